@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bufio"
 	"flag"
+	"fmt"
+	"io"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -15,8 +18,10 @@ import (
 	"github.com/bytedance/dbatman/config"
 	"github.com/bytedance/dbatman/database/cluster"
 	"github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/metrics"
 	"github.com/bytedance/dbatman/proxy"
 	"github.com/ngaut/log"
+	"golang.org/x/term"
 )
 
 var (
@@ -44,8 +49,43 @@ func getCurrentDir() string {
 	return path1
 }
 
+// passwd prints the hash of the password to set in the config instead of the
+// password, it is read from stdin if not given in args, without echo on a
+// terminal
+func passwd(args []string) int {
+	var password string
+	if len(args) > 0 {
+		password = args[0]
+	} else if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		// the password typed is not echoed
+		fmt.Fprint(os.Stderr, "Password: ")
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		password = string(b)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	fmt.Println(proxy.HashPassword(password))
+	return 0
+}
+
 func main() {
 
+	// dbatman passwd [password]
+	if len(os.Args) > 1 && os.Args[1] == "passwd" {
+		os.Exit(passwd(os.Args[2:]))
+	}
+
 	// runtime  and env config
 	flag.Parse()                         //parse tue input argument
 	runtime.GOMAXPROCS(runtime.NumCPU()) // set max proces
@@ -101,9 +141,13 @@ func main() {
 		os.Exit(1)
 	}
 
-	//port for go pprof Debug
+	//port for go pprof Debug and prometheus metrics
+	http.Handle("/metrics", metrics.Handler())
 	go func() {
-		http.ListenAndServe(":11888", nil)
+		httpPort := cfg.GetConfig().Global.HttpPort
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", httpPort), nil); err != nil {
+			log.Warnf("http server at [%d] error: %s", httpPort, err.Error())
+		}
 	}()
 
 	go func() {
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -27,8 +28,14 @@ type ProxyConfig struct {
 }
 
 type GlobalConfig struct {
-	Port              int
+	Port int
+	// the unix socket listened as well as the port, or instead of it if the
+	// port is 0
+	Socket            string   `yaml:"socket"`
 	ManagePort        int      `yaml:"manage_port"`
+	ManageUser        string   `yaml:"manage_user"`
+	ManagePassword    string   `yaml:"manage_password"`
+	HttpPort          int      `yaml:"http_port"`
 	MaxConnections    int      `yaml:"max_connections"`
 	LogFilename       string   `yaml:"log_filename"`
 	LogLevel          int      `yaml:"log_level"`
@@ -41,11 +48,86 @@ type GlobalConfig struct {
 	ReqRate           int64    `yaml:"rate"`
 	ReqBurst          int64    `yaml:"burst"`
 	AuthIPs           []string `yaml:"auth_ips,omitempty"`
+
+	// the clients rejected whatever authip_active is, in CIDR or IP as
+	// auth_ips, they win over auth_ips
+	BlackListIPs []string `yaml:"black_list_ips,omitempty"`
+
+	// flow control, rate and burst above are the server-wide limit
+	LimitActive       bool           `yaml:"limit_active"`
+	FingerprintRate   int64          `yaml:"fingerprint_rate"`
+	FingerprintBurst  int64          `yaml:"fingerprint_burst"`
+	FingerprintLimits []*LimitConfig `yaml:"fingerprint_limits,omitempty"`
+	LimitQueueSize    int64          `yaml:"limit_queue_size"`
+	LimitWaitTimeout  int            `yaml:"limit_wait_timeout"`
+
+	// TLS of the client connections, enabled if the cert and key are set,
+	// see ServerTLSConfig
+	TLSCert         string `yaml:"tls_cert"`
+	TLSKey          string `yaml:"tls_key"`
+	TLSCA           string `yaml:"tls_ca"`
+	RequireTLS      bool   `yaml:"require_tls"`
+	TLSVerifyClient bool   `yaml:"tls_verify_client"`
+
+	// the auth plugin announced to the clients, mysql_native_password by
+	// default or caching_sha2_password. rsa_private_key is the PEM key the
+	// caching_sha2_password clients without TLS encrypt the password with,
+	// a key is generated on start if not set.
+	AuthPlugin    string `yaml:"auth_plugin"`
+	RSAPrivateKey string `yaml:"rsa_private_key"`
+
+	// the load balancers allowed to send the PROXY protocol header before
+	// the handshake, in CIDR or IP, see ParseNetworks
+	ProxyProtocolNetworks []string `yaml:"proxy_protocol_networks,omitempty"`
+
+	// the firewall rules of all the users, checked before the ones of the
+	// user, see FirewallRule
+	Firewall []*FirewallRule `yaml:"firewall,omitempty"`
+}
+
+// LimitConfig is the rate limit of the queries with the same fingerprint,
+// Fingerprint could be any query of the kind, it is fingerprinted on load.
+type LimitConfig struct {
+	Fingerprint string `yaml:"fingerprint"`
+	Rate        int64  `yaml:"rate"`
+	Burst       int64  `yaml:"burst"`
 }
 
+// FirewallRule denies or allows the queries of the statement classes, on the
+// tables and of the fingerprints given, each of them matches any query if
+// not given. A table is name or db.name, db.* for all the tables of db.
+// Fingerprints could be any query of the kind as LimitConfig.
+type FirewallRule struct {
+	Action       string   `yaml:"action"`
+	Statements   []string `yaml:"statements,omitempty"`
+	Tables       []string `yaml:"tables,omitempty"`
+	Fingerprints []string `yaml:"fingerprints,omitempty"`
+}
+
+// the actions and the statement classes of the firewall rules
+var (
+	FirewallActions    = []string{"allow", "deny"}
+	FirewallStatements = []string{
+		"select", "insert", "update", "delete", "replace", "set", "begin",
+		"commit", "rollback", "show", "ddl", "use", "call", "describe", "other",
+		"create", "alter", "drop", "truncate", "rename", "grant",
+	}
+)
+
 type ClusterConfig struct {
 	Master *NodeConfig
 	Slaves []*NodeConfig
+	// the load balancing of the slaves: least_conn (default), wrr, random
+	// or latency
+	Balance string `yaml:"balance"`
+	// promote a candidate slave when the master failed failover_threshold
+	// consecutive heartbeats: off (default), auto, or manual which waits
+	// for FAILOVER CLUSTER on the manage port
+	Failover          string `yaml:"failover"`
+	FailoverThreshold int    `yaml:"failover_threshold"`
+	// the consecutive successful heartbeats a slave cut down needs to be
+	// put back in rotation, 3 by default
+	RecoverThreshold int `yaml:"recover_threshold"`
 }
 
 type NodeConfig struct {
@@ -61,6 +143,18 @@ type NodeConfig struct {
 	MaxConnectionPoolSize int `yaml:"max_connection_pool_size"`
 	ConnectTimeout        int `yaml:"connect_timeout"`
 	TimeReconnectInterval int `yaml:"time_reconnect_interval"`
+	// the seconds a slave could be behind the master before taken out of
+	// rotation, 0 means the lag is not checked
+	MaxReplicationLag int `yaml:"max_replication_lag"`
+	// the slave could be promoted to master on failover
+	Candidate bool `yaml:"candidate"`
+	// use the compressed protocol to the node if it supports it, useful
+	// for the remote nodes
+	Compress bool `yaml:"compress"`
+	// the file or the environment variable the password is read from
+	// instead of password, see resolvePassword
+	PasswordFile string `yaml:"password_file"`
+	PasswordEnv  string `yaml:"password_env"`
 }
 
 type UserConfig struct {
@@ -73,6 +167,13 @@ type UserConfig struct {
 	ClusterName    string   `yaml:"cluster_name"`
 	AuthIPs        []string `yaml:"auth_ips,omitempty"`
 	BlackListIPs   []string `yaml:"black_list_ips,omitempty"`
+	ReqRate        int64    `yaml:"rate"`
+	ReqBurst       int64    `yaml:"burst"`
+	// the firewall rules of the user, see FirewallRule
+	Firewall []*FirewallRule `yaml:"firewall,omitempty"`
+	// reject the UPDATE and DELETE without WHERE or LIMIT as
+	// sql_safe_updates of MySQL
+	SafeUpdates bool `yaml:"safe_updates"`
 }
 
 func (p *ProxyConfig) GetAllClusters() (map[string]*ClusterConfig, error) {
@@ -168,6 +269,8 @@ func (c *Conf) parseConfigFile(proxyConfig *ProxyConfig) error {
 		if err == nil {
 			if !validateConfig(proxyConfig) {
 				err = fmt.Errorf("config is invalidate")
+			} else {
+				err = resolvePasswords(proxyConfig)
 			}
 		}
 
@@ -193,21 +296,15 @@ func (c *Conf) CheckConfigUpdate(notifyChans ...chan bool) {
 				log.Errorf("CheckConfigUpdate error %s", err.Error())
 				continue
 			}
-			if c.lastModifiedTime.Before(fileinfo.ModTime()) {
+			c.mu.RLock()
+			lastModifiedTime := c.lastModifiedTime
+			c.mu.RUnlock()
+			if lastModifiedTime.Before(fileinfo.ModTime()) {
 				log.Infof("CheckConfigUpdate config change and load new config")
-				defaultProxyConfig := getDefaultProxyConfig()
-				err = c.parseConfigFile(defaultProxyConfig)
-				if err != nil {
+				if err = c.reload(fileinfo.ModTime()); err != nil {
 					log.Errorf("CheckConfigUpdate error %s", err.Error())
 					continue
 				}
-				c.lastModifiedTime = fileinfo.ModTime()
-				//goroutine need mutex lock
-				c.mu.Lock()
-				c.proxyConfig = defaultProxyConfig
-				c.mu.Unlock()
-				//modify the log level when update
-				log.SetLevel(log.LogLevel(conf.proxyConfig.Global.LogLevel))
 
 				for _, notifyChan := range notifyChans {
 					notifyChan <- true
@@ -218,6 +315,35 @@ func (c *Conf) CheckConfigUpdate(notifyChans ...chan bool) {
 	}
 }
 
+// Reload reads the config file again and replaces the current config even
+// if the file is not modified, the caller should notify the modules which
+// depend on the config.
+func (c *Conf) Reload() error {
+	fileinfo, err := os.Stat(c.path)
+	if err != nil {
+		return err
+	}
+
+	return c.reload(fileinfo.ModTime())
+}
+
+func (c *Conf) reload(modifiedTime time.Time) error {
+	defaultProxyConfig := getDefaultProxyConfig()
+	if err := c.parseConfigFile(defaultProxyConfig); err != nil {
+		return err
+	}
+
+	//goroutine need mutex lock
+	c.mu.Lock()
+	c.lastModifiedTime = modifiedTime
+	c.proxyConfig = defaultProxyConfig
+	c.mu.Unlock()
+
+	//modify the log level when update
+	log.SetLevel(log.LogLevel(defaultProxyConfig.Global.LogLevel))
+	return nil
+}
+
 func LoadConfig(path string) (*Conf, error) {
 	fileinfo, err := os.Stat(path)
 	if err != nil {
@@ -241,6 +367,11 @@ func validateConfig(cfg *ProxyConfig) bool {
 		return false
 	}
 
+	if cfg.Global != nil && cfg.Global.Port <= 0 && cfg.Global.Socket == "" {
+		log.Errorf("ValidateConfig neither port nor socket is set")
+		return false
+	}
+
 	if len(cfg.Clusters) == 0 {
 		log.Errorf("ValidateConfig 0 cluster")
 		return false
@@ -251,12 +382,38 @@ func validateConfig(cfg *ProxyConfig) bool {
 		return false
 	}
 
+	if cfg.Global != nil {
+		for _, ips := range [][]string{cfg.Global.AuthIPs, cfg.Global.BlackListIPs} {
+			if _, err := ParseNetworks(ips); err != nil {
+				log.Errorf("ValidateConfig global auth_ips or black_list_ips error %s", err.Error())
+				return false
+			}
+		}
+
+		if err := validateFirewall(cfg.Global.Firewall); err != nil {
+			log.Errorf("ValidateConfig global firewall error %s", err.Error())
+			return false
+		}
+	}
+
 	for username, user := range cfg.Users {
 		clusterName := user.ClusterName
 		if _, ok := cfg.Clusters[clusterName]; !ok {
 			log.Errorf("ValidateConfig cluster %s belong to user %s do not exist", clusterName, username)
 			return false
 		}
+
+		for _, ips := range [][]string{user.AuthIPs, user.BlackListIPs} {
+			if _, err := ParseNetworks(ips); err != nil {
+				log.Errorf("ValidateConfig user %s auth_ips or black_list_ips error %s", username, err.Error())
+				return false
+			}
+		}
+
+		if err := validateFirewall(user.Firewall); err != nil {
+			log.Errorf("ValidateConfig user %s firewall error %s", username, err.Error())
+			return false
+		}
 	}
 
 	for clusterName, cluster := range cfg.Clusters {
@@ -284,11 +441,35 @@ func validateConfig(cfg *ProxyConfig) bool {
 	return true
 }
 
+func validateFirewall(rules []*FirewallRule) error {
+	for i, rule := range rules {
+		if !containsFold(FirewallActions, rule.Action) {
+			return fmt.Errorf("rule %d unknown action %s", i, rule.Action)
+		}
+		for _, s := range rule.Statements {
+			if !containsFold(FirewallStatements, s) {
+				return fmt.Errorf("rule %d unknown statement %s", i, s)
+			}
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
 func getDefaultProxyConfig() *ProxyConfig {
 	cfg := ProxyConfig{
 		Global: &GlobalConfig{
 			Port:              3306,
 			ManagePort:        3307,
+			HttpPort:          11888,
 			MaxConnections:    2000,
 			LogLevel:          1,
 			LogFilename:       "./log/dbatman.log",
@@ -2,6 +2,9 @@ package config
 
 import (
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
 	"reflect"
 	"testing"
 )
@@ -16,7 +19,10 @@ func TestConfig(t *testing.T) {
 	globalConfig := GlobalConfig{
 		Port:              3306,
 		ManagePort:        3307,
-		MaxConnections:    10,
+		ManageUser:        "admin",
+		ManagePassword:    "admin",
+		HttpPort:          11888,
+		MaxConnections:    0,
 		LogLevel:          1,
 		LogFilename:       "./log/dbatman.log",
 		LogMaxSize:        1024,
@@ -28,6 +34,15 @@ func TestConfig(t *testing.T) {
 		ReqRate:           1000,
 		ReqBurst:          2000,
 		AuthIPs:           []string{"10.4.64.1", "10.4.64.2"},
+		LimitActive:       false,
+		FingerprintRate:   500,
+		FingerprintBurst:  1000,
+		FingerprintLimits: []*LimitConfig{
+			{Fingerprint: "select * from pgc_item where id = 1", Rate: 100, Burst: 100},
+		},
+		LimitQueueSize:   100,
+		LimitWaitTimeout: 50,
+		AuthPlugin:       "mysql_native_password",
 	}
 
 	masterNode := NodeConfig{
@@ -73,3 +88,85 @@ func TestConfig(t *testing.T) {
 		t.Fatal("user must equal")
 	}
 }
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks([]string{"10.4.0.0/16", "10.1.1.1", "fd00::/8", "::1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		ip       string
+		contains bool
+	}{
+		{"10.4.64.1", true},
+		{"10.5.0.1", false},
+		{"10.1.1.1", true},
+		{"10.1.1.2", false},
+		{"fd12::1", true},
+		{"::1", true},
+		{"::2", false},
+	} {
+		contains := false
+		for _, network := range networks {
+			if network.Contains(net.ParseIP(test.ip)) {
+				contains = true
+			}
+		}
+		if contains != test.contains {
+			t.Fatalf("expect %s in the networks %v", test.ip, test.contains)
+		}
+	}
+
+	for _, invalid := range []string{"10.4.0.0/33", "10.4.0", "localhost"} {
+		if _, err := ParseNetworks([]string{invalid}); err == nil {
+			t.Fatalf("expect error of %s", invalid)
+		}
+	}
+}
+
+func TestResolvePasswords(t *testing.T) {
+	f, err := ioutil.TempFile("", "dbatman_password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("from_file\n")
+	f.Close()
+
+	os.Setenv("DBATMAN_TEST_PASSWORD", "from_env")
+	defer os.Unsetenv("DBATMAN_TEST_PASSWORD")
+
+	cfg := &ProxyConfig{
+		Clusters: map[string]*ClusterConfig{
+			"test": {
+				Master: &NodeConfig{PasswordFile: f.Name()},
+				Slaves: []*NodeConfig{
+					{PasswordEnv: "DBATMAN_TEST_PASSWORD"},
+					{Password: "inline"},
+				},
+			},
+		},
+	}
+	if err := resolvePasswords(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cluster := cfg.Clusters["test"]
+	for i, node := range []*NodeConfig{cluster.Master, cluster.Slaves[0], cluster.Slaves[1]} {
+		if expect := []string{"from_file", "from_env", "inline"}[i]; node.Password != expect {
+			t.Fatalf("%d: expect password %s, got %s", i, expect, node.Password)
+		}
+	}
+
+	for _, node := range []*NodeConfig{
+		{Password: "inline", PasswordEnv: "DBATMAN_TEST_PASSWORD"},
+		{PasswordFile: f.Name(), PasswordEnv: "DBATMAN_TEST_PASSWORD"},
+		{PasswordFile: f.Name() + ".not_exists"},
+		{PasswordEnv: "DBATMAN_TEST_PASSWORD_NOT_SET"},
+	} {
+		if err := node.resolvePassword(); err == nil {
+			t.Fatalf("expect error of %+v", node)
+		}
+	}
+}
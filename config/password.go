@@ -0,0 +1,50 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// resolvePasswords reads the passwords of the nodes given by password_file or
+// password_env, the config file could be shared without the credentials of
+// the backends then.
+func resolvePasswords(cfg *ProxyConfig) error {
+	for clusterName, cluster := range cfg.Clusters {
+		nodes := append([]*NodeConfig{cluster.Master}, cluster.Slaves...)
+		for _, node := range nodes {
+			if err := node.resolvePassword(); err != nil {
+				return fmt.Errorf("cluster %s node %s:%d %s", clusterName, node.Host, node.Port, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// resolvePassword sets the password read from password_file, the trailing
+// newline is trimmed, or from password_env
+func (nc *NodeConfig) resolvePassword() error {
+	if nc.PasswordFile == "" && nc.PasswordEnv == "" {
+		return nil
+	}
+	if nc.Password != "" || (nc.PasswordFile != "" && nc.PasswordEnv != "") {
+		return fmt.Errorf("only one of password, password_file and password_env could be set")
+	}
+
+	if nc.PasswordFile != "" {
+		data, err := ioutil.ReadFile(nc.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("read password_file error: %s", err.Error())
+		}
+		nc.Password = strings.TrimRight(string(data), "\r\n")
+		return nil
+	}
+
+	password, ok := os.LookupEnv(nc.PasswordEnv)
+	if !ok {
+		return fmt.Errorf("password_env %s is not set", nc.PasswordEnv)
+	}
+	nc.Password = password
+	return nil
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+)
+
+// ServerTLSConfig returns the TLS config of the client connections, nil if
+// tls_cert and tls_key are not set. The client certs are verified against
+// tls_ca if given, and required if tls_verify_client is set.
+func (gc *GlobalConfig) ServerTLSConfig() (*tls.Config, error) {
+	if gc.TLSCert == "" && gc.TLSKey == "" {
+		if gc.RequireTLS || gc.TLSVerifyClient {
+			return nil, fmt.Errorf("require_tls and tls_verify_client need tls_cert and tls_key")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(gc.TLSCert, gc.TLSKey)
+	if err != nil {
+		return nil, fmt.Errorf("load tls_cert and tls_key error: %s", err.Error())
+	}
+
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if gc.TLSCA != "" {
+		pem, err := ioutil.ReadFile(gc.TLSCA)
+		if err != nil {
+			return nil, fmt.Errorf("read tls_ca error: %s", err.Error())
+		}
+
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no cert found in tls_ca %s", gc.TLSCA)
+		}
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if gc.TLSVerifyClient {
+		if cfg.ClientCAs == nil {
+			return nil, fmt.Errorf("tls_verify_client needs tls_ca")
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return cfg, nil
+}
+
+// ServerRSAKey returns the key of rsa_private_key, a 2048 bits key is
+// generated if it is not set.
+func (gc *GlobalConfig) ServerRSAKey() (*rsa.PrivateKey, error) {
+	if gc.RSAPrivateKey == "" {
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	data, err := ioutil.ReadFile(gc.RSAPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("read rsa_private_key error: %s", err.Error())
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM key found in rsa_private_key %s", gc.RSAPrivateKey)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse rsa_private_key error: %s", err.Error())
+	}
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return rsaKey, nil
+	}
+	return nil, fmt.Errorf("rsa_private_key %s is not a RSA key", gc.RSAPrivateKey)
+}
@@ -0,0 +1,172 @@
+package cluster
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/bytedance/dbatman/database/mysql"
+)
+
+// Balancer picks the slave to serve a read
+type Balancer interface {
+	// Pick returns one of the nodes, the nodes are alive and not lagging
+	// behind the master, there is at least one node.
+	Pick(nodes []*mysql.DB) *mysql.DB
+}
+
+const (
+	BalanceLeastConn          = "least_conn"
+	BalanceWeightedRoundRobin = "wrr"
+	BalanceRandom             = "random"
+	BalanceLatency            = "latency"
+)
+
+// NewBalancer returns the balancer of the strategy, least_conn is used if
+// the strategy is empty.
+func NewBalancer(strategy string) (Balancer, error) {
+	switch strategy {
+	case "", BalanceLeastConn:
+		return leastConnBalancer{}, nil
+	case BalanceWeightedRoundRobin:
+		return newWrrBalancer(), nil
+	case BalanceRandom:
+		return newRandomBalancer(), nil
+	case BalanceLatency:
+		return newLatencyBalancer(), nil
+	}
+
+	return nil, fmt.Errorf("unknown balance strategy %s", strategy)
+}
+
+// leastConnBalancer picks the node with the most free connections, or the
+// least open connections if they have the same free connections. Both are
+// scaled by the weight, so a heavier node looks less busy.
+type leastConnBalancer struct{}
+
+func (leastConnBalancer) Pick(nodes []*mysql.DB) *mysql.DB {
+	var (
+		ret                  *mysql.DB
+		freeConns, openConns float64
+	)
+
+	for _, db := range nodes {
+		stats := db.Stats()
+		w := float64(stats.Weight)
+		free, open := float64(stats.FreeConnections)*w, float64(stats.OpenConnections)/w
+
+		if ret == nil || free > freeConns || (free == freeConns && open < openConns) {
+			ret, freeConns, openConns = db, free, open
+		}
+	}
+
+	return ret
+}
+
+// wrrBalancer is the smooth weighted round-robin of nginx, the nodes are
+// picked in proportion to their weights and evenly interleaved.
+type wrrBalancer struct {
+	mu      sync.Mutex
+	current map[*mysql.DB]int64
+}
+
+func newWrrBalancer() *wrrBalancer {
+	return &wrrBalancer{current: make(map[*mysql.DB]int64)}
+}
+
+func (b *wrrBalancer) Pick(nodes []*mysql.DB) *mysql.DB {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ret *mysql.DB
+	var total int64
+
+	alive := make(map[*mysql.DB]bool, len(nodes))
+	for _, db := range nodes {
+		w := db.Weight()
+		total += w
+		b.current[db] += w
+		if ret == nil || b.current[db] > b.current[ret] {
+			ret = db
+		}
+		alive[db] = true
+	}
+
+	// forget the nodes out of rotation, they start over when come back
+	for db := range b.current {
+		if !alive[db] {
+			delete(b.current, db)
+		}
+	}
+
+	b.current[ret] -= total
+	return ret
+}
+
+// randomBalancer picks a node randomly in proportion to the weights
+type randomBalancer struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newRandomBalancer() *randomBalancer {
+	return &randomBalancer{rnd: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (b *randomBalancer) float64() float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.rnd.Float64()
+}
+
+func (b *randomBalancer) Pick(nodes []*mysql.DB) *mysql.DB {
+	var total int64
+	for _, db := range nodes {
+		total += db.Weight()
+	}
+
+	b.mu.Lock()
+	n := b.rnd.Int63n(total)
+	b.mu.Unlock()
+
+	for _, db := range nodes {
+		if n -= db.Weight(); n < 0 {
+			return db
+		}
+	}
+	return nodes[len(nodes)-1]
+}
+
+// latencyExplore is the chance a latency balancer picks a random node
+const latencyExplore = 0.05
+
+// latencyBalancer picks the node with the lowest moving average of latency
+// divided by the weight. Nodes never queried are picked first to get their
+// latency measured, and a small share of reads goes to random nodes so the
+// latency of a slow node is updated after it recovers.
+type latencyBalancer struct {
+	random *randomBalancer
+}
+
+func newLatencyBalancer() *latencyBalancer {
+	return &latencyBalancer{newRandomBalancer()}
+}
+
+func (b *latencyBalancer) Pick(nodes []*mysql.DB) *mysql.DB {
+	if b.random.float64() < latencyExplore {
+		return b.random.Pick(nodes)
+	}
+
+	var ret *mysql.DB
+	var score float64
+
+	for _, db := range nodes {
+		s := float64(db.Latency()) / float64(db.Weight())
+		if ret == nil || s < score {
+			ret, score = db, s
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bytedance/dbatman/database/mysql"
+)
+
+// newTestNodes opens the dbs with the weights, no connection is made
+func newTestNodes(t *testing.T, weights ...int) []*mysql.DB {
+	var nodes []*mysql.DB
+	for i, w := range weights {
+		db, err := mysql.Open("dbatman", fmt.Sprintf("root:@tcp(127.0.0.1:%d)/test", 20000+i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.SetWeight(w)
+		nodes = append(nodes, db)
+	}
+	return nodes
+}
+
+func countPicks(b Balancer, nodes []*mysql.DB, n int) map[*mysql.DB]int {
+	counts := make(map[*mysql.DB]int)
+	for i := 0; i < n; i++ {
+		counts[b.Pick(nodes)]++
+	}
+	return counts
+}
+
+func TestBalancer_Unknown(t *testing.T) {
+	if _, err := NewBalancer("nothing"); err == nil {
+		t.Fatal("expect error for unknown balance strategy")
+	}
+
+	if b, err := NewBalancer(""); err != nil {
+		t.Fatal(err)
+	} else if _, ok := b.(leastConnBalancer); !ok {
+		t.Fatalf("expect least_conn by default, got %T", b)
+	}
+}
+
+func TestBalancer_WeightedRoundRobin(t *testing.T) {
+	nodes := newTestNodes(t, 1, 3, 0)
+	b, _ := NewBalancer(BalanceWeightedRoundRobin)
+
+	counts := countPicks(b, nodes, 500)
+	if counts[nodes[0]] != 100 || counts[nodes[1]] != 300 || counts[nodes[2]] != 100 {
+		t.Fatalf("expect picks 100/300/100, got %d/%d/%d", counts[nodes[0]], counts[nodes[1]], counts[nodes[2]])
+	}
+
+	// the heavy node is interleaved with the others
+	if b.Pick(nodes) == nodes[1] && b.Pick(nodes) == nodes[1] && b.Pick(nodes) == nodes[1] {
+		t.Fatal("expect smooth weighted round-robin")
+	}
+}
+
+func TestBalancer_Random(t *testing.T) {
+	nodes := newTestNodes(t, 1, 9)
+	b, _ := NewBalancer(BalanceRandom)
+
+	counts := countPicks(b, nodes, 10000)
+	if c := counts[nodes[1]]; c < 8500 || c > 9500 {
+		t.Fatalf("expect about 9000 picks of the heavy node, got %d", c)
+	}
+}
+
+func TestBalancer_Latency(t *testing.T) {
+	nodes := newTestNodes(t, 1, 1)
+	b, _ := NewBalancer(BalanceLatency)
+
+	nodes[0].ObserveLatency(10 * time.Millisecond)
+	nodes[1].ObserveLatency(time.Millisecond)
+
+	counts := countPicks(b, nodes, 1000)
+	if c := counts[nodes[1]]; c < 900 {
+		t.Fatalf("expect most picks of the fast node, got %d", c)
+	}
+	if c := counts[nodes[0]]; c == 0 {
+		t.Fatal("expect the slow node explored")
+	}
+}
+
+func TestBalancer_LeastConn(t *testing.T) {
+	nodes := newTestNodes(t, 1, 1)
+	b, _ := NewBalancer(BalanceLeastConn)
+
+	if db := b.Pick(nodes[1:]); db != nodes[1] {
+		t.Fatal("expect the only node picked")
+	}
+}
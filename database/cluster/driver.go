@@ -2,6 +2,7 @@ package cluster
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -15,15 +16,40 @@ var (
 	clusterConns          = make(map[string]*Cluster)
 	cfgHandler            *config.Conf
 	currentClusterVersion = 1
-	NotifyChan            = make(chan bool)
+	NotifyChan            = make(chan bool, 1) // one reload pending is enough
 )
 
 type Cluster struct {
 	masterNode *mysql.DB
 	slaveNodes map[string]*mysql.DB
+	balancer   Balancer
 	cluserName string
 	DBName     string
 	version    int
+
+	// failover of the master, see failover.go
+	failover          string
+	failoverThreshold int
+	candidates        map[string]bool
+	failedMaster      string // dsn of the master replaced by failover
+
+	failoverMu     sync.Mutex
+	masterFailures int
+
+	// recovery of the slaves cut down, see recovery.go
+	recoverThreshold int
+	recoveryMu       sync.Mutex
+	recovery         map[*mysql.DB]*nodeRecovery
+
+	// set while the pools are pre-warmed, see warm.go
+	warming int32
+}
+
+// NodeStats is the pool status of a node in the cluster
+type NodeStats struct {
+	Role string
+	Addr string
+	mysql.DBStats
 }
 
 type CrashDb struct {
@@ -33,10 +59,13 @@ type CrashDb struct {
 }
 
 func (c *Cluster) Master() (*mysql.DB, error) {
-	if c.masterNode == nil {
+	clustersMu.RLock()
+	db := c.masterNode
+	clustersMu.RUnlock()
+
+	if db == nil {
 		return nil, fmt.Errorf("MasterConn error c.masterNode==nil")
 	}
-	db := c.masterNode
 	stats := db.Stats()
 	if stats.OpenConnections == 0 {
 		// set connection using Ping
@@ -52,37 +81,27 @@ func (c *Cluster) Master() (*mysql.DB, error) {
 }
 
 func (c *Cluster) Slave() (*mysql.DB, error) {
-
-	//	if len(c.slaveNodes) == 0 {
-	//		log.Warnf("Slave no exsits, use master in instead")
-	//		return c.Master()
-	//	}
-
-	var (
-		freeConnections int    = -1
-		openConnections int    = -1
-		serviceSlaveKey string = ""
-	)
-
-	//load balance mechanism
-	for key, db := range c.slaveNodes {
-		if db != nil {
-			stats := db.Stats()
-			if stats.AliveStatus == true { // only alive db can server
-				if stats.FreeConnections > freeConnections ||
-					(stats.FreeConnections == freeConnections && stats.OpenConnections < openConnections) {
-					freeConnections, openConnections = stats.FreeConnections, stats.OpenConnections
-					serviceSlaveKey = key
-				}
-			}
+	clustersMu.RLock()
+	nodes := make([]*mysql.DB, 0, len(c.slaveNodes))
+	for _, db := range c.slaveNodes {
+		// only alive db not far behind the master can server
+		if db != nil && db.GetDbAliveStatus() && !db.Lagging() {
+			nodes = append(nodes, db)
 		}
 	}
-	if serviceSlaveKey == "" { //there is no slave exist user master instead
+	balancer := c.balancer
+	clustersMu.RUnlock()
+
+	if len(nodes) == 0 { //there is no slave exist user master instead
 		log.Warnf("Slave no exsits, use master in instead")
 		return c.Master()
 	}
-	db := c.slaveNodes[serviceSlaveKey]
-	if openConnections == 0 {
+
+	// the map is iterated randomly, keep the order for the balancer
+	sort.Sort(dbsByDsn(nodes))
+
+	db := balancer.Pick(nodes)
+	if db.Stats().OpenConnections == 0 {
 		err := makeConnection(db)
 		if err != nil {
 			return nil, err
@@ -91,6 +110,12 @@ func (c *Cluster) Slave() (*mysql.DB, error) {
 	return db, nil
 }
 
+type dbsByDsn []*mysql.DB
+
+func (s dbsByDsn) Len() int           { return len(s) }
+func (s dbsByDsn) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s dbsByDsn) Less(i, j int) bool { return s[i].Dsn() < s[j].Dsn() }
+
 //TODO HeartBeat test for each node
 /*
 Problem the ping use the conn pool to ping the db
@@ -103,13 +128,15 @@ func DisasterControl() error {
 			err := fmt.Errorf("There is no cluster init")
 			return err
 		}
-		for _, c := range clusterConns {
+		for _, c := range Clusters() {
 			//	log.Info("HeartBeart test the db healthy of clusters:", name)
 			crashDb, err := c.HeartBeat()
 			if err != nil {
 				//return err
 				log.Debug(err)
+				continue
 			}
+			c.checkMaster(crashDb.masterNode == nil)
 			if crashDb.crashNum != 0 {
 				//TODO add the Manager module to contorle the diseaster
 
@@ -119,9 +146,7 @@ func DisasterControl() error {
 				if len(crashDb.slaveNode) != 0 {
 					//TODO  INFO the manager module that the slave node has been cut down
 					for _, db := range crashDb.slaveNode {
-						db.SetDbAliveStatus(false)
-						log.Debug("db disconnect ", db.Dsn())
-						log.Info("cut down the db:", db.Dsn())
+						c.cutDown(db)
 					}
 
 				}
@@ -133,22 +158,28 @@ func DisasterControl() error {
 	return nil
 }
 func (c *Cluster) HeartBeat() (*CrashDb, error) {
-	if c.masterNode == nil {
+	//get all the cluster cfg
+	clustersMu.RLock()
+	masterDb := c.masterNode
+	slaveDbs := make([]*mysql.DB, 0, len(c.slaveNodes))
+	for _, db := range c.slaveNodes {
+		slaveDbs = append(slaveDbs, db)
+	}
+	clustersMu.RUnlock()
+
+	if masterDb == nil {
 		log.Info("master node did not exists")
 		err := fmt.Errorf("config is nil")
 		return nil, err
 	}
 	ret := &CrashDb{crashNum: 0, masterNode: nil}
 
-	//get all the cluster cfg
-	masterDb := c.masterNode
-	slaveDbs := c.slaveNodes
-
 	err := masterDb.HeartBeatPing() //HeartBeatPing or Ping use single conn or user conn from conn pools
 	if err != nil {
 		// log.Warn("db ping error ,", err.Error())
 		ret.crashNum++
 		ret.masterNode = masterDb
+		heartbeatFailures.WithLabelValues(c.cluserName, "master", nodeAddr(masterDb)).Inc()
 	}
 
 	for _, slavedb := range slaveDbs {
@@ -159,12 +190,46 @@ func (c *Cluster) HeartBeat() (*CrashDb, error) {
 				ret.crashNum++
 				// log.Warn("db ping error ,", err.Error())
 				ret.slaveNode = append(ret.slaveNode, slavedb)
+				heartbeatFailures.WithLabelValues(c.cluserName, "slave", nodeAddr(slavedb)).Inc()
+			} else {
+				c.checkReplicationLag(slavedb)
 			}
+		} else {
+			c.recoverNode(slavedb, time.Now())
 		}
 	}
+	c.pruneRecovery(slaveDbs)
 
 	return ret, nil
 }
+
+// checkReplicationLag takes the slave out of rotation when it is behind the
+// master more than its max_replication_lag, and puts it back once caught up
+func (c *Cluster) checkReplicationLag(slavedb *mysql.DB) {
+	max := slavedb.MaxReplicationLag()
+	if max <= 0 {
+		return
+	}
+
+	lagging := slavedb.Lagging()
+	lag, err := slavedb.CheckReplicationLag()
+	if err != nil {
+		log.Debugf("cluster %s slave %s check replication lag error: %s", c.cluserName, nodeAddr(slavedb), err.Error())
+	}
+
+	if slavedb.Lagging() == lagging {
+		return
+	}
+
+	if lagging {
+		log.Infof("cluster %s slave %s caught up with replication lag %ds, put it back", c.cluserName, nodeAddr(slavedb), lag)
+	} else if err != nil {
+		log.Warnf("cluster %s slave %s replication lag unknown: %s, take it out of rotation", c.cluserName, nodeAddr(slavedb), err.Error())
+	} else {
+		log.Warnf("cluster %s slave %s replication lag %ds exceeds %ds, take it out of rotation", c.cluserName, nodeAddr(slavedb), lag, max)
+	}
+}
+
 func (c *Cluster) DB(isread bool) (*mysql.DB, error) {
 	if isread {
 		log.Info("return a master conn")
@@ -175,6 +240,24 @@ func (c *Cluster) DB(isread bool) (*mysql.DB, error) {
 	return c.Slave()
 }
 
+// Node returns the master or slave of the cluster at the addr (host:port)
+func (c *Cluster) Node(addr string) (*mysql.DB, error) {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+
+	if c.masterNode != nil && nodeAddr(c.masterNode) == addr {
+		return c.masterNode, nil
+	}
+
+	for _, db := range c.slaveNodes {
+		if db != nil && nodeAddr(db) == addr {
+			return db, nil
+		}
+	}
+
+	return nil, fmt.Errorf("node %s not exists in cluster %s", addr, c.cluserName)
+}
+
 func (c *Cluster) String() string {
 	masterNodeDsn := c.masterNode.Dsn()
 	slaveNodeDsns := make([]string, 0)
@@ -185,6 +268,63 @@ func (c *Cluster) String() string {
 	return s
 }
 
+func (c *Cluster) Name() string {
+	return c.cluserName
+}
+
+// Stats returns the pool status of the master and slaves of the cluster
+func (c *Cluster) Stats() []NodeStats {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+
+	ret := make([]NodeStats, 0, len(c.slaveNodes)+1)
+	if c.masterNode != nil {
+		ret = append(ret, NodeStats{"master", nodeAddr(c.masterNode), c.masterNode.Stats()})
+	}
+
+	slaves := make([]NodeStats, 0, len(c.slaveNodes))
+	for _, db := range c.slaveNodes {
+		slaves = append(slaves, NodeStats{"slave", nodeAddr(db), db.Stats()})
+	}
+	sort.Sort(nodeStatsByAddr(slaves))
+
+	return append(ret, slaves...)
+}
+
+type nodeStatsByAddr []NodeStats
+
+func (s nodeStatsByAddr) Len() int           { return len(s) }
+func (s nodeStatsByAddr) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s nodeStatsByAddr) Less(i, j int) bool { return s[i].Addr < s[j].Addr }
+
+// Clusters returns all the clusters currently served, ordered by name
+func Clusters() []*Cluster {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+
+	names := make([]string, 0, len(clusterConns))
+	for name := range clusterConns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := make([]*Cluster, 0, len(names))
+	for _, name := range names {
+		ret = append(ret, clusterConns[name])
+	}
+	return ret
+}
+
+// nodeAddr returns the host:port of the db, the dsn can not be shown
+// because it contains the password
+func nodeAddr(db *mysql.DB) string {
+	cfg, err := mysql.ParseDSN(db.Dsn())
+	if err != nil {
+		return ""
+	}
+	return cfg.Addr
+}
+
 func Init(cfg *config.Conf) error {
 	if cfg == nil {
 		err := fmt.Errorf("config is nil")
@@ -206,6 +346,7 @@ func Init(cfg *config.Conf) error {
 		clusterConns[clusterName] = cluster
 	}
 
+	warm()
 	go monitor()
 	return nil
 }
@@ -238,6 +379,9 @@ func probe() error {
 			}
 		}
 	}
+
+	// open the idle connections closed again
+	warm()
 	return nil
 }
 
@@ -269,7 +413,14 @@ func reload() error {
 			newMasterNodeCfg := clusterCfg.GetMasterNode()
 			newMasterNodeDsn := getDsnFromNodeCfg(newMasterNodeCfg)
 			oldMasterNodeDsn := cluster.masterNode.Dsn()
-			if newMasterNodeDsn != oldMasterNodeDsn {
+			if newMasterNodeDsn == oldMasterNodeDsn {
+				// the config caught up with the failover
+				cluster.failedMaster = ""
+			}
+
+			if newMasterNodeDsn != oldMasterNodeDsn && newMasterNodeDsn == cluster.failedMaster {
+				log.Warnf("Cluster reload cluster[%s] keep the master %s promoted by failover, the config master %s is down", clusterName, nodeAddr(cluster.masterNode), newMasterNodeDsn)
+			} else if newMasterNodeDsn != oldMasterNodeDsn {
 				newMasterNode, err := openDBFromNodeCfg(newMasterNodeCfg)
 				if err != nil {
 					log.Errorf("Cluster reload modify cluster[%s] master node error dsn:%s msg:%s", clusterName, newMasterNodeDsn, err.Error())
@@ -282,10 +433,25 @@ func reload() error {
 				setDBProperty(cluster.masterNode, newMasterNodeCfg)
 			}
 
+			if balancer, err := NewBalancer(clusterCfg.Balance); err != nil {
+				log.Errorf("Cluster reload cluster[%s] error msg:%s", clusterName, err.Error())
+			} else {
+				cluster.balancer = balancer
+			}
+
+			if err := cluster.setFailoverConfig(clusterCfg); err != nil {
+				log.Errorf("Cluster reload cluster[%s] error msg:%s", clusterName, err.Error())
+			}
+			cluster.setRecoverThreshold(clusterCfg.RecoverThreshold)
+
 			// slave nodes
 			allSlaveNodeDsns := make(map[string]bool)
 			for _, newSlaveNodeCfg := range clusterCfg.GetSlaveNodes() {
 				newSlaveNodeDsn := getDsnFromNodeCfg(newSlaveNodeCfg)
+				if newSlaveNodeDsn == cluster.masterNode.Dsn() {
+					// promoted to master by failover
+					continue
+				}
 				node, ok := cluster.slaveNodes[newSlaveNodeDsn]
 				if ok {
 					setDBProperty(node, newSlaveNodeCfg)
@@ -344,7 +510,22 @@ func closeClusterDBConns(dbsWaitToBeClosed []*mysql.DB) {
 func makeCluster(clusterName string, clusterCfg *config.ClusterConfig) (*Cluster, error) {
 	master := clusterCfg.GetMasterNode()
 	DBName := master.DBName
-	cluster := Cluster{nil, make(map[string]*mysql.DB), clusterName, DBName, currentClusterVersion}
+	balancer, err := NewBalancer(clusterCfg.Balance)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := &Cluster{
+		slaveNodes: make(map[string]*mysql.DB),
+		balancer:   balancer,
+		cluserName: clusterName,
+		DBName:     DBName,
+		version:    currentClusterVersion,
+	}
+	if err := cluster.setFailoverConfig(clusterCfg); err != nil {
+		return nil, err
+	}
+	cluster.setRecoverThreshold(clusterCfg.RecoverThreshold)
 
 	db, err := openDBFromNodeCfg(master)
 	if err != nil {
@@ -359,7 +540,7 @@ func makeCluster(clusterName string, clusterCfg *config.ClusterConfig) (*Cluster
 		}
 		cluster.slaveNodes[db.Dsn()] = db
 	}
-	return &cluster, nil
+	return cluster, nil
 }
 
 func New(clusterName string) (*Cluster, error) {
@@ -390,6 +571,8 @@ func openDBFromNodeCfg(nodeCfg *config.NodeConfig) (*mysql.DB, error) {
 func setDBProperty(db *mysql.DB, nodeCfg *config.NodeConfig) {
 	db.SetMaxOpenConns(nodeCfg.MaxConnections)
 	db.SetMaxIdleConns(nodeCfg.MaxConnectionPoolSize)
+	db.SetMaxReplicationLag(nodeCfg.MaxReplicationLag)
+	db.SetWeight(nodeCfg.Weight)
 	db.SetDbAliveStatus(true)
 }
 
@@ -399,7 +582,7 @@ func getDsnFromNodeCfg(nodeCfg *config.NodeConfig) string {
 		return ""
 	}
 
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&timeout=%dms",
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=%s&timeout=%dms",
 		nodeCfg.Username,
 		nodeCfg.Password,
 		nodeCfg.Host,
@@ -407,6 +590,12 @@ func getDsnFromNodeCfg(nodeCfg *config.NodeConfig) string {
 		nodeCfg.DBName,
 		nodeCfg.Charset,
 		nodeCfg.ConnectTimeout)
+
+	if nodeCfg.Compress {
+		dsn += "&compress=true"
+	}
+
+	return dsn
 }
 
 func makeConnection(db *mysql.DB) error {
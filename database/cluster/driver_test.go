@@ -0,0 +1,407 @@
+package cluster
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytedance/dbatman/config"
+	"github.com/bytedance/dbatman/database/mysql"
+)
+
+func newTestDB(t *testing.T, s *fakeServer) *mysql.DB {
+	db, err := mysql.Open("dbatman", s.DSN())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetDbAliveStatus(true)
+	return db
+}
+
+func TestCluster_ReplicationLag(t *testing.T) {
+	var lag int64 = 3
+	lagFunc := func() interface{} {
+		if l := atomic.LoadInt64(&lag); l >= 0 {
+			return l
+		}
+		return nil
+	}
+
+	master := newFakeServer(t, nil)
+	defer master.Close()
+	slave := newFakeServer(t, slaveStatus(lagFunc))
+	defer slave.Close()
+
+	masterDB := newTestDB(t, master)
+	defer masterDB.Close()
+	slaveDB := newTestDB(t, slave)
+	defer slaveDB.Close()
+	slaveDB.SetMaxReplicationLag(10)
+
+	c := &Cluster{
+		masterNode: masterDB,
+		slaveNodes: map[string]*mysql.DB{slaveDB.Dsn(): slaveDB},
+		balancer:   leastConnBalancer{},
+		cluserName: "test_cluster",
+	}
+
+	expect := func(lagging bool, lag int64, node *mysql.DB) {
+		c.checkReplicationLag(slaveDB)
+
+		if slaveDB.Lagging() != lagging {
+			t.Fatalf("expect lagging %v, got %v", lagging, slaveDB.Lagging())
+		}
+		if l := slaveDB.ReplicationLag(); l != lag {
+			t.Fatalf("expect replication lag %d, got %d", lag, l)
+		}
+
+		if db, err := c.Slave(); err != nil {
+			t.Fatal(err)
+		} else if db != node {
+			t.Fatalf("expect slave %s, got %s", node.Dsn(), db.Dsn())
+		}
+	}
+
+	expect(false, 3, slaveDB)
+
+	// far behind the master, reads go to the master
+	atomic.StoreInt64(&lag, 60)
+	expect(true, 60, masterDB)
+
+	// caught up, put back
+	atomic.StoreInt64(&lag, 0)
+	expect(false, 0, slaveDB)
+
+	// replication stopped
+	atomic.StoreInt64(&lag, -1)
+	expect(true, -1, masterDB)
+
+	// not checked without max_replication_lag
+	slaveDB.SetMaxReplicationLag(0)
+	if slaveDB.Lagging() {
+		t.Fatal("expect not lagging if the lag is not checked")
+	}
+}
+
+func TestCluster_Failover(t *testing.T) {
+	var mu sync.Mutex
+	var promoted []string
+	caughtUp := true
+
+	// the candidate answers SHOW SLAVE STATUS and records the promotion
+	candidateHandler := func(query string) *fakeResult {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if query != "SHOW SLAVE STATUS" {
+			promoted = append(promoted, query)
+			return nil
+		}
+
+		exec := 120
+		if !caughtUp {
+			exec = 100
+		}
+		return &fakeResult{
+			columns: []string{"Master_Log_File", "Read_Master_Log_Pos", "Relay_Master_Log_File", "Exec_Master_Log_Pos"},
+			rows:    [][]interface{}{{"mysql-bin.000003", 120, "mysql-bin.000003", exec}},
+		}
+	}
+
+	newCluster := func(mode string) (*Cluster, *mysql.DB, *mysql.DB, func()) {
+		master := newFakeServer(t, nil)
+		candidate := newFakeServer(t, candidateHandler)
+		other := newFakeServer(t, slaveStatus(func() interface{} { return 0 }))
+
+		masterDB := newTestDB(t, master)
+		candidateDB := newTestDB(t, candidate)
+		otherDB := newTestDB(t, other)
+
+		c := &Cluster{
+			masterNode: masterDB,
+			slaveNodes: map[string]*mysql.DB{
+				candidateDB.Dsn(): candidateDB,
+				otherDB.Dsn():     otherDB,
+			},
+			balancer:          leastConnBalancer{},
+			cluserName:        "test_cluster",
+			failover:          mode,
+			failoverThreshold: 2,
+			candidates:        map[string]bool{candidateDB.Dsn(): true},
+		}
+
+		return c, masterDB, candidateDB, func() {
+			master.Close()
+			candidate.Close()
+			other.Close()
+			candidateDB.Close()
+			otherDB.Close()
+		}
+	}
+
+	expectMaster := func(c *Cluster, db *mysql.DB) {
+		if m, err := c.Master(); err != nil {
+			t.Fatal(err)
+		} else if m != db {
+			t.Fatalf("expect master %s, got %s", db.Dsn(), m.Dsn())
+		}
+	}
+
+	// manual mode waits for the operator
+	c, masterDB, candidateDB, closeAll := newCluster(FailoverManual)
+	defer closeAll()
+
+	if err := c.Failover(""); err == nil {
+		t.Fatal("expect failover refused while the master is alive")
+	}
+
+	c.checkMaster(false)
+	c.checkMaster(false)
+	c.checkMaster(false)
+	expectMaster(c, masterDB)
+
+	if err := c.Failover(""); err != nil {
+		t.Fatal(err)
+	}
+	expectMaster(c, candidateDB)
+
+	if _, ok := c.slaveNodes[candidateDB.Dsn()]; ok {
+		t.Fatal("expect the promoted slave removed from the slaves")
+	}
+	if c.failedMaster != masterDB.Dsn() {
+		t.Fatalf("expect failed master %s, got %s", masterDB.Dsn(), c.failedMaster)
+	}
+
+	mu.Lock()
+	expect := []string{"STOP SLAVE", "RESET SLAVE ALL", "SET GLOBAL read_only = 0"}
+	if fmt.Sprint(promoted) != fmt.Sprint(expect) {
+		t.Fatalf("expect promotion %v, got %v", expect, promoted)
+	}
+	promoted = nil
+	caughtUp = false
+	mu.Unlock()
+
+	// auto mode does not promote a candidate behind the master
+	c, masterDB, _, closeAll = newCluster(FailoverAuto)
+	defer closeAll()
+
+	c.checkMaster(false)
+	c.checkMaster(false)
+	expectMaster(c, masterDB)
+
+	// the master comes back
+	c.checkMaster(true)
+	if err := c.Failover(""); err == nil {
+		t.Fatal("expect failover refused while the master is alive")
+	}
+
+	// caught up, promoted on reaching the threshold
+	mu.Lock()
+	caughtUp = true
+	mu.Unlock()
+
+	c, _, candidateDB, closeAll = newCluster(FailoverAuto)
+	defer closeAll()
+
+	c.checkMaster(false)
+	c.checkMaster(false)
+	expectMaster(c, candidateDB)
+
+	// off
+	c, _, _, closeAll = newCluster(FailoverOff)
+	defer closeAll()
+
+	c.checkMaster(false)
+	c.checkMaster(false)
+	if err := c.Failover(""); err == nil {
+		t.Fatal("expect failover refused if off")
+	}
+}
+
+func TestCluster_Recovery(t *testing.T) {
+	master := newFakeServer(t, nil)
+	defer master.Close()
+	slave := newFakeServer(t, nil)
+	addr := slave.Addr()
+
+	masterDB := newTestDB(t, master)
+	defer masterDB.Close()
+	slaveDB := newTestDB(t, slave)
+	defer slaveDB.Close()
+
+	c := &Cluster{
+		masterNode:       masterDB,
+		slaveNodes:       map[string]*mysql.DB{slaveDB.Dsn(): slaveDB},
+		balancer:         leastConnBalancer{},
+		cluserName:       "test_cluster",
+		recoverThreshold: 2,
+	}
+
+	slave.Close()
+	c.cutDown(slaveDB)
+
+	expectNext := func(next time.Time) {
+		c.recoveryMu.Lock()
+		defer c.recoveryMu.Unlock()
+		if r := c.recovery[slaveDB]; r == nil || !r.next.Equal(next) {
+			t.Fatalf("expect next probe at %v, got %+v", next, r)
+		}
+	}
+
+	// probed with backoff while down
+	now := time.Now()
+	c.recoverNode(slaveDB, now)
+	expectNext(now.Add(time.Second))
+	c.recoverNode(slaveDB, now.Add(500*time.Millisecond))
+	expectNext(now.Add(time.Second))
+	c.recoverNode(slaveDB, now.Add(time.Second))
+	expectNext(now.Add(3 * time.Second))
+
+	if db, _ := c.Slave(); db != masterDB {
+		t.Fatal("expect reads go to the master while the slave is down")
+	}
+
+	// back after 2 consecutive successes
+	slave = newFakeServerAt(t, addr, nil)
+	defer slave.Close()
+
+	c.recoverNode(slaveDB, now.Add(3*time.Second))
+	if slaveDB.GetDbAliveStatus() {
+		t.Fatal("expect the slave kept out after 1 success")
+	}
+	c.recoverNode(slaveDB, now.Add(3*time.Second))
+	if !slaveDB.GetDbAliveStatus() {
+		t.Fatal("expect the slave put back after 2 successes")
+	}
+
+	if db, _ := c.Slave(); db != slaveDB {
+		t.Fatal("expect reads go to the slave recovered")
+	}
+
+	c.pruneRecovery([]*mysql.DB{slaveDB})
+	if len(c.recovery) != 0 {
+		t.Fatalf("expect recovery state forgotten, got %d", len(c.recovery))
+	}
+}
+
+// TestCluster_SlaveWeight drains a slave by lowering its weight, the
+// sessions pick the slave by Slave for each read so they follow at once.
+func TestCluster_SlaveWeight(t *testing.T) {
+	master := newFakeServer(t, nil)
+	defer master.Close()
+	masterDB := newTestDB(t, master)
+	defer masterDB.Close()
+
+	slaves := make(map[string]*mysql.DB)
+	var dbs []*mysql.DB
+	for i := 0; i < 2; i++ {
+		s := newFakeServer(t, nil)
+		defer s.Close()
+		db := newTestDB(t, s)
+		defer db.Close()
+		db.SetWeight(5)
+		slaves[db.Dsn()] = db
+		dbs = append(dbs, db)
+	}
+
+	c := &Cluster{
+		masterNode: masterDB,
+		slaveNodes: slaves,
+		balancer:   newWrrBalancer(),
+		cluserName: "test_cluster",
+	}
+
+	countPicks := func(n int) map[*mysql.DB]int {
+		counts := make(map[*mysql.DB]int)
+		for i := 0; i < n; i++ {
+			db, err := c.Slave()
+			if err != nil {
+				t.Fatal(err)
+			}
+			counts[db]++
+		}
+		return counts
+	}
+
+	if counts := countPicks(10); counts[dbs[0]] != 5 || counts[dbs[1]] != 5 {
+		t.Fatalf("expect picks 5/5, got %d/%d", counts[dbs[0]], counts[dbs[1]])
+	}
+
+	dbs[0].SetWeight(1)
+	if counts := countPicks(6); counts[dbs[0]] != 1 || counts[dbs[1]] != 5 {
+		t.Fatalf("expect picks 1/5 after the weight lowered, got %d/%d", counts[dbs[0]], counts[dbs[1]])
+	}
+}
+
+func TestCluster_Warm(t *testing.T) {
+	master := newFakeServer(t, nil)
+	defer master.Close()
+	slave := newFakeServer(t, nil)
+	defer slave.Close()
+
+	masterDB := newTestDB(t, master)
+	defer masterDB.Close()
+	masterDB.SetMaxIdleConns(2)
+	slaveDB := newTestDB(t, slave)
+	defer slaveDB.Close()
+	slaveDB.SetMaxIdleConns(5)
+
+	c := &Cluster{
+		masterNode: masterDB,
+		slaveNodes: map[string]*mysql.DB{slaveDB.Dsn(): slaveDB},
+		balancer:   leastConnBalancer{},
+		cluserName: "test_cluster",
+	}
+
+	// bounded by the max idle connections of the master
+	c.warm(3)
+	if stats := masterDB.Stats(); stats.FreeConnections != 2 || stats.OpenConnections != 2 {
+		t.Fatalf("expect 2 idle connections of the master, got %+v", stats)
+	}
+	if stats := slaveDB.Stats(); stats.FreeConnections != 3 || stats.OpenConnections != 3 {
+		t.Fatalf("expect 3 idle connections of the slave, got %+v", stats)
+	}
+
+	// only the connections missing are opened
+	c.warm(4)
+	if stats := slaveDB.Stats(); stats.FreeConnections != 4 || stats.OpenConnections != 4 {
+		t.Fatalf("expect 4 idle connections of the slave, got %+v", stats)
+	}
+
+	// more idle connections than asked already
+	c.warm(2)
+	if stats := slaveDB.Stats(); stats.FreeConnections != 4 || stats.OpenConnections != 4 {
+		t.Fatalf("expect 4 idle connections of the slave, got %+v", stats)
+	}
+
+	// the master has more connections open than the limit, all of them busy
+	var txs []*mysql.Tx
+	for i := 0; i < 2; i++ {
+		tx, err := masterDB.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	masterDB.SetMaxOpenConns(1)
+	c.warm(1)
+	if stats := masterDB.Stats(); stats.FreeConnections != 0 || stats.OpenConnections != 2 {
+		t.Fatalf("expect 2 busy connections of the master, got %+v", stats)
+	}
+	for _, tx := range txs {
+		tx.Rollback(true)
+	}
+
+	mins := minConnections(&config.ProxyConfig{
+		Users: map[string]*config.UserConfig{
+			"a": {ClusterName: "test_cluster", MinConnections: 10},
+			"b": {ClusterName: "test_cluster", MinConnections: 20},
+			"c": {ClusterName: "other_cluster", MinConnections: 5},
+		},
+	})
+	if mins["test_cluster"] != 20 || mins["other_cluster"] != 5 {
+		t.Fatalf("expect the most min_connections of the users, got %v", mins)
+	}
+}
@@ -0,0 +1,209 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/bytedance/dbatman/config"
+	"github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/metrics"
+	"github.com/ngaut/log"
+)
+
+const (
+	FailoverOff    = "off"
+	FailoverAuto   = "auto"
+	FailoverManual = "manual"
+
+	defaultFailoverThreshold = 3
+)
+
+var failovers = metrics.NewCounterVec("dbatman_failovers_total",
+	"Total number of the slaves promoted to master.", "cluster")
+
+// setFailoverConfig applies the failover config of the cluster, it must be
+// called with clustersMu locked or before the cluster is published.
+func (c *Cluster) setFailoverConfig(cfg *config.ClusterConfig) error {
+	switch cfg.Failover {
+	case "", FailoverOff:
+		c.failover = FailoverOff
+	case FailoverAuto, FailoverManual:
+		c.failover = cfg.Failover
+	default:
+		return fmt.Errorf("unknown failover mode %s", cfg.Failover)
+	}
+
+	c.failoverThreshold = cfg.FailoverThreshold
+	if c.failoverThreshold <= 0 {
+		c.failoverThreshold = defaultFailoverThreshold
+	}
+
+	c.candidates = make(map[string]bool)
+	for _, slave := range cfg.GetSlaveNodes() {
+		if slave.Candidate {
+			c.candidates[getDsnFromNodeCfg(slave)] = true
+		}
+	}
+
+	return nil
+}
+
+func (c *Cluster) failoverConfig() (string, int) {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+	return c.failover, c.failoverThreshold
+}
+
+// checkMaster counts the consecutive failed heartbeats of the master. When
+// the threshold is reached, a candidate slave is promoted in auto mode, or
+// the cluster waits for an operator to run FAILOVER CLUSTER in manual mode.
+func (c *Cluster) checkMaster(alive bool) {
+	mode, threshold := c.failoverConfig()
+
+	c.failoverMu.Lock()
+	if alive {
+		if c.masterFailures >= threshold {
+			log.Infof("cluster %s master is alive again after %d failed heartbeats", c.cluserName, c.masterFailures)
+		}
+		c.masterFailures = 0
+		c.failoverMu.Unlock()
+		return
+	}
+
+	c.masterFailures++
+	failures := c.masterFailures
+	c.failoverMu.Unlock()
+
+	if failures < threshold {
+		return
+	}
+
+	switch mode {
+	case FailoverAuto:
+		// retry every threshold heartbeats if no candidate is ready
+		if (failures-threshold)%threshold != 0 {
+			return
+		}
+		if err := c.Failover(""); err != nil {
+			log.Errorf("cluster %s auto failover error: %s", c.cluserName, err.Error())
+		}
+	case FailoverManual:
+		if failures == threshold {
+			log.Warnf("cluster %s master is down, waiting for FAILOVER CLUSTER %s on the manage port", c.cluserName, c.cluserName)
+		}
+	default:
+		if failures == threshold {
+			log.Warnf("cluster %s master is down and failover is off", c.cluserName)
+		}
+	}
+}
+
+// Failover promotes a slave to the master of the cluster. The slave is the
+// one at addr (host:port), or the first caught up candidate if addr is empty.
+// It is refused unless the master failed enough heartbeats.
+func (c *Cluster) Failover(addr string) error {
+	mode, threshold := c.failoverConfig()
+	if mode == FailoverOff {
+		return fmt.Errorf("failover of cluster %s is off", c.cluserName)
+	}
+
+	c.failoverMu.Lock()
+	defer c.failoverMu.Unlock()
+
+	if c.masterFailures < threshold {
+		return fmt.Errorf("master of cluster %s is alive", c.cluserName)
+	}
+
+	candidates := c.failoverCandidates(addr)
+	if len(candidates) == 0 {
+		if addr != "" {
+			return fmt.Errorf("slave %s not exists in cluster %s", addr, c.cluserName)
+		}
+		return fmt.Errorf("no candidate slave in cluster %s", c.cluserName)
+	}
+
+	for _, db := range candidates {
+		if err := checkCaughtUp(db); err != nil {
+			log.Warnf("cluster %s skip candidate %s: %s", c.cluserName, nodeAddr(db), err.Error())
+			continue
+		}
+
+		if err := promote(db); err != nil {
+			return fmt.Errorf("promote %s error: %s", nodeAddr(db), err.Error())
+		}
+
+		c.swapMaster(db)
+		c.masterFailures = 0
+		failovers.WithLabelValues(c.cluserName).Inc()
+		return nil
+	}
+
+	return fmt.Errorf("no candidate slave caught up with the master in cluster %s", c.cluserName)
+}
+
+// failoverCandidates returns the slave at addr, or the candidate slaves
+// ordered by dsn if addr is empty
+func (c *Cluster) failoverCandidates(addr string) []*mysql.DB {
+	clustersMu.RLock()
+	defer clustersMu.RUnlock()
+
+	var ret []*mysql.DB
+	for dsn, db := range c.slaveNodes {
+		if (addr == "" && c.candidates[dsn]) || (addr != "" && nodeAddr(db) == addr) {
+			ret = append(ret, db)
+		}
+	}
+
+	sort.Sort(dbsByDsn(ret))
+	return ret
+}
+
+// checkCaughtUp checks the slave applied all the relay log it read from the
+// master
+func checkCaughtUp(db *mysql.DB) error {
+	status, err := db.SlaveStatus()
+	if err != nil {
+		return err
+	}
+
+	if status["Master_Log_File"] != status["Relay_Master_Log_File"] ||
+		status["Read_Master_Log_Pos"] != status["Exec_Master_Log_Pos"] {
+		return fmt.Errorf("relay log not applied, read %s:%s, executed %s:%s",
+			status["Master_Log_File"].String, status["Read_Master_Log_Pos"].String,
+			status["Relay_Master_Log_File"].String, status["Exec_Master_Log_Pos"].String)
+	}
+
+	return nil
+}
+
+// promote stops the replication on the slave and makes it writable
+func promote(db *mysql.DB) error {
+	for _, sql := range []string{"STOP SLAVE", "RESET SLAVE ALL", "SET GLOBAL read_only = 0"} {
+		if _, err := db.Exec(sql); err != nil {
+			return fmt.Errorf("%s: %s", sql, err.Error())
+		}
+	}
+	return nil
+}
+
+// swapMaster makes the slave the master of the cluster, the old master is
+// closed the same way reload does
+func (c *Cluster) swapMaster(db *mysql.DB) {
+	clustersMu.Lock()
+	defer clustersMu.Unlock()
+
+	old := c.masterNode
+	for dsn, slave := range c.slaveNodes {
+		if slave == db {
+			delete(c.slaveNodes, dsn)
+		}
+	}
+
+	// the master is never taken out of rotation for lagging
+	db.SetMaxReplicationLag(0)
+	c.masterNode = db
+	c.failedMaster = old.Dsn()
+
+	log.Warnf("cluster %s failover master from %s to %s, please update the config", c.cluserName, nodeAddr(old), nodeAddr(db))
+	go closeClusterDBConns([]*mysql.DB{old})
+}
@@ -0,0 +1,219 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/bytedance/dbatman/database/mysql"
+)
+
+// fakeResult is the answer of the fake server to a query, an error packet is
+// written if err is not nil, an OK packet if columns is nil, or a resultset.
+type fakeResult struct {
+	columns []string
+	rows    [][]interface{} // printed by fmt.Sprint, nil is NULL
+	err     *mysql.MySQLError
+}
+
+// fakeServer is a MySQL server accepts any user and answers the queries by
+// handler, it is used to test the node management without a real MySQL.
+type fakeServer struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	handler func(query string) *fakeResult
+	conns   []net.Conn
+}
+
+func newFakeServer(t *testing.T, handler func(query string) *fakeResult) *fakeServer {
+	return newFakeServerAt(t, "127.0.0.1:0", handler)
+}
+
+// newFakeServerAt starts the server at addr, e.g. to bring back a server
+// closed
+func newFakeServerAt(t *testing.T, addr string, handler func(query string) *fakeResult) *fakeServer {
+	l, err := net.Listen("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeServer{listener: l, handler: handler}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeServer) DSN() string {
+	return fmt.Sprintf("root:@tcp(%s)/test", s.Addr())
+}
+
+func (s *fakeServer) SetHandler(handler func(query string) *fakeResult) {
+	s.mu.Lock()
+	s.handler = handler
+	s.mu.Unlock()
+}
+
+// Close stops the server and closes all the connections
+func (s *fakeServer) Close() {
+	s.listener.Close()
+
+	s.mu.Lock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+	s.mu.Unlock()
+}
+
+func (s *fakeServer) serve() {
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns = append(s.conns, c)
+		s.mu.Unlock()
+
+		go s.serveConn(c)
+	}
+}
+
+func (s *fakeServer) Salt() []byte {
+	return []byte("01234567890123456789")
+}
+
+func (s *fakeServer) CheckAuth(username string, auth []byte, db string) error {
+	return nil
+}
+
+func (s *fakeServer) ServerName() []byte {
+	return []byte("5.6.0-fake")
+}
+
+func (s *fakeServer) serveConn(c net.Conn) {
+	fc := mysql.NewMySQLServerConn(s, c)
+	defer fc.Close()
+
+	if err := fc.Handshake(); err != nil {
+		return
+	}
+
+	for {
+		fc.ResetSequence()
+		data, err := fc.ReadPacket()
+		if err != nil {
+			return
+		}
+
+		switch data[0] {
+		case mysql.ComQuit:
+			return
+		case mysql.ComQuery:
+			s.mu.Lock()
+			handler := s.handler
+			s.mu.Unlock()
+
+			var r *fakeResult
+			if query := string(data[1:]); query == "SELECT @@max_allowed_packet" {
+				// asked by the driver after connected
+				r = &fakeResult{columns: []string{"@@max_allowed_packet"}, rows: [][]interface{}{{4194304}}}
+			} else if handler != nil {
+				r = handler(query)
+			}
+			err = writeFakeResult(fc, r)
+		default:
+			err = fc.WriteOK(nil)
+		}
+
+		if err == nil {
+			err = fc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func writeFakeResult(fc *mysql.MySQLServerConn, r *fakeResult) error {
+	if r == nil || (r.err == nil && r.columns == nil) {
+		return fc.WriteOK(nil)
+	}
+
+	if r.err != nil {
+		return fc.WriteError(r.err)
+	}
+
+	if err := fc.WritePacket(appendLengthEncoded(make([]byte, 4), uint64(len(r.columns)))); err != nil {
+		return err
+	}
+
+	for _, name := range r.columns {
+		data := make([]byte, 4)
+		for _, s := range []string{"def", "", "", "", name, name} {
+			data = appendLengthEncodedString(data, s)
+		}
+		data = append(data, 0x0c, 33, 0, 0, 1, 0, 0, mysql.FieldTypeVarString, 0, 0, 0, 0, 0)
+		if err := fc.WritePacket(data); err != nil {
+			return err
+		}
+	}
+
+	if err := fc.WriteEOF(); err != nil {
+		return err
+	}
+
+	for _, row := range r.rows {
+		data := make([]byte, 4)
+		for _, v := range row {
+			if v == nil {
+				data = append(data, 0xfb)
+			} else {
+				data = appendLengthEncodedString(data, fmt.Sprint(v))
+			}
+		}
+		if err := fc.WritePacket(data); err != nil {
+			return err
+		}
+	}
+
+	return fc.WriteEOF()
+}
+
+func appendLengthEncoded(b []byte, n uint64) []byte {
+	switch {
+	case n <= 250:
+		return append(b, byte(n))
+	case n <= 0xffff:
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n <= 0xffffff:
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	}
+	return append(b, 0xfe, byte(n), byte(n>>8), byte(n>>16), byte(n>>24),
+		byte(n>>32), byte(n>>40), byte(n>>48), byte(n>>56))
+}
+
+func appendLengthEncodedString(b []byte, s string) []byte {
+	return append(appendLengthEncoded(b, uint64(len(s))), s...)
+}
+
+// slaveStatus returns a handler answers SHOW SLAVE STATUS with the lag, nil
+// lag means the replication is not running
+func slaveStatus(lag func() interface{}) func(query string) *fakeResult {
+	return func(query string) *fakeResult {
+		if query != "SHOW SLAVE STATUS" {
+			return nil
+		}
+
+		return &fakeResult{
+			columns: []string{"Slave_IO_State", "Master_Host", "Seconds_Behind_Master"},
+			rows:    [][]interface{}{{"Waiting for master to send event", "127.0.0.1", lag()}},
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package cluster
+
+import (
+	"github.com/bytedance/dbatman/metrics"
+)
+
+var nodeLabels = []string{"cluster", "role", "node"}
+
+var heartbeatFailures = metrics.NewCounterVec("dbatman_heartbeat_failures_total",
+	"Total number of failed heartbeats of the nodes.", nodeLabels...)
+
+var nodeStateChanges = metrics.NewCounterVec("dbatman_node_state_changes_total",
+	"Total number of times the nodes were cut down or put back.", append(nodeLabels, "state")...)
+
+func init() {
+	newNodeGauge("dbatman_node_open_connections", "Number of open connections to the node.",
+		func(s NodeStats) float64 { return float64(s.OpenConnections) })
+	newNodeGauge("dbatman_node_free_connections", "Number of idle connections in the pool of the node.",
+		func(s NodeStats) float64 { return float64(s.FreeConnections) })
+	newNodeGauge("dbatman_node_in_use_connections", "Number of connections borrowed from the pool of the node.",
+		func(s NodeStats) float64 { return float64(s.OpenConnections - s.FreeConnections) })
+	newNodeGauge("dbatman_node_max_open_connections", "Limit of open connections to the node, 0 means unlimited.",
+		func(s NodeStats) float64 { return float64(s.MaxOpenConnections) })
+	newNodeGauge("dbatman_node_alive", "Whether the node is alive (1) or cut down (0).",
+		func(s NodeStats) float64 {
+			if s.AliveStatus {
+				return 1
+			}
+			return 0
+		})
+	newNodeGauge("dbatman_node_replication_lag_seconds", "Seconds the node is behind its master, -1 means unknown.",
+		func(s NodeStats) float64 { return float64(s.ReplicationLag) })
+	newNodeGauge("dbatman_node_lagging", "Whether the slave is out of rotation because of the replication lag.",
+		func(s NodeStats) float64 {
+			if s.Lagging {
+				return 1
+			}
+			return 0
+		})
+	newNodeGauge("dbatman_node_latency_seconds", "Moving average of the query latency of the node.",
+		func(s NodeStats) float64 { return s.Latency.Seconds() })
+
+	metrics.NewCounterFunc("dbatman_node_wait_count_total",
+		"Total number of times waited for a free connection of the node.", nodeLabels,
+		collectNodeStats(func(s NodeStats) float64 { return float64(s.WaitCount) }))
+	metrics.NewCounterFunc("dbatman_node_wait_duration_seconds_total",
+		"Total time blocked waiting for a free connection of the node.", nodeLabels,
+		collectNodeStats(func(s NodeStats) float64 { return s.WaitDuration.Seconds() }))
+}
+
+func newNodeGauge(name, help string, value func(s NodeStats) float64) {
+	metrics.NewGaugeFunc(name, help, nodeLabels, collectNodeStats(value))
+}
+
+// collectNodeStats returns a collect function emits value of every node
+func collectNodeStats(value func(s NodeStats) float64) func(emit func(v float64, values ...string)) {
+	return func(emit func(v float64, values ...string)) {
+		for _, c := range Clusters() {
+			for _, s := range c.Stats() {
+				emit(value(s), c.Name(), s.Role, s.Addr)
+			}
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"time"
+
+	"github.com/bytedance/dbatman/database/mysql"
+	"github.com/ngaut/log"
+)
+
+const (
+	defaultRecoverThreshold = 3
+
+	// the backoff of probing a node cut down doubles on every failure
+	recoverMinBackoff = time.Second
+	recoverMaxBackoff = 30 * time.Second
+)
+
+// nodeRecovery is the probing state of a slave cut down
+type nodeRecovery struct {
+	successes int
+	backoff   time.Duration
+	next      time.Time
+}
+
+// setRecoverThreshold must be called with clustersMu locked or before the
+// cluster is published.
+func (c *Cluster) setRecoverThreshold(threshold int) {
+	if threshold <= 0 {
+		threshold = defaultRecoverThreshold
+	}
+	c.recoverThreshold = threshold
+}
+
+// cutDown takes the slave failed the heartbeat out of rotation, it is probed
+// by recoverNode since then
+func (c *Cluster) cutDown(db *mysql.DB) {
+	db.SetDbAliveStatus(false)
+
+	c.recoveryMu.Lock()
+	if c.recovery == nil {
+		c.recovery = make(map[*mysql.DB]*nodeRecovery)
+	}
+	c.recovery[db] = &nodeRecovery{backoff: recoverMinBackoff}
+	c.recoveryMu.Unlock()
+
+	nodeStateChanges.WithLabelValues(c.cluserName, "slave", nodeAddr(db), "down").Inc()
+	log.Warnf("cluster %s cut down the slave %s", c.cluserName, nodeAddr(db))
+}
+
+// recoverNode probes the slave cut down with backoff, and puts it back in
+// rotation after recoverThreshold consecutive successful heartbeats, so a
+// flapping node does not come and go.
+func (c *Cluster) recoverNode(db *mysql.DB, now time.Time) {
+	clustersMu.RLock()
+	threshold := c.recoverThreshold
+	clustersMu.RUnlock()
+
+	c.recoveryMu.Lock()
+	if c.recovery == nil {
+		c.recovery = make(map[*mysql.DB]*nodeRecovery)
+	}
+	r, ok := c.recovery[db]
+	if !ok {
+		r = &nodeRecovery{backoff: recoverMinBackoff}
+		c.recovery[db] = r
+	}
+	if now.Before(r.next) {
+		c.recoveryMu.Unlock()
+		return
+	}
+	c.recoveryMu.Unlock()
+
+	err := db.HeartBeatPing()
+
+	c.recoveryMu.Lock()
+	if err != nil {
+		r.successes = 0
+		r.next = now.Add(r.backoff)
+		if r.backoff *= 2; r.backoff > recoverMaxBackoff {
+			r.backoff = recoverMaxBackoff
+		}
+		c.recoveryMu.Unlock()
+
+		heartbeatFailures.WithLabelValues(c.cluserName, "slave", nodeAddr(db)).Inc()
+		log.Debugf("cluster %s slave %s is still down: %s", c.cluserName, nodeAddr(db), err.Error())
+		return
+	}
+
+	// probe every heartbeat once it answers
+	r.successes++
+	r.backoff = recoverMinBackoff
+	r.next = time.Time{}
+	successes := r.successes
+	if successes >= threshold {
+		delete(c.recovery, db)
+	}
+	c.recoveryMu.Unlock()
+
+	if successes < threshold {
+		log.Infof("cluster %s slave %s answered the heartbeat %d/%d", c.cluserName, nodeAddr(db), successes, threshold)
+		return
+	}
+
+	db.SetDbAliveStatus(true)
+	nodeStateChanges.WithLabelValues(c.cluserName, "slave", nodeAddr(db), "up").Inc()
+	log.Infof("cluster %s slave %s recovered, put it back", c.cluserName, nodeAddr(db))
+}
+
+// pruneRecovery forgets the nodes removed from the cluster or put back by a
+// config reload
+func (c *Cluster) pruneRecovery(slaves []*mysql.DB) {
+	exists := make(map[*mysql.DB]bool, len(slaves))
+	for _, db := range slaves {
+		exists[db] = true
+	}
+
+	c.recoveryMu.Lock()
+	for db := range c.recovery {
+		if !exists[db] || db.GetDbAliveStatus() {
+			delete(c.recovery, db)
+		}
+	}
+	c.recoveryMu.Unlock()
+}
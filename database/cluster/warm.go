@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"sync/atomic"
+
+	"github.com/bytedance/dbatman/config"
+	"github.com/bytedance/dbatman/database/mysql"
+	"github.com/ngaut/log"
+)
+
+// The pools of the nodes are pre-warmed with min_connections of the users of
+// the cluster, the most of them if the cluster is shared. The idle
+// connections closed by the probe are opened again on the next one.
+
+// minConnections returns the idle connections kept on the nodes of each
+// cluster
+func minConnections(cfg *config.ProxyConfig) map[string]int {
+	mins := make(map[string]int)
+	for _, user := range cfg.Users {
+		if user.MinConnections > mins[user.ClusterName] {
+			mins[user.ClusterName] = user.MinConnections
+		}
+	}
+	return mins
+}
+
+func warm() {
+	mins := minConnections(cfgHandler.GetConfig())
+
+	clustersMu.RLock()
+	for clusterName, c := range clusterConns {
+		if n := mins[clusterName]; n > 0 {
+			go c.warm(n)
+		}
+	}
+	clustersMu.RUnlock()
+}
+
+// warm opens the connections of the alive nodes until n of them are idle,
+// it is skipped if the last one is not done yet
+func (c *Cluster) warm(n int) {
+	if !atomic.CompareAndSwapInt32(&c.warming, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&c.warming, 0)
+
+	clustersMu.RLock()
+	nodes := make([]*mysql.DB, 0, len(c.slaveNodes)+1)
+	nodes = append(nodes, c.masterNode)
+	for _, db := range c.slaveNodes {
+		nodes = append(nodes, db)
+	}
+	clustersMu.RUnlock()
+
+	for _, db := range nodes {
+		if db == nil || !db.GetDbAliveStatus() {
+			continue
+		}
+		if err := db.Warm(n); err != nil {
+			log.Warnf("Cluster[%s] warm node %s error msg:%s", c.cluserName, nodeAddr(db), err.Error())
+		}
+	}
+}
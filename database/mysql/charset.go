@@ -1,7 +1,34 @@
 package mysql
 
+import "strings"
+
 type CollationId uint8
 
+// Name returns the name of the collation
+func (id CollationId) Name() string {
+	return Collations[id]
+}
+
+// Charset returns the charset of the collation, e.g. utf8mb4 of
+// utf8mb4_general_ci
+func (id CollationId) Charset() string {
+	name := Collations[id]
+	if i := strings.IndexByte(name, '_'); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// IsClientCharset reports whether the collation could be used by the clients,
+// the multi-byte charsets without ASCII compatibility could not.
+func (id CollationId) IsClientCharset() bool {
+	switch id.Charset() {
+	case "", "ucs2", "utf16", "utf16le", "utf32":
+		return false
+	}
+	return true
+}
+
 //charset key is charset name and value is default collation id
 var CharsetIds = map[string]CollationId{
 	"default":  DEFAULT_COLLATION_ID,
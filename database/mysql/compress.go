@@ -0,0 +1,177 @@
+// Copyright 2016 ByteDance, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"net"
+)
+
+const (
+	compressedHeaderSize = 7
+
+	// payloads shorter than this are sent uncompressed, as the server does
+	minCompressLength = 50
+)
+
+// compressedConn is a net.Conn speaking the compressed protocol
+// (CLIENT_COMPRESS), it is shared by MySQLConn and MySQLServerConn. The
+// packets read and written through it are the plain ones, each write is
+// sent as one or more compressed packets and the reads return the
+// uncompressed payloads.
+// http://dev.mysql.com/doc/internals/en/compressed-packet-header.html
+type compressedConn struct {
+	net.Conn
+
+	// seq is the sequence of the compressed packets, independent of the
+	// sequence of the packets they carry
+	seq byte
+
+	header [compressedHeaderSize]byte
+	rbuf   []byte
+	zbuf   bytes.Buffer
+	zw     *zlib.Writer
+}
+
+func newCompressedConn(nc net.Conn) *compressedConn {
+	c := &compressedConn{Conn: nc}
+	c.zw = zlib.NewWriter(&c.zbuf)
+	return c
+}
+
+// resetSequence is called at the beginning of a command
+func (c *compressedConn) resetSequence() {
+	c.seq = 0
+}
+
+func (c *compressedConn) Read(p []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		if err := c.readCompressedPacket(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *compressedConn) readCompressedPacket() error {
+	if _, err := io.ReadFull(c.Conn, c.header[:]); err != nil {
+		return err
+	}
+
+	compLen := int(uint32(c.header[0]) | uint32(c.header[1])<<8 | uint32(c.header[2])<<16)
+	uncompLen := int(uint32(c.header[4]) | uint32(c.header[5])<<8 | uint32(c.header[6])<<16)
+	c.seq = c.header[3] + 1
+
+	data := make([]byte, compLen)
+	if _, err := io.ReadFull(c.Conn, data); err != nil {
+		return err
+	}
+
+	// not compressed
+	if uncompLen == 0 {
+		c.rbuf = data
+		return nil
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return NewDefaultError(ER_NET_UNCOMPRESS_ERROR)
+	}
+	defer zr.Close()
+
+	c.rbuf = make([]byte, uncompLen)
+	if _, err := io.ReadFull(zr, c.rbuf); err != nil {
+		c.rbuf = nil
+		return NewDefaultError(ER_NET_UNCOMPRESS_ERROR)
+	}
+
+	return nil
+}
+
+func (c *compressedConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		size := len(p)
+		if size > maxPacketSize {
+			size = maxPacketSize
+		}
+
+		if err := c.writeCompressedPacket(p[:size]); err != nil {
+			return written, err
+		}
+
+		written += size
+		p = p[size:]
+	}
+
+	return written, nil
+}
+
+func (c *compressedConn) writeCompressedPacket(payload []byte) error {
+	data := payload
+	uncompLen := 0
+
+	if len(payload) >= minCompressLength {
+		c.zbuf.Reset()
+		c.zw.Reset(&c.zbuf)
+		if _, err := c.zw.Write(payload); err != nil {
+			return err
+		}
+		if err := c.zw.Close(); err != nil {
+			return err
+		}
+
+		// keep the payload as is if it doesn't shrink
+		if c.zbuf.Len() < len(payload) {
+			data = c.zbuf.Bytes()
+			uncompLen = len(payload)
+		}
+	}
+
+	pkt := make([]byte, compressedHeaderSize+len(data))
+	pkt[0] = byte(len(data))
+	pkt[1] = byte(len(data) >> 8)
+	pkt[2] = byte(len(data) >> 16)
+	pkt[3] = c.seq
+	pkt[4] = byte(uncompLen)
+	pkt[5] = byte(uncompLen >> 8)
+	pkt[6] = byte(uncompLen >> 16)
+	copy(pkt[compressedHeaderSize:], data)
+
+	if _, err := c.Conn.Write(pkt); err != nil {
+		return err
+	}
+
+	c.seq++
+	return nil
+}
+
+// enableCompression switches the connection to the compressed protocol,
+// after the handshake is done
+func (mc *MySQLConn) enableCompression() {
+	cc := newCompressedConn(mc.netConn)
+	mc.netConn = cc
+	mc.buf.nc = cc
+}
+
+// Compressed returns whether the connection uses the compressed protocol
+func (mc *MySQLConn) Compressed() bool {
+	_, ok := mc.netConn.(*compressedConn)
+	return ok
+}
@@ -0,0 +1,151 @@
+package mysql
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestCompressedConn_Write(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	small := []byte("select 1")
+	large := bytes.Repeat([]byte("select * from t where id = 1;"), 1000)
+	random := make([]byte, 1000)
+	rand.Read(random)
+
+	cc := newCompressedConn(client)
+	go func() {
+		for _, p := range [][]byte{small, large, random} {
+			if _, err := cc.Write(p); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i, test := range []struct {
+		payload    []byte
+		compressed bool
+	}{
+		{small, false},
+		{large, true},
+		// could not be compressed
+		{random, false},
+	} {
+		header := make([]byte, compressedHeaderSize)
+		if _, err := io.ReadFull(server, header); err != nil {
+			t.Fatal(err)
+		}
+
+		compLen := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		uncompLen := int(header[4]) | int(header[5])<<8 | int(header[6])<<16
+		if header[3] != byte(i) {
+			t.Fatalf("%d: expect sequence %d, got %d", i, i, header[3])
+		}
+
+		if test.compressed {
+			if uncompLen != len(test.payload) || compLen >= len(test.payload) {
+				t.Fatalf("%d: expect compressed %d bytes, got %d of %d", i, len(test.payload), compLen, uncompLen)
+			}
+		} else if uncompLen != 0 || compLen != len(test.payload) {
+			t.Fatalf("%d: expect uncompressed %d bytes, got %d of %d", i, len(test.payload), compLen, uncompLen)
+		}
+
+		if _, err := io.ReadFull(server, make([]byte, compLen)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCompressedConn_Packets(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	newConn := func(nc net.Conn) *MySQLConn {
+		mc := &MySQLConn{
+			netConn:          nc,
+			buf:              newBuffer(nc),
+			maxPacketAllowed: maxPacketSize,
+			maxWriteSize:     maxPacketSize - 1,
+		}
+		mc.enableCompression()
+		return mc
+	}
+
+	smc, cmc := newConn(server), newConn(client)
+	if !smc.Compressed() || !cmc.Compressed() {
+		t.Fatal("expect compressed connections")
+	}
+
+	query := append([]byte{comQuery}, bytes.Repeat([]byte("x"), 100)...)
+	reply := bytes.Repeat([]byte("y"), 2*defaultBufSize)
+
+	// the command and reply twice, the sequences are reset by the command
+	for i := 0; i < 2; i++ {
+		done := make(chan error, 1)
+		go func() {
+			cmc.sequence = 0
+			if err := cmc.writePacket(append(make([]byte, 4), query...)); err != nil {
+				done <- err
+				return
+			}
+			data, err := cmc.readPacket()
+			if err == nil && !bytes.Equal(data, reply) {
+				t.Errorf("expect reply of %d bytes, got %d", len(reply), len(data))
+			}
+			done <- err
+		}()
+
+		smc.sequence = 0
+		data, err := smc.readPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, query) {
+			t.Fatalf("expect query % x, got % x", query, data)
+		}
+
+		if cc := smc.netConn.(*compressedConn); cc.seq != 1 {
+			t.Fatalf("expect compressed sequence 1, got %d", cc.seq)
+		}
+
+		if err := smc.writePacket(append(make([]byte, 4), reply...)); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestServerConn_Compress(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		ctx := &testAuthCtx{password: "pass"}
+		c, done := newTestAuthClient(t, ctx, nil)
+		if ctx.fc.Flags()&uint32(ClientCompress) == 0 {
+			t.Fatal("expect CLIENT_COMPRESS announced")
+		}
+		if compress {
+			c.flags = clientCompress
+		}
+
+		c.writeResponse(t, AuthNativePassword, scramblePassword(c.salt, []byte("pass")))
+		// the OK of the handshake is not compressed
+		expectPacket(t, c.read(t), iOK)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		if ctx.fc.Compressed() != compress {
+			t.Fatalf("expect compressed %v", compress)
+		}
+		if ctx.fc.TLS() {
+			t.Fatal("expect no TLS")
+		}
+	}
+}
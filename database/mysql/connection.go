@@ -33,6 +33,7 @@ type MySQLConn struct {
 	strict           bool
 	threadId         uint32
 	status_info      string // General response info
+	stateChanges     []SessionStateChange
 }
 
 // Handles parameters set in DSN after the connection is established
@@ -73,7 +74,7 @@ func (mc *MySQLConn) Begin() (driver.Tx, error) {
 
 	err := mc.exec("START TRANSACTION")
 	if err == nil {
-		return &mysqlTx{mc}, err
+		return &mysqlTx{mc: mc}, err
 	}
 
 	return nil, err
@@ -104,6 +105,7 @@ func (mc *MySQLConn) cleanup() {
 
 	// must clean status_info
 	mc.status_info = ""
+	mc.stateChanges = nil
 	mc.cfg = nil
 	mc.buf.nc = nil
 }
@@ -287,6 +289,7 @@ func (mc *MySQLConn) Exec(query string, args []driver.Value) (driver.Result, err
 			status:       mc.status,
 			warnings:     nil,
 			statusInfo:   mc.popStatusInfo(),
+			stateChanges: mc.popSessionState(),
 		}, nil
 	} else if errs, ok := err.(MySQLWarnings); ok {
 		return &MySQLResult{
@@ -295,6 +298,7 @@ func (mc *MySQLConn) Exec(query string, args []driver.Value) (driver.Result, err
 			status:       mc.status,
 			warnings:     errs.Errors(),
 			statusInfo:   mc.popStatusInfo(),
+			stateChanges: mc.popSessionState(),
 		}, err
 	}
 
@@ -313,7 +317,7 @@ func (mc *MySQLConn) exec(query string) error {
 	// Read Result
 	resLen, err := mc.readResultSetHeaderPacket()
 	if err == nil && resLen > 0 {
-		if err = mc.readUntilEOF(); err != nil {
+		if err = mc.skipColumns(resLen); err != nil {
 			return err
 		}
 
@@ -329,6 +333,13 @@ func (mc *MySQLConn) popStatusInfo() string {
 	return str
 }
 
+// popSessionState returns the session state changes of the last OK packet
+func (mc *MySQLConn) popSessionState() []SessionStateChange {
+	changes := mc.stateChanges
+	mc.stateChanges = nil
+	return changes
+}
+
 func (mc *MySQLConn) Query(query string, args []driver.Value) (driver.Rows, error) {
 	if mc.netConn == nil {
 		errLog.Print(ErrInvalidConn)
@@ -355,12 +366,20 @@ func (mc *MySQLConn) Query(query string, args []driver.Value) (driver.Rows, erro
 		resLen, err = mc.readResultSetHeaderPacket()
 		if err == nil {
 			rows := new(TextRows)
-			rows.mc = mc
 
 			if resLen == 0 {
-				// no columns, no more data
-				return emptyRows{}, nil
+				// no columns, no more data, e.g. CALL of the procedures
+				// without SELECT, the OK packet is kept for Result
+				rows.result = &MySQLResult{
+					affectedRows: int64(mc.affectedRows),
+					insertId:     int64(mc.insertId),
+					status:       mc.status,
+					statusInfo:   mc.popStatusInfo(),
+					stateChanges: mc.popSessionState(),
+				}
+				return rows, nil
 			}
+			rows.mc = mc
 			// Columns
 			rows.columns, err = mc.readColumns(resLen)
 			return rows, err
@@ -406,7 +425,7 @@ func (mc *MySQLConn) getSystemVar(name string) ([]byte, error) {
 
 		if resLen > 0 {
 			// Columns
-			if err := mc.readUntilEOF(); err != nil {
+			if err := mc.skipColumns(resLen); err != nil {
 				return nil, err
 			}
 		}
@@ -419,6 +438,14 @@ func (mc *MySQLConn) getSystemVar(name string) ([]byte, error) {
 	return nil, err
 }
 
+// Ping sends a COM_PING and reads the OK packet
+func (mc *MySQLConn) Ping() error {
+	if err := mc.writeCommandPacket(comPing); err != nil {
+		return err
+	}
+	return mc.readResultOK()
+}
+
 func (mc *MySQLConn) IsBroken() bool {
 	return mc.buf.isBroken()
 }
@@ -81,6 +81,16 @@ const (
 	ComStmtReset        byte = comStmtReset
 	ComSetOption        byte = comSetOption
 	ComStmtFetch        byte = comStmtFetch
+	ComDaemon           byte = comDaemon
+	ComBinlogDumpGtid   byte = comBinlogDumpGtid
+	ComResetConnection  byte = comResetConnection
+)
+
+const (
+	CursorTypeNoCursor   byte = cursorTypeNoCursor
+	CursorTypeReadOnly   byte = cursorTypeReadOnly
+	CursorTypeForUpdate  byte = cursorTypeForUpdate
+	CursorTypeScrollable byte = cursorTypeScrollable
 )
 
 // https://dev.mysql.com/doc/internals/en/com-query-response.html#packet-Protocol::ColumnType
@@ -119,6 +119,11 @@ func (d MySQLDriver) Open(dsn string) (driver.Conn, error) {
 		return nil, err
 	}
 
+	// Switch to the compressed protocol if both sides support it
+	if mc.cfg.Compress && mc.flags&clientCompress != 0 {
+		mc.enableCompression()
+	}
+
 	// Get max allowed packet size
 	maxap, err := mc.getSystemVar("max_allowed_packet")
 	if err != nil {
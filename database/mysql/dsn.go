@@ -49,6 +49,7 @@ type Config struct {
 	AllowOldPasswords       bool // Allows the old insecure password method
 	ClientFoundRows         bool // Return number of matching rows instead of rows changed
 	ColumnsWithAlias        bool // Prepend table alias to column names
+	Compress                bool // Compress packets if the server supports it
 	InterpolateParams       bool // Interpolate placeholders into query string
 	MultiStatements         bool // Allow multiple statements in one query
 	ParseTime               bool // Parse time values to time.Time
@@ -138,6 +139,15 @@ func (cfg *Config) FormatDSN() string {
 		}
 	}
 
+	if cfg.Compress {
+		if hasParam {
+			buf.WriteString("&compress=true")
+		} else {
+			hasParam = true
+			buf.WriteString("?compress=true")
+		}
+	}
+
 	if cfg.InterpolateParams {
 		if hasParam {
 			buf.WriteString("&interpolateParams=true")
@@ -410,7 +420,11 @@ func parseDSNParams(cfg *Config, params string) (err error) {
 
 		// Compression
 		case "compress":
-			return errors.New("compression not implemented yet")
+			var isBool bool
+			cfg.Compress, isBool = readBool(value)
+			if !isBool {
+				return errors.New("invalid bool value: " + value)
+			}
 
 		// Enable client side placeholder substitution
 		case "interpolateParams":
@@ -103,6 +103,11 @@ func (mc *MySQLConn) writePacket(data []byte) error {
 		}
 		data[3] = mc.sequence
 
+		// a new command resets the sequence of the compressed packets too
+		if cc, ok := mc.netConn.(*compressedConn); ok && mc.sequence == 0 {
+			cc.resetSequence()
+		}
+
 		// Write packet
 		if mc.writeTimeout > 0 {
 			if err := mc.netConn.SetWriteDeadline(time.Now().Add(mc.writeTimeout)); err != nil {
@@ -248,6 +253,18 @@ func (mc *MySQLConn) writeAuthPacket(cipher []byte) error {
 		clientFlags |= clientMultiStatements
 	}
 
+	if mc.cfg.Compress && mc.flags&clientCompress != 0 {
+		clientFlags |= clientCompress
+	}
+
+	// the session state changes are passed through to the clients tracking
+	// them, see readOKInfo
+	clientFlags |= mc.flags & clientSessionTrack
+
+	// the columns are not followed by the EOF packet and the rows end with
+	// the OK packet then, see isEOFPacket
+	clientFlags |= mc.flags & clientDeprecateEOF
+
 	// User Password
 	scrambleBuff := scramblePassword(cipher, []byte(mc.cfg.Passwd))
 
@@ -598,9 +615,6 @@ func (mc *MySQLConn) handleOkPacket(data []byte) error {
 
 	// server_status [2 bytes]
 	mc.status = readStatus(data[1+n+m : 1+n+m+2])
-	if err := mc.discardResults(); err != nil {
-		return err
-	}
 
 	// warning count [2 bytes]
 	//if !mc.strict {
@@ -609,9 +623,21 @@ func (mc *MySQLConn) handleOkPacket(data []byte) error {
 
 	pos := 1 + n + m + 2
 
+	// info and session state changes, the results following have their own
+	var info string
+	var changes []SessionStateChange
 	if pos+2 < len(data) {
-		mc.status_info = string(data[pos+2:])
+		var err error
+		if info, changes, err = mc.readOKInfo(data[pos+2:], mc.status); err != nil {
+			return err
+		}
+	}
+
+	if err := mc.discardResults(); err != nil {
+		return err
 	}
+	mc.status_info = info
+	mc.stateChanges = changes
 
 	// get warnings
 	if binary.LittleEndian.Uint16(data[pos:pos+2]) > 0 {
@@ -629,6 +655,11 @@ func (mc *MySQLConn) readColumns(count int) ([]MySQLField, error) {
 	columns := make([]MySQLField, count)
 
 	for i := 0; ; i++ {
+		// no EOF packet follows the columns
+		if i == count && mc.deprecateEOF() {
+			return columns, nil
+		}
+
 		datali := make([]byte, 0, 256)
 		data, err := mc.readPacket()
 		datali = append(datali, data...)
@@ -637,7 +668,9 @@ func (mc *MySQLConn) readColumns(count int) ([]MySQLField, error) {
 		}
 
 		// EOF Packet
-		if data[0] == iEOF && (len(data) == 5 || len(data) == 1) {
+		if mc.isEOFPacket(data) {
+			// tells if a cursor is opened
+			mc.readEOFPacket(data)
 			if i == count {
 				return columns, nil
 			}
@@ -665,7 +698,8 @@ func (mc *MySQLConn) readFieldList() ([]MySQLField, error) {
 		}
 
 		// EOF Packet
-		if data[0] == iEOF && (len(data) == 5 || len(data) == 1) {
+		if mc.isEOFPacket(data) {
+			mc.readEOFPacket(data)
 			return columns, nil
 		}
 
@@ -780,14 +814,8 @@ func (rows *TextRows) readRow(dest []driver.Value) error {
 	}
 
 	// EOF Packet
-	if data[0] == iEOF && len(data) == 5 {
-		// server_status [2 bytes]
-		rows.mc.status = readStatus(data[3:])
-		if err := rows.mc.discardResults(); err != nil {
-			return err
-		}
-		rows.mc = nil
-		return io.EOF
+	if mc.isEOFPacket(data) {
+		return rows.readEOF(data)
 	}
 	if data[0] == iERR {
 		rows.mc = nil
@@ -838,17 +866,88 @@ func (rows *TextRows) readRow(dest []driver.Value) error {
 func (mc *MySQLConn) readUntilEOF() error {
 	for {
 		data, err := mc.readPacket()
+		if err != nil {
+			return err
+		}
 
-		// No Err and no EOF Packet
-		if err == nil && data[0] != iEOF {
+		// No EOF Packet
+		if !mc.isEOFPacket(data) {
 			continue
 		}
-		if err == nil && data[0] == iEOF && len(data) == 5 {
-			mc.status = readStatus(data[3:])
+
+		mc.readEOFPacket(data)
+		return nil
+	}
+}
+
+// skipColumns reads the count columns of a result set and the EOF packet
+// after them if any
+func (mc *MySQLConn) skipColumns(count int) error {
+	if !mc.deprecateEOF() {
+		return mc.readUntilEOF()
+	}
+
+	for i := 0; i < count; i++ {
+		if _, err := mc.readPacket(); err != nil {
+			return err
 		}
+	}
+	return nil
+}
+
+// deprecateEOF tells if CLIENT_DEPRECATE_EOF is used on the connection, the
+// columns are not followed by the EOF packet and the rows end with the OK
+// packet with the EOF header then. The flag is requested whenever the
+// server supports it.
+func (mc *MySQLConn) deprecateEOF() bool {
+	return mc.flags&clientDeprecateEOF != 0
+}
 
-		return err // Err or EOF
+// isEOFPacket tells if data is the packet at the end of the columns or rows.
+// A row could start with 0xfe too, as the length of a value longer than
+// 2^24, the packet is much longer than the EOF or OK packet then.
+// http://dev.mysql.com/doc/internals/en/packet-EOF_Packet.html
+func (mc *MySQLConn) isEOFPacket(data []byte) bool {
+	if data[0] != iEOF {
+		return false
 	}
+
+	if mc.deprecateEOF() {
+		return len(data) < maxPacketSize
+	}
+	return len(data) < 9
+}
+
+// readEOFPacket reads the status of the packet at the end of the rows, the
+// info of the OK packet is kept in the result too
+func (mc *MySQLConn) readEOFPacket(data []byte) *MySQLResult {
+	result := &MySQLResult{status: mc.status}
+
+	if !mc.deprecateEOF() {
+		// warning count [2 bytes], server_status [2 bytes]
+		if len(data) >= 5 {
+			result.status = readStatus(data[3:])
+		}
+		mc.status = result.status
+		return result
+	}
+
+	// affected rows, insert id [Length Coded Binary]
+	_, _, n := readLengthEncodedInteger(data[1:])
+	_, _, m := readLengthEncodedInteger(data[1+n:])
+	pos := 1 + n + m
+
+	// server_status [2 bytes], warning count [2 bytes]
+	if pos+2 <= len(data) {
+		result.status = readStatus(data[pos:])
+	}
+	if pos+4 < len(data) {
+		// a malformed state is not worth failing the rows
+		result.statusInfo, result.stateChanges, _ = mc.readOKInfo(data[pos+4:], result.status)
+	}
+
+	mc.status = result.status
+	return result
 }
 
 /******************************************************************************
@@ -947,9 +1046,41 @@ func (stmt *mysqlStmt) writeCommandLongData(paramID int, arg []byte) error {
 	return nil
 }
 
+// Fetch Rows of Cursor
+// http://dev.mysql.com/doc/internals/en/com-stmt-fetch.html
+func (stmt *mysqlStmt) writeCommandFetch(n uint32) error {
+	// Reset Packet Sequence
+	stmt.mc.sequence = 0
+
+	data := stmt.mc.buf.takeSmallBuffer(4 + 1 + 4 + 4)
+	if data == nil {
+		// can not take the buffer. Something must be wrong with the connection
+		errLog.Print(ErrBusyBuffer)
+		return driver.ErrBadConn
+	}
+
+	// Add command byte [1 byte]
+	data[4] = comStmtFetch
+
+	// Add stmtID [32 bit]
+	data[5] = byte(stmt.id)
+	data[6] = byte(stmt.id >> 8)
+	data[7] = byte(stmt.id >> 16)
+	data[8] = byte(stmt.id >> 24)
+
+	// Add number of rows [32 bit]
+	data[9] = byte(n)
+	data[10] = byte(n >> 8)
+	data[11] = byte(n >> 16)
+	data[12] = byte(n >> 24)
+
+	// Send CMD packet
+	return stmt.mc.writePacket(data)
+}
+
 // Execute Prepared Statement
 // http://dev.mysql.com/doc/internals/en/com-stmt-execute.html
-func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
+func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value, cursorType byte) error {
 	raw := false
 	var rawParams []byte
 	if len(args) == 1 {
@@ -997,8 +1128,8 @@ func (stmt *mysqlStmt) writeExecutePacket(args []driver.Value) error {
 	data[7] = byte(stmt.id >> 16)
 	data[8] = byte(stmt.id >> 24)
 
-	// flags (0: CURSOR_TYPE_NO_CURSOR) [1 byte]
-	data[9] = 0x00
+	// flags (cursor type) [1 byte]
+	data[9] = cursorType
 
 	// iteration_count (uint32(1)) [4 bytes]
 	data[10] = 0x01
@@ -1177,7 +1308,7 @@ func (mc *MySQLConn) discardResults() error {
 		}
 		if resLen > 0 {
 			// columns
-			if err := mc.readUntilEOF(); err != nil {
+			if err := mc.skipColumns(resLen); err != nil {
 				return err
 			}
 			// rows
@@ -1193,26 +1324,27 @@ func (mc *MySQLConn) discardResults() error {
 
 // http://dev.mysql.com/doc/internals/en/binary-protocol-resultset-row.html
 func (rows *BinaryRows) readRow(dest []driver.Value) error {
-	data, err := rows.mc.readPacket()
+	data, err := rows.readPacket()
 	if err != nil {
 		return err
 	}
 
 	// packet indicator [1 byte]
 	if data[0] != iOK {
+		mc := rows.mc
+		rows.mc = nil
+
 		// EOF Packet
-		if data[0] == iEOF && len(data) == 5 {
-			rows.mc.status = readStatus(data[3:])
-			if err := rows.mc.discardResults(); err != nil {
+		if mc.isEOFPacket(data) {
+			rows.eof = mc.readEOFPacket(data)
+			if err := mc.discardResults(); err != nil {
 				return err
 			}
-			rows.mc = nil
 			return io.EOF
 		}
-		rows.mc = nil
 
 		// Error otherwise
-		return rows.mc.handleErrorPacket(data)
+		return mc.handleErrorPacket(data)
 	}
 
 	// NULL-bitmap,  [(column-count + 7 + 2) / 8 bytes]
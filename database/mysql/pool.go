@@ -16,8 +16,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"runtime"
 	"sort"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -231,8 +233,25 @@ type DB struct {
 	// connections in Stmt.css.
 	numClosed uint64
 
+	// waitCount and waitDuration are accessed atomically, they count how many
+	// times and how long conn waited for a free connection.
+	waitCount    int64
+	waitDuration int64
+
 	aliveStatus bool // indicate the result heartbeat detected the healthy of the db
 
+	// replicationLag is the seconds the slave is behind its master, -1 means
+	// unknown or the replication stopped. maxReplicationLag <= 0 means the
+	// lag is not checked. Both are accessed atomically.
+	replicationLag    int64
+	maxReplicationLag int64
+
+	// weight of the node in load balancing, and the exponentially weighted
+	// moving average of the query latency in nanoseconds, as float64 bits.
+	// Both are accessed atomically.
+	weight  int64
+	latency uint64
+
 	mu     sync.Mutex  // protects following fields
 	hbConn *driverConn //heart beat Conn
 
@@ -278,6 +297,10 @@ type driverConn struct {
 	finalClosed bool // ci.Close has been called
 	openStmt    map[driver.Stmt]bool
 
+	// vars applied to the connection by the sessions, it is accessed only by
+	// the holder of the connection
+	vars map[string]string
+
 	// guarded by db.mu
 	inUse          bool
 	onPut          []func() // code (with db.mu held) run when conn is next returned
@@ -527,13 +550,16 @@ func (db *DB) Ping() error {
 	return nil
 }
 
-//heartbeat ping verifies the health of a db,
-//establish if the conn is nil
+//heartbeat ping verifies the health of a db by a COM_PING on a dedicated
+//conn, establish the conn if it is nil or broken
 func (db *DB) HeartBeatPing() error {
-	// var dc *driverConn
-	if db.hbConn == nil {
-		dc, err := db.conn(cachedOrNewConn)
-		if err != nil {
+	db.mu.Lock()
+	dc := db.hbConn
+	db.mu.Unlock()
+
+	if dc == nil {
+		var err error
+		if dc, err = db.conn(cachedOrNewConn); err != nil {
 			return err
 		}
 		db.mu.Lock()
@@ -541,15 +567,21 @@ func (db *DB) HeartBeatPing() error {
 		db.mu.Unlock()
 	}
 
-	if broken, err := db.hbConn.isIdleConnectionBroken(); broken {
-		db.mu.Unlock()
-		db.hbConn.Close()
-		db.hbConn = nil
+	var err error
+	dc.Lock()
+	if mc, ok := dc.ci.(*MySQLConn); ok {
+		err = mc.Ping()
+	}
+	dc.Unlock()
+
+	if err != nil {
 		db.mu.Lock()
-		log.Warnf("close db(%s) broken db", db.dsn)
-		return err
+		db.hbConn = nil
+		db.mu.Unlock()
+		dc.Close()
+		log.Warnf("close db(%s) broken heartbeat conn", db.dsn)
 	}
-	return nil
+	return err
 }
 
 // Close closes the database, releasing any open resources.
@@ -569,11 +601,16 @@ func (db *DB) Close() error {
 		fns = append(fns, dc.closeDBLocked())
 	}
 	db.freeConn = nil
+	hbConn := db.hbConn
+	db.hbConn = nil
 	db.closed = true
 	for _, req := range db.connRequests {
 		close(req)
 	}
 	db.mu.Unlock()
+	if hbConn != nil {
+		hbConn.Close()
+	}
 	for _, fn := range fns {
 		err1 := fn()
 		if err1 != nil {
@@ -641,6 +678,151 @@ func (db *DB) GetDbAliveStatus() bool {
 	return db.aliveStatus
 }
 
+// SetWeight sets the weight of the node in load balancing, a weight <= 0 is
+// taken as 1 since most configs leave it unset.
+func (db *DB) SetWeight(weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	atomic.StoreInt64(&db.weight, int64(weight))
+}
+
+func (db *DB) Weight() int64 {
+	if w := atomic.LoadInt64(&db.weight); w > 0 {
+		return w
+	}
+	return 1
+}
+
+// latencyDecay is the weight of the history in the latency EWMA
+const latencyDecay = 0.8
+
+// ObserveLatency adds a sample of the query latency to the moving average,
+// it is called by every query.
+func (db *DB) ObserveLatency(d time.Duration) {
+	for {
+		old := atomic.LoadUint64(&db.latency)
+		ewma := math.Float64frombits(old)
+		if ewma == 0 {
+			ewma = float64(d)
+		} else {
+			ewma = ewma*latencyDecay + float64(d)*(1-latencyDecay)
+		}
+
+		if atomic.CompareAndSwapUint64(&db.latency, old, math.Float64bits(ewma)) {
+			return
+		}
+	}
+}
+
+// Latency returns the moving average of the query latency, 0 if no query
+// has been done yet.
+func (db *DB) Latency() time.Duration {
+	return time.Duration(math.Float64frombits(atomic.LoadUint64(&db.latency)))
+}
+
+// SetMaxReplicationLag sets the seconds the slave could be behind its master
+// before taken out of rotation, <= 0 means the lag is not checked.
+func (db *DB) SetMaxReplicationLag(seconds int) {
+	atomic.StoreInt64(&db.maxReplicationLag, int64(seconds))
+}
+
+func (db *DB) MaxReplicationLag() int64 {
+	return atomic.LoadInt64(&db.maxReplicationLag)
+}
+
+func (db *DB) SetReplicationLag(seconds int64) {
+	atomic.StoreInt64(&db.replicationLag, seconds)
+}
+
+// ReplicationLag returns the seconds behind the master found by the last
+// CheckReplicationLag, -1 means unknown.
+func (db *DB) ReplicationLag() int64 {
+	return atomic.LoadInt64(&db.replicationLag)
+}
+
+// Lagging reports whether the slave is behind its master more than the max
+// replication lag, or the lag is unknown.
+func (db *DB) Lagging() bool {
+	max := db.MaxReplicationLag()
+	if max <= 0 {
+		return false
+	}
+
+	lag := db.ReplicationLag()
+	return lag < 0 || lag > max
+}
+
+// CheckReplicationLag reads Seconds_Behind_Master from SHOW SLAVE STATUS and
+// saves it as the replication lag, the lag is -1 if the replication is not
+// running or the status can not be read.
+func (db *DB) CheckReplicationLag() (int64, error) {
+	lag, err := db.readReplicationLag()
+	if err != nil {
+		lag = -1
+	}
+
+	db.SetReplicationLag(lag)
+	return lag, err
+}
+
+func (db *DB) readReplicationLag() (int64, error) {
+	status, err := db.SlaveStatus()
+	if err != nil {
+		return -1, err
+	}
+
+	lag, ok := status["Seconds_Behind_Master"]
+	if !ok {
+		return -1, errors.New("no Seconds_Behind_Master in slave status")
+	}
+
+	// NULL means the replication threads are not running
+	if !lag.Valid {
+		return -1, errors.New("replication is not running")
+	}
+
+	return strconv.ParseInt(lag.String, 10, 64)
+}
+
+// SlaveStatus returns the columns of SHOW SLAVE STATUS, an error is returned
+// if the replication is not configured.
+func (db *DB) SlaveStatus() (map[string]NullString, error) {
+	rows, err := db.Query("SHOW SLAVE STATUS")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("replication is not configured")
+	}
+
+	values := make([]RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	status := make(map[string]NullString, len(columns))
+	for i, c := range columns {
+		status[c] = NullString{String: string(values[i]), Valid: values[i] != nil}
+	}
+	return status, nil
+}
+
 // SetMaxOpenConns sets the maximum number of open connections to the database.
 //
 // If MaxIdleConns is greater than 0 and the new MaxOpenConns is less than
@@ -669,15 +851,39 @@ type DBStats struct {
 	OpenConnections int
 	FreeConnections int
 	AliveStatus     bool
+
+	// MaxOpenConnections is the limit of open connections, <= 0 means unlimited
+	MaxOpenConnections int
+	// WaitCount is the total number of connections waited for, WaitDuration
+	// is the total time blocked waiting for a connection.
+	WaitCount    int64
+	WaitDuration time.Duration
+
+	// ReplicationLag is the seconds the slave is behind its master, -1 means
+	// unknown. Lagging is true if the lag is over the max replication lag.
+	ReplicationLag int64
+	Lagging        bool
+
+	// Weight is the weight in load balancing, Latency is the moving average
+	// of the query latency.
+	Weight  int64
+	Latency time.Duration
 }
 
 // Stats returns database statistics.
 func (db *DB) Stats() DBStats {
 	db.mu.Lock()
 	stats := DBStats{
-		OpenConnections: db.numOpen,
-		FreeConnections: len(db.freeConn),
-		AliveStatus:     db.aliveStatus,
+		OpenConnections:    db.numOpen,
+		FreeConnections:    len(db.freeConn),
+		AliveStatus:        db.aliveStatus,
+		MaxOpenConnections: db.maxOpen,
+		WaitCount:          atomic.LoadInt64(&db.waitCount),
+		WaitDuration:       time.Duration(atomic.LoadInt64(&db.waitDuration)),
+		ReplicationLag:     db.ReplicationLag(),
+		Lagging:            db.Lagging(),
+		Weight:             db.Weight(),
+		Latency:            db.Latency(),
 	}
 	db.mu.Unlock()
 	return stats
@@ -746,6 +952,11 @@ type connRequest struct {
 
 var errDBClosed = errors.New("sql: database is closed")
 
+func (db *DB) recordWait(start time.Time) {
+	atomic.AddInt64(&db.waitCount, 1)
+	atomic.AddInt64(&db.waitDuration, int64(time.Since(start)))
+}
+
 // conn returns a newly-opened or cached *driverConn.
 func (db *DB) conn(strategy connReuseStrategy) (*driverConn, error) {
 	db.mu.Lock()
@@ -799,15 +1010,18 @@ func (db *DB) conn(strategy connReuseStrategy) (*driverConn, error) {
 		db.mu.Unlock()
 		log.Warn("the conn is Ful!,wait for conn free", db.Dsn())
 		// ret := <-req
+		waitStart := time.Now()
 		timeout := time.After(time.Second * time.Duration(connReqTimeOut))
 		select {
 		case ret := <-req:
+			db.recordWait(waitStart)
 			if ret.err == nil {
 				ret.conn.lastActiveTime = time.Now()
 				return ret.conn, ret.err
 			}
 
 		case <-timeout:
+			db.recordWait(waitStart)
 			log.Warnf("freeconn status: opennum :%d, maxOpen :%d", db.numOpen, db.maxOpen)
 			return nil, errConnPoolTimeOut
 
@@ -969,28 +1183,32 @@ const maxBadConnRetries = 2
 // The caller must call the statement's Close method
 // when the statement is no longer needed.
 func (db *DB) Prepare(query string) (*Stmt, error) {
+	return db.prepareVars(query, nil)
+}
+
+func (db *DB) prepareVars(query string, vars *SessionVars) (*Stmt, error) {
 	var stmt *Stmt
 	var err error
 	for i := 0; i < maxBadConnRetries; i++ {
-		stmt, err = db.prepare(query, cachedOrNewConn)
+		stmt, err = db.prepare(query, vars, cachedOrNewConn)
 		if err != driver.ErrBadConn {
 			break
 		}
 	}
 	if err == driver.ErrBadConn {
-		return db.prepare(query, alwaysNewConn)
+		return db.prepare(query, vars, alwaysNewConn)
 	}
 	return stmt, err
 }
 
-func (db *DB) prepare(query string, strategy connReuseStrategy) (*Stmt, error) {
+func (db *DB) prepare(query string, vars *SessionVars, strategy connReuseStrategy) (*Stmt, error) {
 	// TODO: check if db.driver supports an optional
 	// driver.Preparer interface and call that instead, if so,
 	// otherwise we make a prepared statement that's bound
 	// to a connection, and to execute this prepared statement
 	// we either need to use this connection (if it's free), else
 	// get a new connection + re-prepare + execute on that one.
-	dc, err := db.conn(strategy)
+	dc, err := db.varsConn(strategy, vars)
 	if err != nil {
 		return nil, err
 	}
@@ -1003,6 +1221,7 @@ func (db *DB) prepare(query string, strategy connReuseStrategy) (*Stmt, error) {
 	}
 	stmt := &Stmt{
 		db:            db,
+		vars:          vars,
 		query:         query,
 		css:           []connStmt{{dc, si}},
 		lastNumClosed: atomic.LoadUint64(&db.numClosed),
@@ -1018,22 +1237,26 @@ func (db *DB) prepare(query string, strategy connReuseStrategy) (*Stmt, error) {
 // Exec executes a query without returning any rows.
 // The args are for any placeholder parameters in the query.
 func (db *DB) Exec(query string, args ...interface{}) (Result, error) {
+	return db.execVars(query, args, nil)
+}
+
+func (db *DB) execVars(query string, args []interface{}, vars *SessionVars) (Result, error) {
 	var res Result
 	var err error
 	for i := 0; i < maxBadConnRetries; i++ {
-		res, err = db.exec(query, args, cachedOrNewConn)
+		res, err = db.exec(query, args, vars, cachedOrNewConn)
 		if err != driver.ErrBadConn {
 			break
 		}
 	}
 	if err == driver.ErrBadConn {
-		return db.exec(query, args, alwaysNewConn)
+		return db.exec(query, args, vars, alwaysNewConn)
 	}
 	return res, err
 }
 
-func (db *DB) exec(query string, args []interface{}, strategy connReuseStrategy) (res Result, err error) {
-	dc, err := db.conn(strategy)
+func (db *DB) exec(query string, args []interface{}, vars *SessionVars, strategy connReuseStrategy) (res Result, err error) {
+	dc, err := db.varsConn(strategy, vars)
 	if err != nil {
 		return nil, err
 	}
@@ -1041,13 +1264,21 @@ func (db *DB) exec(query string, args []interface{}, strategy connReuseStrategy)
 		db.putConn(dc, err)
 	}()
 
+	return db.execConn(dc, query, args)
+}
+
+// execConn executes a query on the given connection, the connection is not
+// released.
+func (db *DB) execConn(dc *driverConn, query string, args []interface{}) (Result, error) {
 	if execer, ok := dc.ci.(driver.Execer); ok {
 		dargs, err := driverArgs(nil, args)
 		if err != nil {
 			return nil, err
 		}
 		dc.Lock()
+		start := time.Now()
 		resi, err := execer.Exec(query, dargs)
+		db.ObserveLatency(time.Since(start))
 		dc.Unlock()
 
 		if err != driver.ErrSkip {
@@ -1073,22 +1304,26 @@ func (db *DB) exec(query string, args []interface{}, strategy connReuseStrategy)
 // Query executes a query that returns rows, typically a SELECT.
 // The args are for any placeholder parameters in the query.
 func (db *DB) Query(query string, args ...interface{}) (Rows, error) {
+	return db.queryVars(query, args, nil)
+}
+
+func (db *DB) queryVars(query string, args []interface{}, vars *SessionVars) (Rows, error) {
 	var rows *sqlrows
 	var err error
 	for i := 0; i < maxBadConnRetries; i++ {
-		rows, err = db.query(query, args, cachedOrNewConn)
+		rows, err = db.query(query, args, vars, cachedOrNewConn)
 		if err != driver.ErrBadConn {
 			break
 		}
 	}
 	if err == driver.ErrBadConn {
-		return db.query(query, args, alwaysNewConn)
+		return db.query(query, args, vars, alwaysNewConn)
 	}
 	return rows, err
 }
 
-func (db *DB) query(query string, args []interface{}, strategy connReuseStrategy) (*sqlrows, error) {
-	ci, err := db.conn(strategy)
+func (db *DB) query(query string, args []interface{}, vars *SessionVars, strategy connReuseStrategy) (*sqlrows, error) {
+	ci, err := db.varsConn(strategy, vars)
 	if err != nil {
 		return nil, err
 	}
@@ -1106,7 +1341,9 @@ func (db *DB) queryConn(dc *driverConn, releaseConn func(error), query string, a
 			return nil, err
 		}
 		dc.Lock()
+		start := time.Now()
 		rowsi, err := queryer.Query(query, dargs)
+		db.ObserveLatency(time.Since(start))
 		dc.Unlock()
 		if err != driver.ErrSkip {
 			if err != nil {
@@ -1162,23 +1399,27 @@ func (db *DB) QueryRow(query string, args ...interface{}) *Row {
 }
 
 func (db *DB) FieldList(table string, wild string) (Rows, error) {
+	return db.fieldlistVars(table, wild, nil)
+}
+
+func (db *DB) fieldlistVars(table string, wild string, vars *SessionVars) (Rows, error) {
 	var rows *sqlrows
 	var err error
 	for i := 0; i < maxBadConnRetries; i++ {
-		rows, err = db.fieldlist(table, wild, cachedOrNewConn)
+		rows, err = db.fieldlist(table, wild, vars, cachedOrNewConn)
 		if err != driver.ErrBadConn {
 			break
 		}
 	}
 	if err == driver.ErrBadConn {
-		return db.fieldlist(table, wild, alwaysNewConn)
+		return db.fieldlist(table, wild, vars, alwaysNewConn)
 	}
 
 	return rows, err
 }
 
-func (db *DB) fieldlist(table string, wild string, strategy connReuseStrategy) (*sqlrows, error) {
-	dc, err := db.conn(strategy)
+func (db *DB) fieldlist(table string, wild string, vars *SessionVars, strategy connReuseStrategy) (*sqlrows, error) {
+	dc, err := db.varsConn(strategy, vars)
 	if err != nil {
 		return nil, err
 	}
@@ -1208,22 +1449,26 @@ func (db *DB) fieldlist(table string, wild string, strategy connReuseStrategy) (
 // Begin starts a transaction. The isolation level is dependent on
 // the driver.
 func (db *DB) Begin() (*Tx, error) {
+	return db.beginVars(nil)
+}
+
+func (db *DB) beginVars(vars *SessionVars) (*Tx, error) {
 	var tx *Tx
 	var err error
 	for i := 0; i < maxBadConnRetries; i++ {
-		tx, err = db.begin(cachedOrNewConn)
+		tx, err = db.begin(vars, cachedOrNewConn)
 		if err != driver.ErrBadConn {
 			break
 		}
 	}
 	if err == driver.ErrBadConn {
-		return db.begin(alwaysNewConn)
+		return db.begin(vars, alwaysNewConn)
 	}
 	return tx, err
 }
 
-func (db *DB) begin(strategy connReuseStrategy) (tx *Tx, err error) {
-	dc, err := db.conn(strategy)
+func (db *DB) begin(vars *SessionVars, strategy connReuseStrategy) (tx *Tx, err error) {
+	dc, err := db.varsConn(strategy, vars)
 	if err != nil {
 		return nil, err
 	}
@@ -1241,6 +1486,46 @@ func (db *DB) begin(strategy connReuseStrategy) (tx *Tx, err error) {
 	}, nil
 }
 
+// Warm opens new connections into the pool until n of them are idle, bounded
+// by the max idle and open connections. It pre-warms the pool before the
+// sessions come.
+func (db *DB) Warm(n int) error {
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return errDBClosed
+	}
+	if maxIdle := db.maxIdleConnsLocked(); n > maxIdle {
+		n = maxIdle
+	}
+	need := n - len(db.freeConn)
+	if db.maxOpen > 0 && need > db.maxOpen-db.numOpen {
+		need = db.maxOpen - db.numOpen
+	}
+	db.mu.Unlock()
+
+	// enough idle connections already, or the pool is full
+	if need <= 0 {
+		return nil
+	}
+
+	var err error
+	conns := make([]*driverConn, 0, need)
+	for i := 0; i < need; i++ {
+		dc, e := db.conn(alwaysNewConn)
+		if e != nil {
+			err = e
+			break
+		}
+		conns = append(conns, dc)
+	}
+
+	for _, dc := range conns {
+		db.putConn(dc, nil)
+	}
+	return err
+}
+
 // Probe and close idle connection when connection is timeout or broken
 func (db *DB) ProbeIdleConnection(idleTimeout int) error {
 	// log.Debugf("Probe idle connections with db(%s) start", db.dsn)
@@ -1321,6 +1606,9 @@ type Tx struct {
 		sync.Mutex
 		v []*Stmt
 	}
+
+	// the session state changes of the last Commit or Rollback
+	stateChanges []SessionStateChange
 }
 
 var ErrTxDone = errors.New("sql: Transaction has already been committed or rolled back")
@@ -1362,6 +1650,7 @@ func (tx *Tx) Commit(inAutoCommit bool) error {
 	}
 	tx.dc.Lock()
 	err := tx.txi.Commit()
+	tx.readSessionState()
 	tx.dc.Unlock()
 
 	if inAutoCommit { // when in autocommit
@@ -1384,6 +1673,7 @@ func (tx *Tx) Rollback(inAutoCommit bool) error {
 	}
 	tx.dc.Lock()
 	err := tx.txi.Rollback()
+	tx.readSessionState()
 	tx.dc.Unlock()
 	if inAutoCommit { // when in autocommit
 		if err != driver.ErrBadConn {
@@ -1393,6 +1683,19 @@ func (tx *Tx) Rollback(inAutoCommit bool) error {
 	return err
 }
 
+func (tx *Tx) readSessionState() {
+	tx.stateChanges = nil
+	if sr, ok := tx.txi.(sessionStateResult); ok {
+		tx.stateChanges = sr.SessionState()
+	}
+}
+
+// SessionState returns the session state changes reported by the last
+// Commit or Rollback, e.g. the GTID of the transaction committed.
+func (tx *Tx) SessionState() []SessionStateChange {
+	return tx.stateChanges
+}
+
 // Prepare creates a prepared statement for use within a transaction.
 //
 // The returned statement operates within the transaction and can no longer
@@ -1501,7 +1804,9 @@ func (tx *Tx) Exec(query string, args ...interface{}) (Result, error) {
 			return nil, err
 		}
 		dc.Lock()
+		start := time.Now()
 		resi, err := execer.Exec(query, dargs)
+		tx.db.ObserveLatency(time.Since(start))
 		dc.Unlock()
 		if err != nil && err != driver.ErrSkip {
 			if _, ok := err.(MySQLWarnings); !ok {
@@ -1554,8 +1859,9 @@ type connStmt struct {
 // A Stmt is safe for concurrent use by multiple goroutines.
 type Stmt struct {
 	// Immutable:
-	db        *DB    // where we came from
-	query     string // that created the Stmt
+	db        *DB          // where we came from
+	vars      *SessionVars // applied to the connections the Stmt runs on
+	query     string       // that created the Stmt
 	stickyErr error  // if non-nil, this error is returned for all operations
 
 	closemu sync.RWMutex // held exclusively during close, for read otherwise.
@@ -1582,6 +1888,8 @@ type Stmt struct {
 	ID      uint32
 
 	SQL interface{}
+	// Fingerprint of the query, checked by the flow control on executions
+	Fingerprint string
 }
 
 // Exec executes a prepared statement with the given arguments and
@@ -1701,7 +2009,7 @@ func (s *Stmt) connStmt() (ci *driverConn, releaseConn func(error), si driver.St
 	s.mu.Unlock()
 
 	// TODO(bradfitz): or always wait for one? make configurable later?
-	dc, err := s.db.conn(cachedOrNewConn)
+	dc, err := s.db.varsConn(cachedOrNewConn, s.vars)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -1773,6 +2081,23 @@ func (s *Stmt) Query(args ...interface{}) (*sqlrows, error) {
 }
 
 func rowsiFromStatement(ds driverStmt, args ...interface{}) (driver.Rows, error) {
+	dargs, err := statementArgs(ds, args)
+	if err != nil {
+		return nil, err
+	}
+
+	ds.Lock()
+	rowsi, err := ds.si.Query(dargs)
+	ds.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return rowsi, nil
+}
+
+// statementArgs checks the number of args and converts them to the driver
+// values
+func statementArgs(ds driverStmt, args []interface{}) ([]driver.Value, error) {
 	ds.Lock()
 	want := ds.si.NumInput()
 	ds.Unlock()
@@ -1790,18 +2115,120 @@ func rowsiFromStatement(ds driverStmt, args ...interface{}) (driver.Rows, error)
 		}
 	}
 
-	dargs, err := driverArgs(&ds, args)
-	if err != nil {
-		return nil, err
+	return driverArgs(&ds, args)
+}
+
+// OpenCursor executes a prepared query statement with a read only cursor on
+// the server. If the cursor is opened, the connection is held by the *Cursor
+// until it is closed, otherwise the results are returned as a *Rows like
+// Query.
+func (s *Stmt) OpenCursor(args ...interface{}) (*Cursor, *sqlrows, error) {
+	s.closemu.RLock()
+	defer s.closemu.RUnlock()
+
+	for i := 0; i < maxBadConnRetries; i++ {
+		dc, releaseConn, si, err := s.connStmt()
+		if err != nil {
+			if err == driver.ErrBadConn {
+				continue
+			}
+			return nil, nil, err
+		}
+
+		ds := driverStmt{dc, si}
+		dargs, err := statementArgs(ds, args)
+		if err != nil {
+			releaseConn(err)
+			return nil, nil, err
+		}
+
+		ds.Lock()
+		rowsi, opened, err := si.OpenCursor(dargs)
+		ds.Unlock()
+		if err == nil {
+			if opened {
+				cursor := &Cursor{ds: ds, columns: rowsi.DumpColumns()}
+				s.db.addDep(s, cursor)
+				cursor.releaseConn = func(err error) {
+					releaseConn(err)
+					s.db.removeDep(s, cursor)
+				}
+				return cursor, nil, nil
+			}
+
+			rows := &sqlrows{
+				dc:    dc,
+				rowsi: rowsi,
+			}
+			s.db.addDep(s, rows)
+			rows.releaseConn = func(err error) {
+				releaseConn(err)
+				s.db.removeDep(s, rows)
+			}
+			return nil, rows, nil
+		}
+
+		releaseConn(err)
+		if err != driver.ErrBadConn {
+			return nil, nil, err
+		}
 	}
+	return nil, nil, driver.ErrBadConn
+}
 
-	ds.Lock()
-	rowsi, err := ds.si.Query(dargs)
-	ds.Unlock()
+// Cursor is a cursor opened on the server by Stmt.OpenCursor, the rows are
+// fetched from the connection it holds until it is closed.
+type Cursor struct {
+	ds          driverStmt
+	releaseConn func(error)
+	columns     []driver.RawPacket
+
+	closed bool
+}
+
+// ColumnPackets returns the column definitions of the cursor
+func (c *Cursor) ColumnPackets() []driver.RawPacket {
+	return c.columns
+}
+
+// Fetch fetches at most n rows of the cursor, the cursor is closed after the
+// last row is fetched, which is reported by last.
+func (c *Cursor) Fetch(n uint32) (rows []driver.RawPacket, last bool, err error) {
+	if c.closed {
+		return nil, false, errors.New("sql: Cursor is closed")
+	}
+
+	c.ds.Lock()
+	rows, last, err = c.ds.si.Fetch(n)
+	c.ds.Unlock()
 	if err != nil {
-		return nil, err
+		c.close(err)
+		return nil, false, err
 	}
-	return rowsi, nil
+
+	if last {
+		// the server has closed the cursor
+		c.close(nil)
+	}
+	return rows, last, nil
+}
+
+// Close closes the cursor on the server and releases the connection.
+func (c *Cursor) Close() error {
+	if c.closed {
+		return nil
+	}
+
+	c.ds.Lock()
+	_, err := c.ds.si.Reset()
+	c.ds.Unlock()
+	c.close(err)
+	return err
+}
+
+func (c *Cursor) close(err error) {
+	c.closed = true
+	c.releaseConn(err)
 }
 
 // QueryRow executes a prepared query statement with the given arguments.
@@ -1958,6 +2385,9 @@ type Rows interface {
 	Close() error
 	Err() error
 	Columns() ([]string, error)
+	NextResultSet() bool
+	Result() Result
+	EOFResult() Result
 }
 
 type sqlrows struct {
@@ -1970,6 +2400,7 @@ type sqlrows struct {
 	lastrow   driver.RawPacket
 	lasterr   error       // non-nil only if closed is true
 	closeStmt driver.Stmt // if non-nil, statement to Close on close
+	eof       Result      // the status at the end of the rows, see EOFResult
 }
 
 // Next prepares the next result row for reading with the Scan method.  It
@@ -1987,7 +2418,10 @@ func (rs *sqlrows) Next() bool {
 	}
 	rs.lasterr = rs.rowsi.Next(rs.lastcols)
 	if rs.lasterr != nil {
-		rs.Close()
+		rs.readEOFResult()
+		if rs.lasterr != io.EOF || !rs.hasNextResultSet() {
+			rs.Close()
+		}
 		return false
 	}
 	return true
@@ -2001,13 +2435,93 @@ func (rs *sqlrows) NextRowPacket() (driver.RawPacket, error) {
 	var row driver.RawPacket
 	row, rs.lasterr = rs.rowsi.NextRowPacket()
 	if rs.lasterr != nil {
-		rs.Close()
+		rs.readEOFResult()
+		if rs.lasterr != io.EOF || !rs.hasNextResultSet() {
+			rs.Close()
+		}
 		return row, rs.lasterr
 	}
 
 	return row, nil
 }
 
+// hasNextResultSet reports whether another result follows the current one,
+// the rows are kept open at the end of the current one then.
+func (rs *sqlrows) hasNextResultSet() bool {
+	nextResultSet, ok := rs.rowsi.(driver.RowsNextResultSet)
+	return ok && nextResultSet.HasNextResultSet()
+}
+
+// NextResultSet prepares the next result for reading, the rows left in the
+// current result are skipped. It reports whether there is further result,
+// or false if there is no further result or an error happened while
+// advancing to it. The Err method should be consulted to distinguish between
+// the two cases.
+//
+// After calling NextResultSet, the Next method should always be called
+// before scanning. If the next result has no columns, e.g. the status at the
+// end of a CALL, its OK packet is returned by Result.
+func (rs *sqlrows) NextResultSet() bool {
+	if rs.closed {
+		return false
+	}
+	rs.lastcols = nil
+	rs.eof = nil
+
+	nextResultSet, ok := rs.rowsi.(driver.RowsNextResultSet)
+	if !ok {
+		rs.Close()
+		return false
+	}
+
+	rs.lasterr = nextResultSet.NextResultSet()
+	if rs.lasterr != nil {
+		rs.Close()
+		return false
+	}
+	return true
+}
+
+// Result returns the OK packet of the current result if it has no columns,
+// otherwise nil.
+func (rs *sqlrows) Result() Result {
+	if rs.closed {
+		return nil
+	}
+
+	nextResultSet, ok := rs.rowsi.(driver.RowsNextResultSet)
+	if !ok {
+		return nil
+	}
+
+	resi := nextResultSet.Result()
+	if resi == nil {
+		return nil
+	}
+	return driverResult{rs.dc, resi}
+}
+
+// EOFResult returns the status at the end of the rows of the current result,
+// e.g. the info of the OK packet ending the rows if the EOF packet is
+// deprecated. It is nil before the end is read, and kept after the rows are
+// closed by the end.
+func (rs *sqlrows) EOFResult() Result {
+	return rs.eof
+}
+
+// readEOFResult keeps the status at the end of the rows before they are
+// closed
+func (rs *sqlrows) readEOFResult() {
+	if rs.lasterr != io.EOF {
+		return
+	}
+	if eofResult, ok := rs.rowsi.(driver.RowsEOFResult); ok {
+		if resi := eofResult.EOFResult(); resi != nil {
+			rs.eof = resi
+		}
+	}
+}
+
 // Err returns the error, if any, that was encountered during iteration.
 // Err may be called after an explicit or implicit Close.
 func (rs *sqlrows) Err() error {
@@ -2204,6 +2718,15 @@ func (dr driverResult) Info() (string, error) {
 	return dr.resi.Info()
 }
 
+func (dr driverResult) SessionState() []SessionStateChange {
+	dr.Lock()
+	defer dr.Unlock()
+	if sr, ok := dr.resi.(sessionStateResult); ok {
+		return sr.SessionState()
+	}
+	return nil
+}
+
 func stack() string {
 	var buf [2 << 10]byte
 	return string(buf[:runtime.Stack(buf[:], false)])
@@ -0,0 +1,161 @@
+package mysql
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bytedance/dbatman/database/sql/driver"
+	"github.com/ngaut/log"
+)
+
+// WithTimeout returns an Executor runs the queries on db, a query runs longer
+// than timeout is killed by `KILL QUERY` and fails with ER_QUERY_INTERRUPTED,
+// the connection is kept in the pool. A timeout <= 0 means no timeout.
+func (db *DB) WithTimeout(timeout time.Duration) Executor {
+	if timeout <= 0 {
+		return db
+	}
+	return &timeoutExecutor{db, nil, timeout}
+}
+
+// WithTimeout is like DB.WithTimeout, the queries run with the vars applied
+func (vd *VarsDB) WithTimeout(timeout time.Duration) Executor {
+	if timeout <= 0 {
+		return vd
+	}
+	return &timeoutExecutor{vd.db, vd.vars, timeout}
+}
+
+type timeoutExecutor struct {
+	db      *DB
+	vars    *SessionVars
+	timeout time.Duration
+}
+
+func (te *timeoutExecutor) Exec(query string, args ...interface{}) (Result, error) {
+	var res Result
+	var err error
+	for i := 0; i < maxBadConnRetries; i++ {
+		res, err = te.exec(query, args, cachedOrNewConn)
+		if err != driver.ErrBadConn {
+			break
+		}
+	}
+	if err == driver.ErrBadConn {
+		return te.exec(query, args, alwaysNewConn)
+	}
+	return res, err
+}
+
+func (te *timeoutExecutor) exec(query string, args []interface{}, strategy connReuseStrategy) (res Result, err error) {
+	dc, err := te.db.varsConn(strategy, te.vars)
+	if err != nil {
+		return nil, err
+	}
+
+	w := te.db.watch(dc, te.timeout)
+	defer func() {
+		w.stop()
+		te.db.putConn(dc, err)
+	}()
+
+	return te.db.execConn(dc, query, args)
+}
+
+func (te *timeoutExecutor) Query(query string, args ...interface{}) (Rows, error) {
+	var rows *sqlrows
+	var err error
+	for i := 0; i < maxBadConnRetries; i++ {
+		rows, err = te.query(query, args, cachedOrNewConn)
+		if err != driver.ErrBadConn {
+			break
+		}
+	}
+	if err == driver.ErrBadConn {
+		return te.query(query, args, alwaysNewConn)
+	}
+	return rows, err
+}
+
+func (te *timeoutExecutor) query(query string, args []interface{}, strategy connReuseStrategy) (*sqlrows, error) {
+	dc, err := te.db.varsConn(strategy, te.vars)
+	if err != nil {
+		return nil, err
+	}
+
+	// the timeout covers reading the rows, stop watching when the rows
+	// release the connection
+	w := te.db.watch(dc, te.timeout)
+	return te.db.queryConn(dc, func(err error) {
+		w.stop()
+		dc.releaseConn(err)
+	}, query, args)
+}
+
+// Prepare is not limited by the timeout
+func (te *timeoutExecutor) Prepare(query string) (*Stmt, error) {
+	return te.db.prepareVars(query, te.vars)
+}
+
+// queryWatchdog kills the query running on a connection after a timeout
+type queryWatchdog struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	stopped bool
+}
+
+// watch starts a watchdog on dc, the query is killed from a new connection
+// out of the pool, so a full pool can not block the kill.
+func (db *DB) watch(dc *driverConn, timeout time.Duration) *queryWatchdog {
+	w := new(queryWatchdog)
+
+	mc, ok := dc.ci.(*MySQLConn)
+	if !ok {
+		return w
+	}
+
+	threadId := mc.ThreadId()
+	w.timer = time.AfterFunc(timeout, func() {
+		// hold the lock until killed, so the connection is not released
+		// and reused by another query before the kill
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.stopped {
+			return
+		}
+
+		log.Warnf("query on thread %d exceeded timeout %s, kill it", threadId, timeout)
+		if err := db.killQuery(threadId); err != nil {
+			log.Warnf("kill query on thread %d error: %s", threadId, err.Error())
+		}
+	})
+
+	return w
+}
+
+func (w *queryWatchdog) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+func (db *DB) killQuery(threadId uint32) error {
+	ci, err := db.driver.Open(db.dsn)
+	if err != nil {
+		return err
+	}
+	defer ci.Close()
+
+	execer, ok := ci.(driver.Execer)
+	if !ok {
+		return fmt.Errorf("driver %T does not support exec", ci)
+	}
+
+	_, err = execer.Exec(fmt.Sprintf("KILL QUERY %d", threadId), nil)
+	return err
+}
@@ -0,0 +1,337 @@
+package mysql
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/bytedance/dbatman/database/sql/driver"
+)
+
+// SessionVars is the system and user variables set by a client session. The
+// values are SQL literals read back from the connection the SET statement ran
+// on, so they replay the same on every pooled connection, even if they were
+// set by expressions like `SET @a = @a + 1` or `SET time_zone = @@time_zone`.
+//
+// SessionVars is not safe for concurrent use, it belongs to one session.
+type SessionVars struct {
+	vars map[string]string
+}
+
+func NewSessionVars() *SessionVars {
+	return &SessionVars{vars: make(map[string]string)}
+}
+
+// SysVar returns the name of the session scope system variable, which is
+// used to set and read the variable
+func SysVar(name string) string {
+	return "@@session." + strings.ToLower(name)
+}
+
+// UserVar returns the name of the user variable, user variables are case
+// insensitive
+func UserVar(name string) string {
+	return "@`" + strings.Replace(strings.ToLower(name), "`", "``", -1) + "`"
+}
+
+// Len returns the number of the variables set
+func (sv *SessionVars) Len() int {
+	if sv == nil {
+		return 0
+	}
+	return len(sv.vars)
+}
+
+// Get returns the literal value of the variable named by SysVar or UserVar
+func (sv *SessionVars) Get(name string) (string, bool) {
+	if sv == nil {
+		return "", false
+	}
+	v, ok := sv.vars[name]
+	return v, ok
+}
+
+// Reset clears the variables, the connections are reset the next time they
+// are borrowed by the session.
+func (sv *SessionVars) Reset() {
+	sv.vars = make(map[string]string)
+}
+
+// GetString returns the value of the variable of string type
+func (sv *SessionVars) GetString(name string) (string, bool) {
+	v, ok := sv.Get(name)
+	if !ok || len(v) < 2 || v[0] != '\'' || v[len(v)-1] != '\'' {
+		return "", false
+	}
+
+	r := strings.NewReplacer(`''`, `'`, `\'`, `'`, `\\`, `\`)
+	return r.Replace(v[1 : len(v)-1]), true
+}
+
+// SetString sets the variable to the string value
+func (sv *SessionVars) SetString(name, value string) {
+	buf := make([]byte, 0, len(value)+2)
+	buf = append(buf, '\'')
+	buf = escapeStringQuotes(buf, value)
+	sv.set(name, string(append(buf, '\'')))
+}
+
+func (sv *SessionVars) set(name, value string) {
+	if value == "NULL" && !isSysVar(name) {
+		// an user variable is NULL if it is not set
+		delete(sv.vars, name)
+		return
+	}
+	sv.vars[name] = value
+}
+
+func isSysVar(name string) bool {
+	return strings.HasPrefix(name, "@@")
+}
+
+// varUnknown is the value of a variable of the connection which is not known,
+// it never equals a literal so the variable is always set or reset.
+const varUnknown = ""
+
+// varsDiff returns the assignments turning the vars applied to a connection
+// into vars, the variables not in vars are reset to the default.
+func varsDiff(applied map[string]string, vars *SessionVars) []string {
+	var sets []string
+	if vars != nil {
+		for name, value := range vars.vars {
+			if v, ok := applied[name]; !ok || v != value {
+				sets = append(sets, name+" = "+value)
+			}
+		}
+	}
+
+	for name := range applied {
+		if _, ok := vars.Get(name); ok {
+			continue
+		}
+		if isSysVar(name) {
+			sets = append(sets, name+" = DEFAULT")
+		} else {
+			sets = append(sets, name+" = NULL")
+		}
+	}
+
+	sort.Strings(sets)
+	return sets
+}
+
+// syncVars applies vars to the connection, the variables set by the other
+// sessions are reset. A nil vars only resets the connection.
+func (dc *driverConn) syncVars(vars *SessionVars) error {
+	sets := varsDiff(dc.vars, vars)
+	if len(sets) == 0 {
+		return nil
+	}
+
+	execer, ok := dc.ci.(driver.Execer)
+	if !ok {
+		return errors.New("sql: driver does not support session variables")
+	}
+
+	dc.Lock()
+	_, err := execer.Exec("SET "+strings.Join(sets, ", "), nil)
+	dc.Unlock()
+	if err != nil {
+		// a failed SET changes nothing
+		return err
+	}
+
+	dc.vars = make(map[string]string, vars.Len())
+	if vars != nil {
+		for name, value := range vars.vars {
+			dc.vars[name] = value
+		}
+	}
+	return nil
+}
+
+// varsConn returns a connection from the pool with the vars applied
+func (db *DB) varsConn(strategy connReuseStrategy, vars *SessionVars) (*driverConn, error) {
+	dc, err := db.conn(strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := dc.syncVars(vars); err != nil {
+		db.putConn(dc, err)
+		return nil, err
+	}
+	return dc, nil
+}
+
+// setVarsConn runs the SET statement on the connection, and reads back the
+// values of the variables names into vars
+func (db *DB) setVarsConn(dc *driverConn, vars *SessionVars, query string, names []string) (Result, error) {
+	res, err := db.execConn(dc, query, nil)
+	if err != nil || len(names) == 0 {
+		return res, err
+	}
+
+	if dc.vars == nil {
+		dc.vars = make(map[string]string, len(names))
+	}
+
+	values, err := dc.readVars(names)
+	if err != nil {
+		for _, name := range names {
+			dc.vars[name] = varUnknown
+		}
+		return nil, err
+	}
+
+	for i, name := range names {
+		vars.set(name, values[i])
+		if v, ok := vars.Get(name); ok {
+			dc.vars[name] = v
+		} else {
+			delete(dc.vars, name)
+		}
+	}
+	return res, nil
+}
+
+// readVars selects the variables, and returns their values as literals
+func (dc *driverConn) readVars(names []string) ([]string, error) {
+	dc.Lock()
+	defer dc.Unlock()
+
+	mc, ok := dc.ci.(*MySQLConn)
+	if !ok {
+		return nil, errors.New("sql: driver does not support session variables")
+	}
+
+	rowsi, err := mc.Query("SELECT "+strings.Join(names, ", "), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rowsi.Close()
+
+	rows, ok := rowsi.(*TextRows)
+	if !ok || len(rows.columns) != len(names) {
+		return nil, errors.New("sql: unexpected result of session variables")
+	}
+
+	dest := make([]driver.Value, len(names))
+	if err := rows.Next(dest); err != nil {
+		if err == io.EOF {
+			err = errors.New("sql: unexpected result of session variables")
+		}
+		return nil, err
+	}
+
+	values := make([]string, len(names))
+	for i, v := range dest {
+		values[i] = varLiteral(v, rows.columns[i].FieldType, mc.status&statusNoBackslashEscapes != 0)
+	}
+	return values, nil
+}
+
+// varLiteral returns the SQL literal of the value of the column type
+func varLiteral(v driver.Value, fieldType byte, noBackslashEscapes bool) string {
+	var b []byte
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		b = []byte(fmt.Sprint(v))
+	}
+
+	switch fieldType {
+	case fieldTypeTiny, fieldTypeShort, fieldTypeLong, fieldTypeInt24, fieldTypeLongLong,
+		fieldTypeFloat, fieldTypeDouble, fieldTypeDecimal, fieldTypeNewDecimal, fieldTypeYear:
+		return string(b)
+	}
+
+	buf := make([]byte, 0, len(b)+2)
+	buf = append(buf, '\'')
+	if noBackslashEscapes {
+		buf = escapeBytesQuotes(buf, b)
+	} else {
+		buf = escapeBytesBackslash(buf, b)
+	}
+	return string(append(buf, '\''))
+}
+
+// VarsDB runs the statements of a session on the connections of the DB, with
+// the variables of the session applied.
+type VarsDB struct {
+	db   *DB
+	vars *SessionVars
+}
+
+// WithVars returns the DB of the session with the vars
+func (db *DB) WithVars(vars *SessionVars) *VarsDB {
+	return &VarsDB{db, vars}
+}
+
+func (vd *VarsDB) Exec(query string, args ...interface{}) (Result, error) {
+	return vd.db.execVars(query, args, vd.vars)
+}
+
+func (vd *VarsDB) Query(query string, args ...interface{}) (Rows, error) {
+	return vd.db.queryVars(query, args, vd.vars)
+}
+
+// Prepare creates a prepared statement, the statement follows the later
+// changes of the vars when it runs.
+func (vd *VarsDB) Prepare(query string) (*Stmt, error) {
+	return vd.db.prepareVars(query, vd.vars)
+}
+
+func (vd *VarsDB) FieldList(table string, wild string) (Rows, error) {
+	return vd.db.fieldlistVars(table, wild, vd.vars)
+}
+
+func (vd *VarsDB) Begin() (*Tx, error) {
+	return vd.db.beginVars(vd.vars)
+}
+
+// SetVars runs the SET statement with the vars applied, and reads back the
+// values of the variables names into the vars.
+func (vd *VarsDB) SetVars(query string, names []string) (Result, error) {
+	var res Result
+	var err error
+	for i := 0; i < maxBadConnRetries; i++ {
+		res, err = vd.setVars(query, names, cachedOrNewConn)
+		if err != driver.ErrBadConn {
+			break
+		}
+	}
+	if err == driver.ErrBadConn {
+		return vd.setVars(query, names, alwaysNewConn)
+	}
+	return res, err
+}
+
+func (vd *VarsDB) setVars(query string, names []string, strategy connReuseStrategy) (res Result, err error) {
+	dc, err := vd.db.varsConn(strategy, vd.vars)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		vd.db.putConn(dc, err)
+	}()
+
+	return vd.db.setVarsConn(dc, vd.vars, query, names)
+}
+
+// SetVars runs the SET statement in the transaction, and reads back the
+// values of the variables names into vars.
+func (tx *Tx) SetVars(vars *SessionVars, query string, names []string) (Result, error) {
+	dc, err := tx.grabConn()
+	if err != nil {
+		return nil, err
+	}
+	return tx.db.setVarsConn(dc, vars, query, names)
+}
@@ -0,0 +1,132 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVarsDiff(t *testing.T) {
+	vars := NewSessionVars()
+	vars.set(SysVar("TIME_ZONE"), "'+08:00'")
+	vars.set(UserVar("Uid"), "42")
+	vars.set(UserVar("gone"), "NULL")
+
+	if _, ok := vars.Get(UserVar("gone")); ok {
+		t.Fatal("expect the NULL user variable not tracked")
+	}
+
+	// a fresh connection
+	expect := []string{"@@session.time_zone = '+08:00'", "@`uid` = 42"}
+	if sets := varsDiff(nil, vars); !reflect.DeepEqual(sets, expect) {
+		t.Fatalf("expect %v, got %v", expect, sets)
+	}
+
+	// a connection used by another session
+	applied := map[string]string{
+		"@@session.time_zone": "'+08:00'",
+		"@@session.sql_mode":  "''",
+		"@`uid`":              "1",
+		"@`other`":            "'x'",
+		"@`unknown`":          varUnknown,
+	}
+	expect = []string{
+		"@@session.sql_mode = DEFAULT",
+		"@`other` = NULL",
+		"@`uid` = 42",
+		"@`unknown` = NULL",
+	}
+	if sets := varsDiff(applied, vars); !reflect.DeepEqual(sets, expect) {
+		t.Fatalf("expect %v, got %v", expect, sets)
+	}
+
+	// reset only
+	if sets := varsDiff(applied, nil); len(sets) != len(applied) {
+		t.Fatalf("expect all the variables reset, got %v", sets)
+	}
+	if sets := varsDiff(nil, nil); len(sets) != 0 {
+		t.Fatalf("expect nothing to apply, got %v", sets)
+	}
+}
+
+func TestVarLiteral(t *testing.T) {
+	tests := []struct {
+		v         interface{}
+		fieldType byte
+		noEscapes bool
+		expect    string
+	}{
+		{nil, fieldTypeVarString, false, "NULL"},
+		{[]byte("43"), fieldTypeLongLong, false, "43"},
+		{[]byte("1.5"), fieldTypeNewDecimal, false, "1.5"},
+		{[]byte("007"), fieldTypeVarString, false, "'007'"},
+		{[]byte("it's"), fieldTypeVarString, false, `'it\'s'`},
+		{[]byte("it's"), fieldTypeVarString, true, `'it''s'`},
+	}
+
+	for _, test := range tests {
+		if s := varLiteral(test.v, test.fieldType, test.noEscapes); s != test.expect {
+			t.Errorf("expect %s, got %s", test.expect, s)
+		}
+	}
+}
+
+func TestVarsDB_SetVars(t *testing.T) {
+	runTests(t, dsn, func(dbt *DBTest) {
+		dbt.db.SetMaxIdleConns(2)
+
+		vars := NewSessionVars()
+		vd := dbt.db.WithVars(vars)
+		if _, err := vd.SetVars("SET time_zone = '+08:00', @uid = 42", []string{SysVar("time_zone"), UserVar("uid")}); err != nil {
+			dbt.Fatal(err)
+		}
+		if _, err := vd.SetVars("SET @uid = @uid + 1", []string{UserVar("uid")}); err != nil {
+			dbt.Fatal(err)
+		}
+
+		// hold a connection, so the next query opens another one
+		tx, err := dbt.db.Begin()
+		if err != nil {
+			dbt.Fatal(err)
+		}
+		defer tx.Rollback(true)
+
+		rows, err := vd.Query("SELECT @@time_zone, @uid")
+		if err != nil {
+			dbt.Fatal(err)
+		}
+
+		var tz string
+		var uid int
+		if err := (&Row{rows: rows}).Scan(&tz, &uid); err != nil {
+			dbt.Fatal(err)
+		}
+		if tz != "+08:00" || uid != 43 {
+			dbt.Fatalf("expect +08:00 and 43, got %s and %d", tz, uid)
+		}
+
+		// the connections are reset for the others
+		var nuid NullInt64
+		if err := dbt.db.QueryRow("SELECT @uid").Scan(&nuid); err != nil {
+			dbt.Fatal(err)
+		}
+		if nuid.Valid {
+			dbt.Fatalf("expect the user variable reset, got %d", nuid.Int64)
+		}
+	})
+}
+
+func TestSessionVars_String(t *testing.T) {
+	vars := NewSessionVars()
+	vars.SetString(SysVar("sql_mode"), "it's")
+	if v, _ := vars.Get(SysVar("sql_mode")); v != `'it''s'` {
+		t.Fatalf("expect the quoted literal, got %s", v)
+	}
+	if s, ok := vars.GetString(SysVar("sql_mode")); !ok || s != "it's" {
+		t.Fatalf("expect it's, got %s", s)
+	}
+
+	vars.set(UserVar("n"), "42")
+	if _, ok := vars.GetString(UserVar("n")); ok {
+		t.Fatal("expect a number not a string")
+	}
+}
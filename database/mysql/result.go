@@ -14,6 +14,7 @@ type MySQLResult struct {
 	affectedRows int64
 	insertId     int64
 	statusInfo   string
+	stateChanges []SessionStateChange
 }
 
 func (r *MySQLResult) Status() (int64, error) {
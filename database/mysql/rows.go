@@ -71,18 +71,27 @@ type MySQLRows struct {
 	columns []MySQLField
 
 	comFieldList bool
+
+	// more is the connection to read the next result from, set at the end
+	// of the rows if SERVER_MORE_RESULTS_EXISTS
+	more *MySQLConn
+	// result is the OK packet of the current result if it has no columns
+	result *MySQLResult
+	// eof is the EOF packet at the end of the rows
+	eof *MySQLResult
 }
 
 type BinaryRows struct {
 	MySQLRows
+
+	// first is the row read ahead to tell if a cursor is opened
+	first []byte
 }
 
 type TextRows struct {
 	MySQLRows
 }
 
-type emptyRows struct{}
-
 func (rows *MySQLRows) Columns() []string {
 	columns := make([]string, len(rows.columns))
 	if rows.mc != nil && rows.mc.cfg.ColumnsWithAlias {
@@ -111,7 +120,24 @@ func (rows *MySQLRows) DumpColumns() []driver.RawPacket {
 	return pkgs
 }
 
+// EOFResult returns the status and info of the EOF packet at the end of the
+// rows, nil before the end is read
+func (rows *MySQLRows) EOFResult() driver.Result {
+	if rows.eof == nil {
+		return nil
+	}
+	return rows.eof
+}
+
 func (rows *MySQLRows) Close() error {
+	if mc := rows.more; mc != nil {
+		rows.more = nil
+		if mc.netConn == nil {
+			return ErrInvalidConn
+		}
+		return mc.discardResults()
+	}
+
 	mc := rows.mc
 	if mc == nil {
 		return nil
@@ -162,26 +188,27 @@ func (rows *BinaryRows) NextRowPacket() (driver.RawPacket, error) {
 }
 
 func (rows *BinaryRows) readRowPacket() (driver.RawPacket, error) {
-	data, err := rows.mc.readPacket()
+	data, err := rows.readPacket()
 	if err != nil {
 		return nil, err
 	}
 
 	// packet indicator [1 byte]
 	if data[0] != iOK {
+		mc := rows.mc
+		rows.mc = nil
+
 		// EOF Packet
-		if data[0] == iEOF && len(data) == 5 {
-			rows.mc.status = readStatus(data[3:])
-			if err := rows.mc.discardResults(); err != nil {
+		if mc.isEOFPacket(data) {
+			rows.eof = mc.readEOFPacket(data)
+			if err := mc.discardResults(); err != nil {
 				return nil, err
 			}
-			rows.mc = nil
 			return nil, io.EOF
 		}
-		rows.mc = nil
 
 		// Error otherwise
-		return nil, rows.mc.handleErrorPacket(data)
+		return nil, mc.handleErrorPacket(data)
 	}
 	// ret := append(make([]byte, PacketHeaderLen, len(data)+PacketHeaderLen), data...)
 	// log.Debug("the packet to write is:", ret, len(ret))
@@ -190,6 +217,15 @@ func (rows *BinaryRows) readRowPacket() (driver.RawPacket, error) {
 	return append(make([]byte, PacketHeaderLen, len(data)+PacketHeaderLen), data...), nil
 }
 
+// readPacket returns the row read ahead if any before reading the next one
+func (rows *BinaryRows) readPacket() ([]byte, error) {
+	if data := rows.first; data != nil {
+		rows.first = nil
+		return data, nil
+	}
+	return rows.mc.readPacket()
+}
+
 func (rows *TextRows) Next(dest []driver.Value) error {
 	if mc := rows.mc; mc != nil {
 		if mc.netConn == nil {
@@ -221,18 +257,13 @@ func (rows *TextRows) readRowPacket() (driver.RawPacket, error) {
 	}
 
 	// EOF Packet
-	if data[0] == iEOF && len(data) == 5 {
-		// server_status [2 bytes]
-		rows.mc.status = readStatus(data[3:])
-		if err := rows.mc.discardResults(); err != nil {
-			return nil, err
-		}
-		rows.mc = nil
-		return nil, io.EOF
+	if rows.mc.isEOFPacket(data) {
+		return nil, rows.readEOF(data)
 	}
 	if data[0] == iERR {
+		mc := rows.mc
 		rows.mc = nil
-		return nil, rows.mc.handleErrorPacket(data)
+		return nil, mc.handleErrorPacket(data)
 	}
 
 	// // TODO IMP MEM used 30% reuse the MEM
@@ -250,22 +281,82 @@ func (rows *TextRows) readRowPacket() (driver.RawPacket, error) {
 	return append(make([]byte, PacketHeaderLen, len(data)+PacketHeaderLen), data...), nil
 }
 
-func (rows emptyRows) Columns() []string {
-	return nil
-}
+// readEOF reads the EOF packet at the end of the rows, the results following
+// are kept for NextResultSet, and discarded by Close.
+func (rows *TextRows) readEOF(data []byte) error {
+	mc := rows.mc
+	rows.mc = nil
 
-func (rows emptyRows) Close() error {
-	return nil
+	rows.eof = mc.readEOFPacket(data)
+	if mc.status&statusMoreResultsExists != 0 {
+		rows.more = mc
+	}
+	return io.EOF
 }
 
-func (rows emptyRows) Next(dest []driver.Value) error {
-	return io.EOF
+func (rows *TextRows) HasNextResultSet() bool {
+	return rows.more != nil
 }
 
-func (rows emptyRows) DumpColumns() []driver.RawPacket {
+func (rows *TextRows) NextResultSet() error {
+	if mc := rows.mc; mc != nil {
+		// skip the rows left
+		if mc.netConn == nil {
+			return ErrInvalidConn
+		}
+		if err := mc.readUntilEOF(); err != nil {
+			return err
+		}
+		rows.mc = nil
+		if mc.status&statusMoreResultsExists != 0 {
+			rows.more = mc
+		}
+	}
+
+	mc := rows.more
+	if mc == nil {
+		return io.EOF
+	}
+	if mc.netConn == nil {
+		return ErrInvalidConn
+	}
+	rows.more = nil
+	rows.columns = nil
+	rows.result = nil
+	rows.eof = nil
+
+	mc.affectedRows = 0
+	mc.insertId = 0
+
+	resLen, err := mc.readResultSetHeaderPacket()
+	if resLen > 0 {
+		if rows.columns, err = mc.readColumns(resLen); err != nil {
+			return err
+		}
+		rows.mc = mc
+		return nil
+	}
+
+	result := &MySQLResult{
+		affectedRows: int64(mc.affectedRows),
+		insertId:     int64(mc.insertId),
+		status:       mc.status,
+	}
+	if warnings, ok := err.(MySQLWarnings); ok {
+		result.warnings = warnings.Errors()
+	} else if err != nil {
+		return err
+	}
+	result.statusInfo = mc.popStatusInfo()
+	result.stateChanges = mc.popSessionState()
+
+	rows.result = result
 	return nil
 }
 
-func (rows emptyRows) NextRowPacket() (driver.RawPacket, error) {
-	return nil, nil
+func (rows *TextRows) Result() driver.Result {
+	if rows.result == nil {
+		return nil
+	}
+	return rows.result
 }
@@ -0,0 +1,174 @@
+package mysql
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/bytedance/dbatman/database/sql/driver"
+)
+
+// TestTextRows_NextResultSet reads the results of a CALL from a fake server,
+// a result set for each SELECT of the procedure and the OK packet at last.
+func TestTextRows_NextResultSet(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		fc := NewMySQLServerConn(testServerCtx{}, server)
+		if _, err := fc.ReadPacket(); err != nil {
+			done <- err
+			return
+		}
+
+		fc.SetMoreResults(true)
+		for _, v := range []string{"1", "2"} {
+			fc.WritePacket([]byte{0, 0, 0, 0, 1})
+			fc.WritePacket((&MySQLField{Name: []byte("a"), FieldType: fieldTypeVarString}).Dump())
+			fc.WriteEOF()
+			fc.WritePacket(appendLengthEncodedString(make([]byte, 4), []byte(v)))
+			fc.WriteEOF()
+		}
+		fc.SetMoreResults(false)
+		fc.WriteOK(&MySQLResult{affectedRows: 3, status: statusInAutocommit})
+		done <- fc.Flush()
+	}()
+
+	mc := &MySQLConn{
+		netConn:          client,
+		buf:              newBuffer(client),
+		maxPacketAllowed: maxPacketSize,
+		maxWriteSize:     maxPacketSize - 1,
+	}
+
+	rowsi, err := mc.Query("CALL p()", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, ok := rowsi.(driver.RowsNextResultSet)
+	if !ok {
+		t.Fatalf("expect the rows with more results, got %T", rowsi)
+	}
+
+	dest := make([]driver.Value, 1)
+	for i, v := range []string{"1", "2"} {
+		if i > 0 {
+			if err := rows.NextResultSet(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := rows.Next(dest); err != nil {
+			t.Fatal(err)
+		}
+		if string(dest[0].([]byte)) != v {
+			t.Fatalf("expect %s, got %s", v, dest[0])
+		}
+		if err := rows.Next(dest); err != io.EOF {
+			t.Fatalf("expect the end of the result set, got %v", err)
+		}
+		if !rows.HasNextResultSet() {
+			t.Fatal("expect more results")
+		}
+		if rows.Result() != nil {
+			t.Fatal("expect no OK packet of a result set")
+		}
+	}
+
+	// the status of the CALL
+	if err := rows.NextResultSet(); err != nil {
+		t.Fatal(err)
+	}
+	res := rows.Result()
+	if res == nil {
+		t.Fatal("expect the OK packet at last")
+	}
+	if n, _ := res.RowsAffected(); n != 3 {
+		t.Fatalf("expect 3 rows affected, got %d", n)
+	}
+	if rows.HasNextResultSet() {
+		t.Fatal("expect no more results")
+	}
+	if err := rows.NextResultSet(); err != io.EOF {
+		t.Fatalf("expect io.EOF, got %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTextRows_DeprecateEOF reads a result set ending with the EOF packet,
+// or the OK packet with the EOF header and no EOF packet after the columns
+// if CLIENT_DEPRECATE_EOF is used.
+func TestTextRows_DeprecateEOF(t *testing.T) {
+	for _, deprecate := range []bool{false, true} {
+		flags := clientProtocol41
+		if deprecate {
+			flags |= clientDeprecateEOF
+		}
+
+		server, client := net.Pipe()
+
+		done := make(chan error, 1)
+		go func() {
+			defer server.Close()
+			fc := NewMySQLServerConn(testServerCtx{}, server)
+			fc.SetFlags(uint32(flags))
+			if _, err := fc.ReadPacket(); err != nil {
+				done <- err
+				return
+			}
+
+			fc.WritePacket([]byte{0, 0, 0, 0, 1})
+			fc.WritePacket((&MySQLField{Name: []byte("a"), FieldType: fieldTypeVarString}).Dump())
+			fc.WriteColumnsEOF()
+			fc.WritePacket(appendLengthEncodedString(make([]byte, 4), []byte("1")))
+			fc.WriteEOFResult(&MySQLResult{statusInfo: "info"})
+			done <- fc.Flush()
+		}()
+
+		mc := &MySQLConn{
+			netConn:          client,
+			buf:              newBuffer(client),
+			flags:            flags,
+			maxPacketAllowed: maxPacketSize,
+			maxWriteSize:     maxPacketSize - 1,
+		}
+
+		rowsi, err := mc.Query("SELECT a FROM t", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dest := make([]driver.Value, 1)
+		if err := rowsi.Next(dest); err != nil {
+			t.Fatal(err)
+		}
+		if string(dest[0].([]byte)) != "1" {
+			t.Fatalf("expect 1, got %s", dest[0])
+		}
+		if err := rowsi.Next(dest); err != io.EOF {
+			t.Fatalf("expect the end of the rows, got %v", err)
+		}
+
+		// the info is passed in the OK packet only
+		expect := ""
+		if deprecate {
+			expect = "info"
+		}
+		res := rowsi.(driver.RowsEOFResult).EOFResult()
+		if res == nil {
+			t.Fatal("expect the status at the end of the rows")
+		}
+		if info, _ := res.Info(); info != expect {
+			t.Fatalf("expect info %q, got %q", expect, info)
+		}
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+	}
+}
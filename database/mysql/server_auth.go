@@ -0,0 +1,278 @@
+// Copyright 2016 ByteDance, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+// The authentication plugins supported by the server side
+const (
+	AuthNativePassword      = "mysql_native_password"
+	AuthCachingSha2Password = "caching_sha2_password"
+
+	// AuthClearPassword is the plugin of the password sent by the full
+	// authentication of caching_sha2_password, over TLS or encrypted by the
+	// RSA key of the server, it is never negotiated with the clients.
+	AuthClearPassword = "mysql_clear_password"
+)
+
+// ErrFullAuthRequired is returned by MySQLServerCtx.CheckAuth if it could not
+// check the caching_sha2_password scramble, e.g. the password is hashed, the
+// client is asked to send the password then.
+var ErrFullAuthRequired = errors.New("full authentication required")
+
+// the auth more data of caching_sha2_password
+const (
+	cachingSha2RequestPublicKey = 0x02
+	cachingSha2FastAuthSuccess  = 0x03
+	cachingSha2PerformFullAuth  = 0x04
+)
+
+// SetAuthPlugin sets the plugin announced in the handshake, it must be called
+// before the Handshake. The clients answering with other supported plugins
+// are accepted, otherwise they are asked to switch to this plugin.
+func (mc *MySQLServerConn) SetAuthPlugin(plugin string) error {
+	switch plugin {
+	case "":
+		mc.defaultPlugin = AuthNativePassword
+	case AuthNativePassword, AuthCachingSha2Password:
+		mc.defaultPlugin = plugin
+	default:
+		return fmt.Errorf("unsupported auth plugin %s", plugin)
+	}
+	return nil
+}
+
+// SetRSAKey sets the key to exchange the password of caching_sha2_password
+// on the connections without TLS, the clients asking for the public key are
+// refused if no key is set.
+func (mc *MySQLServerConn) SetRSAKey(key *rsa.PrivateKey) {
+	mc.rsaKey = key
+}
+
+// AuthPlugin returns the plugin the auth data passed to CheckAuth is computed
+// by, AuthClearPassword means the auth data is the password.
+func (mc *MySQLServerConn) AuthPlugin() string {
+	return mc.authPlugin
+}
+
+// authenticate checks the auth data of the handshake response, the client is
+// asked to switch to the default plugin if its plugin is not supported.
+func (mc *MySQLServerConn) authenticate(user string, auth []byte, db string, plugin string) error {
+	if mc.flags&clientPluginAuth == 0 {
+		// the clients before 5.5 only know mysql_native_password
+		plugin = AuthNativePassword
+	}
+
+	switch plugin {
+	case AuthNativePassword, AuthCachingSha2Password:
+	default:
+		var err error
+		if auth, err = mc.switchAuthPlugin(mc.defaultPlugin); err != nil {
+			return err
+		}
+		plugin = mc.defaultPlugin
+	}
+
+	return mc.checkAuth(user, auth, db, plugin)
+}
+
+// checkAuth checks the auth data computed by the plugin
+func (mc *MySQLServerConn) checkAuth(user string, auth []byte, db string, plugin string) error {
+	mc.authPlugin = plugin
+	if plugin == AuthNativePassword {
+		return mc.ctx.CheckAuth(user, auth, db)
+	}
+
+	return mc.cachingSha2Auth(user, auth, db)
+}
+
+// ChangeUser authenticates the user of the COM_CHANGE_USER packet, data is
+// the packet without the command byte. The salt of the ctx should have been
+// refreshed, the client is asked to compute the auth data with it by an Auth
+// Switch Request. The caller writes the OK packet if no error is returned.
+// http://dev.mysql.com/doc/internals/en/com-change-user.html
+func (mc *MySQLServerConn) ChangeUser(data []byte) error {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 {
+		return ErrMalformPkt
+	}
+	user := string(data[:i])
+	pos := i + 1
+
+	// skip the auth data computed with the old salt
+	if pos >= len(data) {
+		return ErrMalformPkt
+	}
+	if mc.flags&clientSecureConn != 0 {
+		pos += 1 + int(data[pos])
+	} else if i := bytes.IndexByte(data[pos:], 0); i >= 0 {
+		pos += i + 1
+	}
+
+	var db string
+	if pos < len(data) {
+		if i := bytes.IndexByte(data[pos:], 0); i >= 0 {
+			db = string(data[pos : pos+i])
+			pos += i + 1
+		}
+	}
+
+	//charset, the unknown collations are ignored
+	if pos+2 <= len(data) {
+		if id := CollationId(data[pos]); data[pos+1] == 0 && id.IsClientCharset() {
+			mc.collation = id
+		}
+	}
+	pos += 2
+
+	plugin := AuthNativePassword
+	if mc.flags&clientPluginAuth != 0 && pos < len(data) {
+		if i := bytes.IndexByte(data[pos:], 0); i >= 0 {
+			plugin = string(data[pos : pos+i])
+		}
+	}
+
+	switch plugin {
+	case AuthNativePassword, AuthCachingSha2Password:
+	default:
+		plugin = mc.defaultPlugin
+	}
+
+	auth, err := mc.switchAuthPlugin(plugin)
+	if err != nil {
+		return err
+	}
+
+	return mc.checkAuth(user, auth, db, plugin)
+}
+
+// switchAuthPlugin sends the Auth Switch Request and returns the auth data
+// computed by the plugin
+// http://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::AuthSwitchRequest
+func (mc *MySQLServerConn) switchAuthPlugin(plugin string) ([]byte, error) {
+	data := make([]byte, 4, 4+1+len(plugin)+1+21)
+	data = append(data, iEOF)
+	data = append(data, plugin...)
+	data = append(data, 0)
+	data = append(data, mc.ctx.Salt()...)
+	data = append(data, 0)
+
+	if err := mc.WritePacket(data); err != nil {
+		return nil, err
+	}
+	if err := mc.Flush(); err != nil {
+		return nil, err
+	}
+
+	return mc.readAuthData()
+}
+
+// cachingSha2Auth checks the scramble of caching_sha2_password, or asks the
+// client to send the password if the scramble could not be checked.
+func (mc *MySQLServerConn) cachingSha2Auth(user string, auth []byte, db string) error {
+	err := mc.ctx.CheckAuth(user, auth, db)
+	if err != ErrFullAuthRequired {
+		if err != nil {
+			return err
+		}
+		return mc.writeAuthMoreData([]byte{cachingSha2FastAuthSuccess})
+	}
+
+	if err := mc.writeAuthMoreData([]byte{cachingSha2PerformFullAuth}); err != nil {
+		return err
+	}
+
+	data, err := mc.readAuthData()
+	if err != nil {
+		return err
+	}
+
+	if !mc.TLS() {
+		if data, err = mc.decryptPassword(data); err != nil {
+			return err
+		}
+	}
+
+	// the password is null terminated
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+
+	mc.authPlugin = AuthClearPassword
+	return mc.ctx.CheckAuth(user, data, db)
+}
+
+// decryptPassword sends the public key if the client asks for it, and decrypts
+// the password encrypted by the key
+func (mc *MySQLServerConn) decryptPassword(data []byte) ([]byte, error) {
+	if mc.rsaKey == nil {
+		return nil, NewDefaultError(ER_ACCESS_DENIED_ERROR, "", mc.netConn.RemoteAddr().String(), "Yes")
+	}
+
+	if len(data) == 1 && data[0] == cachingSha2RequestPublicKey {
+		der, err := x509.MarshalPKIXPublicKey(&mc.rsaKey.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := mc.writeAuthMoreData(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})); err != nil {
+			return nil, err
+		}
+		if data, err = mc.readAuthData(); err != nil {
+			return nil, err
+		}
+	}
+
+	plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, mc.rsaKey, data, nil)
+	if err != nil {
+		return nil, NewDefaultError(ER_ACCESS_DENIED_ERROR, "", mc.netConn.RemoteAddr().String(), "Yes")
+	}
+
+	// the password is xored with the salt before encrypted
+	salt := mc.ctx.Salt()
+	for i := range plain {
+		plain[i] ^= salt[i%len(salt)]
+	}
+	return plain, nil
+}
+
+// writeAuthMoreData writes the Protocol::AuthMoreData packet
+func (mc *MySQLServerConn) writeAuthMoreData(payload []byte) error {
+	data := make([]byte, 4, 4+1+len(payload))
+	data = append(data, iAuthMoreData)
+	data = append(data, payload...)
+
+	if err := mc.WritePacket(data); err != nil {
+		return err
+	}
+	return mc.Flush()
+}
+
+// readAuthData reads the auth data sent by the client during the auth
+// exchange, the packet buffer is reused so a copy is returned
+func (mc *MySQLServerConn) readAuthData() ([]byte, error) {
+	data, err := mc.readPacket()
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), data...), nil
+}
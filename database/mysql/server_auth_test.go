@@ -0,0 +1,299 @@
+package mysql
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+)
+
+// testAuthCtx accepts the user with the password, the caching_sha2_password
+// scramble is not checked if full is set
+type testAuthCtx struct {
+	fc       *MySQLServerConn
+	password string
+	full     bool
+
+	plugin string // the plugin of the auth data checked at last
+}
+
+func (ctx *testAuthCtx) Salt() []byte       { return []byte("01234567890123456789") }
+func (ctx *testAuthCtx) ServerName() []byte { return []byte("5.6.0-test") }
+
+func (ctx *testAuthCtx) CheckAuth(user string, auth []byte, db string) error {
+	ctx.plugin = ctx.fc.AuthPlugin()
+
+	var expect []byte
+	switch ctx.plugin {
+	case AuthNativePassword:
+		expect = scramblePassword(ctx.Salt(), []byte(ctx.password))
+	case AuthCachingSha2Password:
+		if ctx.full {
+			return ErrFullAuthRequired
+		}
+		expect = scrambleSha256Password(ctx.Salt(), ctx.password)
+	case AuthClearPassword:
+		expect = []byte(ctx.password)
+	}
+
+	if !bytes.Equal(auth, expect) {
+		return NewDefaultError(ER_ACCESS_DENIED_ERROR, user, "test", "Yes")
+	}
+	return nil
+}
+
+func scrambleSha256Password(scramble []byte, password string) []byte {
+	m1 := sha256.Sum256([]byte(password))
+	m2 := sha256.Sum256(m1[:])
+	m3 := sha256.Sum256(append(m2[:], scramble...))
+	for i := range m3 {
+		m3[i] ^= m1[i]
+	}
+	return m3[:]
+}
+
+// testAuthClient is the client side of the connection phase
+type testAuthClient struct {
+	mc        *MySQLConn
+	salt      []byte
+	collation CollationId // of the handshake response, utf8 if 0
+	flags     clientFlag  // added to the flags of the handshake response
+}
+
+func newTestAuthClient(t *testing.T, ctx *testAuthCtx, key *rsa.PrivateKey) (*testAuthClient, chan error) {
+	server, client := net.Pipe()
+
+	ctx.fc = NewMySQLServerConn(ctx, server)
+	ctx.fc.SetRSAKey(key)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- ctx.fc.Handshake()
+		server.Close()
+	}()
+
+	c := &testAuthClient{mc: &MySQLConn{
+		netConn:          client,
+		buf:              newBuffer(client),
+		maxPacketAllowed: maxPacketSize,
+		maxWriteSize:     maxPacketSize - 1,
+	}}
+
+	data := c.read(t)
+	if i := bytes.LastIndex(data, []byte(AuthNativePassword)); i < 0 {
+		t.Fatalf("expect plugin %s in the handshake", AuthNativePassword)
+	}
+	// skip version, connection id
+	pos := 1 + bytes.IndexByte(data[1:], 0) + 1 + 4
+	c.salt = append(append([]byte(nil), data[pos:pos+8]...), data[pos+8+1+2+1+2+2+1+10:][:12]...)
+	return c, done
+}
+
+func (c *testAuthClient) read(t *testing.T) []byte {
+	data, err := c.mc.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), data...)
+}
+
+func (c *testAuthClient) write(t *testing.T, payload []byte) {
+	if err := c.mc.writePacket(append(make([]byte, 4), payload...)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *testAuthClient) writeResponse(t *testing.T, plugin string, auth []byte) {
+	flags := clientProtocol41 | clientSecureConn | clientPluginAuth | c.flags
+	collation := c.collation
+	if collation == 0 {
+		collation = DEFAULT_COLLATION_ID
+	}
+	data := []byte{byte(flags), byte(flags >> 8), byte(flags >> 16), byte(flags >> 24), 0, 0, 0, 1, byte(collation)}
+	data = append(data, make([]byte, 23)...)
+	data = append(data, "root"...)
+	data = append(data, 0, byte(len(auth)))
+	data = append(data, auth...)
+	data = append(data, plugin...)
+	c.write(t, append(data, 0))
+}
+
+func expectPacket(t *testing.T, data []byte, expect ...byte) {
+	if !bytes.HasPrefix(data, expect) {
+		t.Fatalf("expect packet % x, got % x", expect, data)
+	}
+}
+
+func TestServerConn_CachingSha2FastAuth(t *testing.T) {
+	ctx := &testAuthCtx{password: "pass"}
+	c, done := newTestAuthClient(t, ctx, nil)
+
+	c.writeResponse(t, AuthCachingSha2Password, scrambleSha256Password(c.salt, "pass"))
+	expectPacket(t, c.read(t), iAuthMoreData, cachingSha2FastAuthSuccess)
+	expectPacket(t, c.read(t), iOK)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if ctx.plugin != AuthCachingSha2Password {
+		t.Fatalf("expect plugin %s, got %s", AuthCachingSha2Password, ctx.plugin)
+	}
+}
+
+func TestServerConn_CachingSha2FullAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &testAuthCtx{password: "pass", full: true}
+	c, done := newTestAuthClient(t, ctx, key)
+
+	c.writeResponse(t, AuthCachingSha2Password, scrambleSha256Password(c.salt, "pass"))
+	expectPacket(t, c.read(t), iAuthMoreData, cachingSha2PerformFullAuth)
+
+	// ask for the public key and send the password encrypted
+	c.write(t, []byte{cachingSha2RequestPublicKey})
+	data := c.read(t)
+	expectPacket(t, data, iAuthMoreData)
+
+	block, _ := pem.Decode(data[1:])
+	if block == nil {
+		t.Fatalf("expect public key, got %q", data[1:])
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := append([]byte("pass"), 0)
+	for i := range plain {
+		plain[i] ^= c.salt[i%len(c.salt)]
+	}
+	enc, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, pub.(*rsa.PublicKey), plain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.write(t, enc)
+	expectPacket(t, c.read(t), iOK)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if ctx.plugin != AuthClearPassword {
+		t.Fatalf("expect plugin %s, got %s", AuthClearPassword, ctx.plugin)
+	}
+}
+
+func TestServerConn_AuthSwitch(t *testing.T) {
+	ctx := &testAuthCtx{password: "pass"}
+	c, done := newTestAuthClient(t, ctx, nil)
+
+	c.writeResponse(t, "sha256_password", []byte("whatever"))
+
+	data := c.read(t)
+	expectPacket(t, data, append([]byte{iEOF}, AuthNativePassword...)...)
+	c.write(t, scramblePassword(c.salt, []byte("pass")))
+	expectPacket(t, c.read(t), iOK)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if ctx.plugin != AuthNativePassword {
+		t.Fatalf("expect plugin %s, got %s", AuthNativePassword, ctx.plugin)
+	}
+
+	// wrong password
+	ctx = &testAuthCtx{password: "pass"}
+	c, done = newTestAuthClient(t, ctx, nil)
+
+	c.writeResponse(t, AuthNativePassword, scramblePassword(c.salt, []byte("wrong")))
+	expectPacket(t, c.read(t), iERR)
+	if err := <-done; err == nil {
+		t.Fatal("expect access denied")
+	}
+}
+
+func TestServerConn_ChangeUser(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	ctx := &testAuthCtx{password: "pass"}
+	ctx.fc = NewMySQLServerConn(ctx, server)
+
+	done := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		data, err := ctx.fc.ReadPacket()
+		if err != nil {
+			done <- err
+			return
+		}
+		if err := ctx.fc.ChangeUser(data[1:]); err != nil {
+			done <- err
+			return
+		}
+		ctx.fc.WriteOK(nil)
+		done <- ctx.fc.Flush()
+	}()
+
+	c := &testAuthClient{mc: &MySQLConn{
+		netConn:          client,
+		buf:              newBuffer(client),
+		maxPacketAllowed: maxPacketSize,
+		maxWriteSize:     maxPacketSize - 1,
+	}, salt: ctx.Salt()}
+
+	// user, auth computed with the old salt, db, charset, plugin
+	data := []byte{ComChangeUser}
+	data = append(data, "root"...)
+	data = append(data, 0, 3, 'o', 'l', 'd')
+	data = append(data, "test"...)
+	data = append(data, 0, 45, 0)
+	data = append(data, AuthNativePassword...)
+	c.write(t, append(data, 0))
+
+	expectPacket(t, c.read(t), append([]byte{iEOF}, AuthNativePassword...)...)
+	c.write(t, scramblePassword(c.salt, []byte("pass")))
+	expectPacket(t, c.read(t), iOK)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if id := ctx.fc.Collation(); id != CharsetIds["utf8mb4"] {
+		t.Fatalf("expect the collation of the packet, got %s", id.Name())
+	}
+}
+
+func TestServerConn_HandshakeCollation(t *testing.T) {
+	for _, test := range []struct {
+		collation CollationId
+		expect    CollationId
+	}{
+		{CollationNames["utf8mb4_unicode_ci"], CollationNames["utf8mb4_unicode_ci"]},
+		{CharsetIds["latin1"], CharsetIds["latin1"]},
+		// ucs2 could not be used by the clients
+		{CharsetIds["ucs2"], DEFAULT_COLLATION_ID},
+		// unknown
+		{255, DEFAULT_COLLATION_ID},
+	} {
+		ctx := &testAuthCtx{password: "pass"}
+		c, done := newTestAuthClient(t, ctx, nil)
+		c.collation = test.collation
+
+		c.writeResponse(t, AuthNativePassword, scramblePassword(c.salt, []byte("pass")))
+		expectPacket(t, c.read(t), iOK)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+
+		if id := ctx.fc.Collation(); id != test.expect {
+			t.Fatalf("expect collation %d, got %d", test.expect, id)
+		}
+	}
+}
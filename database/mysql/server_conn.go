@@ -16,6 +16,9 @@ package mysql
 import (
 	"bufio"
 	"bytes"
+	"crypto/rsa"
+	"crypto/tls"
+	"io"
 	"net"
 	"sync/atomic"
 	"time"
@@ -64,6 +67,21 @@ type MySQLServerConn struct {
 	collation CollationId
 	connID    uint32
 	wb        *bufio.Writer
+
+	tlsConfig  *tls.Config
+	requireTLS bool
+
+	// authentication plugins, see server_auth.go
+	defaultPlugin string
+	authPlugin    string
+	rsaKey        *rsa.PrivateKey
+
+	// moreResults adds SERVER_MORE_RESULTS_EXISTS to the OK and EOF packets
+	moreResults bool
+
+	// trackedState is the session state changes added to the next OK
+	// packet, see TrackSessionState
+	trackedState []SessionStateChange
 }
 
 var baseConnId uint32 = 10000
@@ -84,7 +102,11 @@ func NewMySQLServerConn(s MySQLServerCtx, conn net.Conn) *MySQLServerConn {
 
 	// Default Capacity Flags
 	c.flags = ClientLongPassword | ClientLongFlag |
-		ClientConnectWithDB | ClientProtocol41 | ClientTransactions | ClientSecureConn
+		ClientConnectWithDB | ClientProtocol41 | ClientTransactions | ClientSecureConn |
+		ClientPluginAuth | ClientPluginAuthLenEncClientData |
+		ClientMultiStatements | ClientMultiResults | ClientCompress |
+		ClientDeprecateEOF | ClientSessionTrack
+	c.defaultPlugin = AuthNativePassword
 
 	// Set default connection id
 	c.connID = atomic.AddUint32(&baseConnId, 1)
@@ -95,6 +117,28 @@ func NewMySQLServerConn(s MySQLServerCtx, conn net.Conn) *MySQLServerConn {
 	return c
 }
 
+// SetTLSConfig enables TLS on the connection, it must be called before the
+// Handshake. The clients not switching to TLS are refused if require is set.
+func (mc *MySQLServerConn) SetTLSConfig(config *tls.Config, require bool) {
+	mc.tlsConfig = config
+	mc.requireTLS = require
+	if config != nil {
+		mc.flags |= ClientSSL
+	} else {
+		mc.flags &^= ClientSSL
+	}
+}
+
+// TLS returns whether the connection is encrypted
+func (mc *MySQLServerConn) TLS() bool {
+	nc := mc.netConn
+	if cc, ok := nc.(*compressedConn); ok {
+		nc = cc.Conn
+	}
+	_, ok := nc.(*tls.Conn)
+	return ok
+}
+
 // Hnadshake init handshake package to the client, wait for client autheticate
 // response.
 func (mc *MySQLServerConn) Handshake() error {
@@ -125,6 +169,12 @@ func (mc *MySQLServerConn) Handshake() error {
 		return err
 	}
 
+	// the packets after the OK are compressed if the client asked for it
+	if mc.flags&clientCompress != 0 {
+		mc.enableCompression()
+		mc.wb.Reset(mc.netConn)
+	}
+
 	mc.sequence = 0
 	return nil
 }
@@ -154,7 +204,27 @@ func (mc *MySQLServerConn) SetFlags(flags uint32) {
 }
 
 func (mc *MySQLServerConn) Flags() uint32 {
-	return mc.Flags()
+	return uint32(mc.flags)
+}
+
+// SetMultiStatements turns CLIENT_MULTI_STATEMENTS of the client on or off,
+// by COM_SET_OPTION
+func (mc *MySQLServerConn) SetMultiStatements(on bool) {
+	if on {
+		mc.flags |= clientMultiStatements
+	} else {
+		mc.flags &^= clientMultiStatements
+	}
+}
+
+// SetMoreResults tells the client more results follow the ones written next,
+// by SERVER_MORE_RESULTS_EXISTS in the status of the OK and EOF packets.
+func (mc *MySQLServerConn) SetMoreResults(more bool) {
+	mc.moreResults = more
+}
+
+func (mc *MySQLServerConn) MoreResults() bool {
+	return mc.moreResults
 }
 
 func (mc *MySQLServerConn) SetConnID(id uint32) {
@@ -218,8 +288,8 @@ func (mc *MySQLServerConn) writeInitPacket() error {
 	// capability flag upper 2 bytes, using default capability here
 	data = append(data, byte(mc.flags>>16), byte(mc.flags>>24))
 
-	// filter [0x15], for wireshark dump, value is 0x15
-	data = append(data, 0x00)
+	// length of auth-plugin-data, 0x15
+	data = append(data, byte(len(mc.ctx.Salt())+1))
 
 	// reserved 10 [00]
 	data = append(data, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
@@ -230,6 +300,10 @@ func (mc *MySQLServerConn) writeInitPacket() error {
 	// filter [00]
 	data = append(data, 0)
 
+	// auth-plugin name
+	data = append(data, mc.defaultPlugin...)
+	data = append(data, 0)
+
 	if err := mc.writePacket(data); err != nil {
 		return err
 	}
@@ -251,6 +325,19 @@ func (mc *MySQLServerConn) readHandshakeResponse() error {
 		return err
 	}
 
+	// SSL Connection Request Packet, the client sends the handshake response
+	// again after switched to TLS
+	// http://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::SSLRequest
+	if len(data) == 4+4+1+23 && mc.tlsConfig != nil && clientFlag(endian.Uint32(data[:4]))&clientSSL != 0 {
+		if err := mc.upgradeTLS(); err != nil {
+			return err
+		}
+
+		if data, err = mc.readPacket(); err != nil {
+			return err
+		}
+	}
+
 	pos := 0
 
 	//capability
@@ -260,8 +347,10 @@ func (mc *MySQLServerConn) readHandshakeResponse() error {
 	//skip max packet size
 	pos += 4
 
-	//charset, skip, if you want to use another charset, use set names
-	//c.collation = CollationId(data[pos])
+	//charset, the unknown collations are ignored
+	if id := CollationId(data[pos]); id.IsClientCharset() {
+		mc.collation = id
+	}
 	pos++
 
 	//skip reserved 23[00]
@@ -271,35 +360,87 @@ func (mc *MySQLServerConn) readHandshakeResponse() error {
 	user := string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
 	pos += len(user) + 1
 
+	if mc.requireTLS && !mc.TLS() {
+		log.Warnf("refuse the client %s not using TLS", mc.netConn.RemoteAddr().String())
+		return NewDefaultError(ER_ACCESS_DENIED_ERROR, user, mc.netConn.RemoteAddr().String(), "Yes")
+	}
+
 	//auth length and auth
-	authLen := int(data[pos])
-	pos++
-	auth := data[pos : pos+authLen]
+	var authLen int
+	if mc.flags&clientPluginAuthLenEncClientData != 0 {
+		n, _, m := readLengthEncodedInteger(data[pos:])
+		authLen, pos = int(n), pos+m
+	} else {
+		authLen = int(data[pos])
+		pos++
+	}
+	auth := append([]byte(nil), data[pos:pos+authLen]...)
 	pos += authLen
 
-	if mc.flags&clientConnectWithDB == 0 {
-		if err := mc.ctx.CheckAuth(user, auth, ""); err != nil {
-			return err
-		}
-	} else {
+	var db string
+	if mc.flags&clientConnectWithDB != 0 {
 		// connect must with db, otherwise it will deny the access
 		if len(data[pos:]) == 0 {
 			return NewDefaultError(ER_ACCESS_DENIED_ERROR, mc.netConn.RemoteAddr().String(), user, "Yes")
 		}
 
-		db := string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
+		db = string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
 		pos += len(db) + 1
+	}
 
-		// check with user
-		if err := mc.ctx.CheckAuth(user, auth, db); err != nil {
-			log.Debugf("mysql check auth fail!")
-			return err
+	// the plugin computed the auth data
+	var plugin string
+	if mc.flags&clientPluginAuth != 0 && pos < len(data) {
+		if i := bytes.IndexByte(data[pos:], 0); i >= 0 {
+			plugin = string(data[pos : pos+i])
+		} else {
+			plugin = string(data[pos:])
 		}
 	}
 
+	// check with user
+	if err := mc.authenticate(user, auth, db, plugin); err != nil {
+		log.Debugf("mysql check auth fail!")
+		return err
+	}
+
 	return nil
 }
 
+// upgradeTLS switches the connection to TLS as the server side
+func (mc *MySQLServerConn) upgradeTLS() error {
+	nc := mc.netConn
+
+	// the client hello may have been read into the buffer with the request
+	if mc.buf.length > 0 {
+		pending := make([]byte, mc.buf.length)
+		copy(pending, mc.buf.buf[mc.buf.idx:mc.buf.idx+mc.buf.length])
+		mc.buf.idx, mc.buf.length = 0, 0
+		nc = &bufferedConn{nc, io.MultiReader(bytes.NewReader(pending), nc)}
+	}
+
+	tlsConn := tls.Server(nc, mc.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	mc.netConn = tlsConn
+	mc.buf.nc = tlsConn
+	// nothing is buffered, the init packet has been flushed
+	mc.wb.Reset(tlsConn)
+	return nil
+}
+
+// bufferedConn is a net.Conn reads the bytes buffered before the conn
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
 /******************************************************************************
 *                   Function Send Packets to front client                     *
 ******************************************************************************/
@@ -341,32 +482,90 @@ func (mc *MySQLServerConn) WriteOK(r driver.Result) error {
 
 	warnings := len(r.Warnings())
 	status, _ := r.Status()
+	if mc.moreResults {
+		status |= int64(statusMoreResultsExists)
+	}
 
-	if mc.flags&clientProtocol41 > 0 {
-		data = append(data, byte(status), byte(status>>8))
-		data = append(data, byte(warnings), byte(warnings>>8))
+	data = mc.appendOKInfo(data, statusFlag(status), warnings, r)
+
+	return mc.WritePacket(data)
+}
+
+// DeprecateEOF tells if the client uses CLIENT_DEPRECATE_EOF, the columns
+// are not followed by the EOF packet and the rows end with the OK packet
+// with the EOF header then.
+func (mc *MySQLServerConn) DeprecateEOF() bool {
+	return mc.flags&clientDeprecateEOF != 0
+}
+
+// WriteColumnsEOF writes the EOF packet after the columns of a result set
+// or the parameters of a prepared statement, nothing is written if the
+// client uses CLIENT_DEPRECATE_EOF.
+func (mc *MySQLServerConn) WriteColumnsEOF() error {
+	if mc.DeprecateEOF() {
+		return nil
 	}
+	return mc.writeEOF()
+}
 
-	info, _ := r.Info()
-	if len(info) > 0 {
-		data = append(data, []byte(info)...)
+// WriteEOF writes the EOF packet at the end of the rows, it is the OK packet
+// with the EOF header if the client uses CLIENT_DEPRECATE_EOF.
+func (mc *MySQLServerConn) WriteEOF() error {
+	return mc.WriteEOFResult(nil)
+}
+
+// WriteEOFResult writes the EOF packet at the end of the rows, the info and
+// session state changes of r, the status at the end of the rows read from
+// the backend, are passed through to the client if it uses
+// CLIENT_DEPRECATE_EOF.
+func (mc *MySQLServerConn) WriteEOFResult(r driver.Result) error {
+	if !mc.DeprecateEOF() {
+		return mc.writeEOF()
 	}
 
+	data := make([]byte, 4, 16)
+	data = append(data, iEOF)
+
+	// affected rows, insert id
+	data = append(data, 0, 0)
+
+	status := mc.status
+	if mc.moreResults {
+		status |= statusMoreResultsExists
+	}
+	data = mc.appendOKInfo(data, status, 0, r)
+
 	return mc.WritePacket(data)
 }
 
-func (mc *MySQLServerConn) WriteEOF() error {
+func (mc *MySQLServerConn) writeEOF() error {
 	data := make([]byte, 4, 9)
 
+	status := mc.status
+	if mc.moreResults {
+		status |= statusMoreResultsExists
+	}
+
 	data = append(data, iEOF)
 	if mc.flags&ClientProtocol41 > 0 {
 		data = append(data, 0, 0)
-		data = append(data, byte(mc.status), byte(mc.status>>8))
+		data = append(data, byte(status), byte(status>>8))
 	}
 
 	return mc.WritePacket(data)
 }
 
+// WriteEOFStatus writes the EOF packet with the status flags added to the
+// status of the connection, e.g. StatusCursorExists for the cursors. It is
+// the OK packet with the EOF header too if the EOF is deprecated.
+func (mc *MySQLServerConn) WriteEOFStatus(flags statusFlag) error {
+	status := mc.status
+	mc.status |= flags
+	err := mc.WriteEOF()
+	mc.status = status
+	return err
+}
+
 func (mc *MySQLServerConn) WritePacket(data []byte) error {
 	pktLen := len(data) - 4
 	if pktLen > mc.maxPacketAllowed {
@@ -427,6 +626,10 @@ func (mc *MySQLConn) ReadPacket() ([]byte, error) {
 	return mc.readPacket()
 }
 
+func (mc *MySQLConn) WritePacket(data []byte) error {
+	return mc.writePacket(data)
+}
+
 /******************************************************************************
 *                   Function Wrapper for Export Visiable                      *
 ******************************************************************************/
@@ -1,8 +1,18 @@
 package mysql
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
 	//	"github.com/bytedance/dbatman/database/sql"
 	"testing"
+	"time"
 )
 
 func TestWriteCommandFieldList(t *testing.T) {
@@ -27,3 +37,142 @@ func TestWriteCommandFieldList(t *testing.T) {
 
 	})
 }
+
+type testServerCtx struct{}
+
+func (testServerCtx) Salt() []byte                                        { return []byte("01234567890123456789") }
+func (testServerCtx) CheckAuth(user string, auth []byte, db string) error { return nil }
+func (testServerCtx) ServerName() []byte                                  { return []byte("5.6.0-test") }
+
+// newTestCert returns a self-signed cert of 127.0.0.1
+func newTestCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dbatman test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// serveTestTLS accepts a connection, and answers the query of the driver
+// after connected if the handshake passed
+func serveTestTLS(l net.Listener, config *tls.Config, require bool, done chan<- error) {
+	c, err := l.Accept()
+	if err != nil {
+		done <- err
+		return
+	}
+
+	fc := NewMySQLServerConn(testServerCtx{}, c)
+	defer fc.Close()
+	fc.SetTLSConfig(config, require)
+
+	if err := fc.Handshake(); err != nil {
+		done <- err
+		return
+	}
+	if !fc.TLS() {
+		done <- fmt.Errorf("expect TLS connection")
+		return
+	}
+	done <- nil
+
+	// SELECT @@max_allowed_packet
+	if _, err := fc.ReadPacket(); err != nil {
+		return
+	}
+	fc.WritePacket(appendLengthEncodedInteger(make([]byte, 4), 1))
+	col := make([]byte, 4)
+	for _, s := range []string{"def", "", "", "", "@@max_allowed_packet", ""} {
+		col = appendLengthEncodedInteger(col, uint64(len(s)))
+		col = append(col, s...)
+	}
+	fc.WritePacket(append(col, 0x0c, 33, 0, 0, 1, 0, 0, fieldTypeVarString, 0, 0, 0, 0, 0))
+	fc.WriteEOF()
+	fc.WritePacket(append(make([]byte, 4), 7, '4', '1', '9', '4', '3', '0', '4'))
+	fc.WriteEOF()
+	fc.Flush()
+
+	// wait for COM_QUIT
+	fc.ReadPacket()
+}
+
+func TestServerConn_TLS(t *testing.T) {
+	cert, pool := newTestCert(t)
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if err := RegisterTLSConfig("server-conn-test", &tls.Config{RootCAs: pool}); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTLSConfig("server-conn-test-client", &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{cert}}); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	connect := func(config *tls.Config, require bool, param string) (error, error) {
+		done := make(chan error, 1)
+		go serveTestTLS(l, config, require, done)
+
+		d := MySQLDriver{}
+		c, err := d.Open(fmt.Sprintf("root:@tcp(%s)/test?timeout=5s%s", l.Addr().String(), param))
+		if err == nil {
+			c.Close()
+		}
+		return err, <-done
+	}
+
+	// TLS
+	if cerr, serr := connect(config, true, "&tls=server-conn-test"); cerr != nil || serr != nil {
+		t.Fatalf("expect TLS connected, got client %v, server %v", cerr, serr)
+	}
+
+	// plain connections are refused if TLS is required
+	cerr, serr := connect(config, true, "")
+	if e, ok := cerr.(*MySQLError); !ok || e.Number != ER_ACCESS_DENIED_ERROR {
+		t.Fatalf("expect access denied, got %v", cerr)
+	}
+	if serr == nil {
+		t.Fatal("expect handshake refused")
+	}
+
+	// client cert verification
+	verify := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	if _, serr := connect(verify, true, "&tls=server-conn-test"); serr == nil {
+		t.Fatal("expect handshake refused without client cert")
+	}
+	if cerr, serr := connect(verify, true, "&tls=server-conn-test-client"); cerr != nil || serr != nil {
+		t.Fatalf("expect TLS connected with client cert, got client %v, server %v", cerr, serr)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2016 ByteDance, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mysql
+
+import (
+	"github.com/bytedance/dbatman/database/sql/driver"
+)
+
+// The types of the session state changes in the OK packet, reported if the
+// client uses CLIENT_SESSION_TRACK.
+// https://dev.mysql.com/doc/internals/en/packet-OK_Packet.html
+const (
+	SessionTrackSystemVariables byte = iota
+	SessionTrackSchema
+	SessionTrackStateChange
+	SessionTrackGtids
+	SessionTrackTransactionCharacteristics
+	SessionTrackTransactionState
+)
+
+// SessionStateChange is a session state change reported in the OK packet.
+// Name is the name of the system variable for SessionTrackSystemVariables,
+// Value is the value of the change, or the data of the unknown types.
+type SessionStateChange struct {
+	Type  byte
+	Name  string
+	Value string
+}
+
+// parseSessionState parses the session state changes of the OK packet
+func parseSessionState(data []byte) ([]SessionStateChange, error) {
+	var changes []SessionStateChange
+
+	for pos := 0; pos < len(data); {
+		typ := data[pos]
+		pos++
+
+		entry, _, n, err := readLengthEncodedString(data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		pos += n
+
+		change := SessionStateChange{Type: typ}
+		switch typ {
+		case SessionTrackSystemVariables:
+			name, _, n, err := readLengthEncodedString(entry)
+			if err != nil {
+				return nil, err
+			}
+			value, _, _, err := readLengthEncodedString(entry[n:])
+			if err != nil {
+				return nil, err
+			}
+			change.Name, change.Value = string(name), string(value)
+		case SessionTrackGtids:
+			// the encoding specification [1 byte], 0 for the GTID sets
+			if len(entry) < 1 {
+				return nil, ErrMalformPkt
+			}
+			value, _, _, err := readLengthEncodedString(entry[1:])
+			if err != nil {
+				return nil, err
+			}
+			change.Value = string(value)
+		case SessionTrackSchema, SessionTrackStateChange,
+			SessionTrackTransactionCharacteristics, SessionTrackTransactionState:
+			value, _, _, err := readLengthEncodedString(entry)
+			if err != nil {
+				return nil, err
+			}
+			change.Value = string(value)
+		default:
+			change.Value = string(entry)
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// appendSessionState appends the session state changes to the OK packet
+func appendSessionState(data []byte, changes []SessionStateChange) []byte {
+	var state []byte
+	for _, change := range changes {
+		var entry []byte
+		switch change.Type {
+		case SessionTrackSystemVariables:
+			entry = appendLengthEncodedString(entry, []byte(change.Name))
+			entry = appendLengthEncodedString(entry, []byte(change.Value))
+		case SessionTrackGtids:
+			entry = append(entry, 0)
+			entry = appendLengthEncodedString(entry, []byte(change.Value))
+		case SessionTrackSchema, SessionTrackStateChange,
+			SessionTrackTransactionCharacteristics, SessionTrackTransactionState:
+			entry = appendLengthEncodedString(entry, []byte(change.Value))
+		default:
+			entry = []byte(change.Value)
+		}
+
+		state = append(state, change.Type)
+		state = appendLengthEncodedString(state, entry)
+	}
+
+	return appendLengthEncodedString(data, state)
+}
+
+// mergeSessionState adds the changes to the ones reported already, the
+// change of the same variable or type replaces the reported one
+func mergeSessionState(reported, changes []SessionStateChange) []SessionStateChange {
+	if len(changes) == 0 {
+		return reported
+	}
+
+	merged := make([]SessionStateChange, 0, len(reported)+len(changes))
+	for _, r := range reported {
+		replaced := false
+		for _, c := range changes {
+			if r.Type == c.Type && r.Name == c.Name {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, r)
+		}
+	}
+
+	return append(merged, changes...)
+}
+
+// sessionStateResult is implemented by the results of the OK packets read
+// from the backends, the session state changes are passed through.
+type sessionStateResult interface {
+	SessionState() []SessionStateChange
+}
+
+// SessionState returns the session state changes reported in the OK packet
+func (r *MySQLResult) SessionState() []SessionStateChange {
+	return r.stateChanges
+}
+
+// readOKInfo reads the info and session state changes after the warning
+// count of the OK packet. The info takes the rest of the packet if the
+// session state is not tracked.
+func (mc *MySQLConn) readOKInfo(data []byte, status statusFlag) (string, []SessionStateChange, error) {
+	if mc.flags&clientSessionTrack == 0 {
+		return string(data), nil, nil
+	}
+	if len(data) == 0 {
+		return "", nil, nil
+	}
+
+	info, _, n, err := readLengthEncodedString(data)
+	if err != nil {
+		return "", nil, err
+	}
+	if status&statusSessionStateChanged == 0 || n >= len(data) {
+		return string(info), nil, nil
+	}
+
+	state, _, _, err := readLengthEncodedString(data[n:])
+	if err != nil {
+		return "", nil, err
+	}
+	changes, err := parseSessionState(state)
+	return string(info), changes, err
+}
+
+// SessionTrack tells if the client uses CLIENT_SESSION_TRACK
+func (mc *MySQLServerConn) SessionTrack() bool {
+	return mc.flags&clientSessionTrack != 0
+}
+
+// TrackSessionState adds the session state change to the next OK packet
+// written, it is ignored if the client does not track the session state.
+func (mc *MySQLServerConn) TrackSessionState(change SessionStateChange) {
+	if !mc.SessionTrack() {
+		return
+	}
+	mc.trackedState = mergeSessionState(mc.trackedState, []SessionStateChange{change})
+}
+
+// appendOKInfo appends the status, warning count, info and the session
+// state changes of r and the ones tracked by the proxy to the OK packet.
+// The tracked changes are cleared.
+func (mc *MySQLServerConn) appendOKInfo(data []byte, status statusFlag, warnings int, r driver.Result) []byte {
+	var info string
+	var changes []SessionStateChange
+	if r != nil {
+		info, _ = r.Info()
+		if sr, ok := r.(sessionStateResult); ok {
+			changes = sr.SessionState()
+		}
+	}
+
+	changes = mergeSessionState(changes, mc.trackedState)
+	mc.trackedState = nil
+
+	status &^= statusSessionStateChanged
+	if mc.SessionTrack() && len(changes) > 0 {
+		status |= statusSessionStateChanged
+	}
+
+	if mc.flags&clientProtocol41 > 0 {
+		data = append(data, byte(status), byte(status>>8))
+		data = append(data, byte(warnings), byte(warnings>>8))
+	}
+
+	if !mc.SessionTrack() {
+		return append(data, info...)
+	}
+
+	if len(info) > 0 || status&statusSessionStateChanged != 0 {
+		data = appendLengthEncodedString(data, []byte(info))
+	}
+	if status&statusSessionStateChanged != 0 {
+		data = appendSessionState(data, changes)
+	}
+	return data
+}
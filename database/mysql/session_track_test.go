@@ -0,0 +1,109 @@
+package mysql
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestSessionState(t *testing.T) {
+	changes := []SessionStateChange{
+		{Type: SessionTrackSystemVariables, Name: "autocommit", Value: "OFF"},
+		{Type: SessionTrackSchema, Value: "test"},
+		{Type: SessionTrackStateChange, Value: "1"},
+		{Type: SessionTrackGtids, Value: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"},
+		{Type: SessionTrackTransactionState, Value: "T_______"},
+	}
+
+	state, _, _, err := readLengthEncodedString(appendSessionState(nil, changes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseSessionState(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, changes) {
+		t.Fatalf("expect %v, got %v", changes, parsed)
+	}
+
+	merged := mergeSessionState(changes[:2], []SessionStateChange{
+		{Type: SessionTrackSchema, Value: "other"},
+	})
+	expect := []SessionStateChange{changes[0], {Type: SessionTrackSchema, Value: "other"}}
+	if !reflect.DeepEqual(merged, expect) {
+		t.Fatalf("expect %v, got %v", expect, merged)
+	}
+}
+
+// TestServerConn_SessionTrack passes the session state changes of the
+// backend through with the ones made by the proxy, the latter win.
+func TestServerConn_SessionTrack(t *testing.T) {
+	backend := []SessionStateChange{
+		{Type: SessionTrackGtids, Value: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"},
+		{Type: SessionTrackSchema, Value: "backend"},
+	}
+	tracked := SessionStateChange{Type: SessionTrackSchema, Value: "proxy"}
+
+	for _, track := range []bool{false, true} {
+		flags := clientProtocol41
+		if track {
+			flags |= clientSessionTrack
+		}
+
+		server, client := net.Pipe()
+
+		done := make(chan error, 1)
+		go func() {
+			defer server.Close()
+			fc := NewMySQLServerConn(testServerCtx{}, server)
+			fc.SetFlags(uint32(flags))
+			if _, err := fc.ReadPacket(); err != nil {
+				done <- err
+				return
+			}
+
+			fc.TrackSessionState(tracked)
+			fc.WriteOK(&MySQLResult{affectedRows: 1, statusInfo: "info", stateChanges: backend})
+			done <- fc.Flush()
+		}()
+
+		mc := &MySQLConn{
+			netConn:          client,
+			buf:              newBuffer(client),
+			flags:            flags,
+			maxPacketAllowed: maxPacketSize,
+			maxWriteSize:     maxPacketSize - 1,
+		}
+
+		res, err := mc.Exec("USE proxy", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var expect []SessionStateChange
+		if track {
+			expect = []SessionStateChange{backend[0], tracked}
+		}
+		r := res.(*MySQLResult)
+		if !reflect.DeepEqual(r.SessionState(), expect) {
+			t.Fatalf("expect session state %v, got %v", expect, r.SessionState())
+		}
+		if info, _ := r.Info(); info != "info" {
+			t.Fatalf("expect info %q, got %q", "info", info)
+		}
+		if n, _ := r.RowsAffected(); n != 1 {
+			t.Fatalf("expect 1 row affected, got %d", n)
+		}
+
+		status, _ := r.Status()
+		if changed := statusFlag(status)&statusSessionStateChanged != 0; changed != track {
+			t.Fatalf("expect SERVER_SESSION_STATE_CHANGED %v", track)
+		}
+
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		client.Close()
+	}
+}
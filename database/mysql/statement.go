@@ -59,6 +59,7 @@ func (stmt *mysqlStmt) Exec(args []driver.Value) (driver.Result, error) {
 			status:       mc.status,
 			warnings:     nil,
 			statusInfo:   mc.popStatusInfo(),
+			stateChanges: mc.popSessionState(),
 		}, nil
 	} else if errs, ok := err.(MySQLWarnings); ok {
 		return &MySQLResult{
@@ -67,6 +68,7 @@ func (stmt *mysqlStmt) Exec(args []driver.Value) (driver.Result, error) {
 			status:       mc.status,
 			warnings:     errs.Errors(),
 			statusInfo:   mc.popStatusInfo(),
+			stateChanges: mc.popSessionState(),
 		}, err
 	}
 
@@ -75,7 +77,7 @@ func (stmt *mysqlStmt) Exec(args []driver.Value) (driver.Result, error) {
 
 func (stmt *mysqlStmt) exec(args []driver.Value) error {
 	// Send command
-	err := stmt.writeExecutePacket(args)
+	err := stmt.writeExecutePacket(args, cursorTypeNoCursor)
 	if err != nil {
 		return err
 	}
@@ -86,7 +88,7 @@ func (stmt *mysqlStmt) exec(args []driver.Value) error {
 	resLen, err := mc.readResultSetHeaderPacket()
 	if err == nil && resLen > 0 {
 		// Columns
-		err = mc.readUntilEOF()
+		err = mc.skipColumns(resLen)
 		if err != nil {
 			return err
 		}
@@ -112,7 +114,7 @@ func (stmt *mysqlStmt) reset() error {
 	resLen, err := mc.readResultSetHeaderPacket()
 	if err == nil && resLen > 0 {
 		// Columns
-		err = mc.readUntilEOF()
+		err = mc.skipColumns(resLen)
 		if err != nil {
 			return err
 		}
@@ -130,7 +132,7 @@ func (stmt *mysqlStmt) Query(args []driver.Value) (driver.Rows, error) {
 		return nil, driver.ErrBadConn
 	}
 	// Send command
-	err := stmt.writeExecutePacket(args)
+	err := stmt.writeExecutePacket(args, cursorTypeNoCursor)
 	if err != nil {
 		return nil, err
 	}
@@ -154,13 +156,107 @@ func (stmt *mysqlStmt) Query(args []driver.Value) (driver.Rows, error) {
 			stmt.columns = rows.columns
 		} else {
 			rows.columns = stmt.columns
-			err = mc.readUntilEOF()
+			err = mc.skipColumns(resLen)
 		}
 	}
 
 	return rows, err
 }
 
+func (stmt *mysqlStmt) OpenCursor(args []driver.Value) (driver.Rows, bool, error) {
+	if stmt.mc.netConn == nil {
+		errLog.Print(ErrInvalidConn)
+		return nil, false, driver.ErrBadConn
+	}
+
+	if err := stmt.writeExecutePacket(args, cursorTypeReadOnly); err != nil {
+		return nil, false, err
+	}
+
+	mc := stmt.mc
+
+	// Read Result
+	resLen, err := mc.readResultSetHeaderPacket()
+	if err != nil {
+		return nil, false, err
+	}
+
+	rows := new(BinaryRows)
+	if resLen == 0 {
+		return rows, false, nil
+	}
+
+	if rows.columns, err = mc.readColumns(resLen); err != nil {
+		return nil, false, err
+	}
+
+	// the status of the cursor is in the EOF packet after the columns, or
+	// the one with the EOF header following them if the EOF is deprecated
+	if mc.deprecateEOF() {
+		data, err := mc.readPacket()
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch {
+		case mc.isEOFPacket(data):
+			rows.eof = mc.readEOFPacket(data)
+			if mc.status&statusCursorExists == 0 {
+				// no rows
+				return rows, false, mc.discardResults()
+			}
+		case data[0] == iERR:
+			return nil, false, mc.handleErrorPacket(data)
+		default:
+			// the first row, the buffer is reused by the next read
+			rows.first = append([]byte(nil), data...)
+			mc.status &^= statusCursorExists
+		}
+	}
+
+	// the server opens the cursor for the SELECT statements only, the rows
+	// follow the columns otherwise
+	if mc.status&statusCursorExists != 0 {
+		return rows, true, nil
+	}
+
+	rows.mc = mc
+	return rows, false, nil
+}
+
+func (stmt *mysqlStmt) Fetch(n uint32) ([]driver.RawPacket, bool, error) {
+	if stmt.mc.netConn == nil {
+		errLog.Print(ErrInvalidConn)
+		return nil, false, driver.ErrBadConn
+	}
+
+	if err := stmt.writeCommandFetch(n); err != nil {
+		return nil, false, err
+	}
+
+	mc := stmt.mc
+
+	var rows []driver.RawPacket
+	for {
+		data, err := mc.readPacket()
+		if err != nil {
+			return nil, false, err
+		}
+
+		switch {
+		case data[0] == iOK:
+			rows = append(rows, append(make([]byte, PacketHeaderLen, len(data)+PacketHeaderLen), data...))
+		case mc.isEOFPacket(data):
+			mc.readEOFPacket(data)
+			return rows, mc.status&statusLastRowSent != 0, nil
+		case data[0] == iERR:
+			return nil, false, mc.handleErrorPacket(data)
+		default:
+			return nil, false, ErrMalformPkt
+		}
+	}
+}
+
 func (s *mysqlStmt) Columns() []driver.RawPacket {
 	var ret []driver.RawPacket
 	for _, col := range s.prepareColumns {
@@ -203,6 +299,7 @@ func (stmt *mysqlStmt) Reset() (driver.Result, error) {
 			status:       mc.status,
 			warnings:     nil,
 			statusInfo:   mc.popStatusInfo(),
+			stateChanges: mc.popSessionState(),
 		}, nil
 	} else if errs, ok := err.(MySQLWarnings); ok {
 		return &MySQLResult{
@@ -211,6 +308,7 @@ func (stmt *mysqlStmt) Reset() (driver.Result, error) {
 			status:       mc.status,
 			warnings:     errs.Errors(),
 			statusInfo:   mc.popStatusInfo(),
+			stateChanges: mc.popSessionState(),
 		}, err
 	}
 
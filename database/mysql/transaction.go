@@ -10,6 +10,9 @@ package mysql
 
 type mysqlTx struct {
 	mc *MySQLConn
+
+	// the session state changes of the COMMIT or ROLLBACK, e.g. the GTID
+	stateChanges []SessionStateChange
 }
 
 func (tx *mysqlTx) Commit() (err error) {
@@ -18,6 +21,7 @@ func (tx *mysqlTx) Commit() (err error) {
 		return ErrInvalidConn
 	}
 	err = tx.mc.exec("COMMIT")
+	tx.stateChanges = tx.mc.popSessionState()
 
 	//TODO when to release the mc
 	//tx.mc = nil
@@ -29,6 +33,11 @@ func (tx *mysqlTx) Rollback() (err error) {
 		return ErrInvalidConn
 	}
 	err = tx.mc.exec("ROLLBACK")
+	tx.stateChanges = tx.mc.popSessionState()
 	//tx.mc = nil
 	return
 }
+
+func (tx *mysqlTx) SessionState() []SessionStateChange {
+	return tx.stateChanges
+}
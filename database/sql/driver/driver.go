@@ -171,6 +171,16 @@ type Stmt interface {
 	// SendLongData
 	SendLongData(paramId int, data []byte) error
 	Reset() (Result, error)
+
+	// OpenCursor executes a query with a read only cursor on the server,
+	// the returned Rows has the columns only and the rows are fetched by
+	// Fetch. If the server did not open a cursor, opened is false and the
+	// rows are returned like Query.
+	OpenCursor(args []Value) (rows Rows, opened bool, err error)
+
+	// Fetch fetches at most n rows of the cursor as raw packets, last
+	// reports whether the last row of the cursor has been sent.
+	Fetch(n uint32) (rows []RawPacket, last bool, err error)
 }
 
 // ColumnConverter may be optionally implemented by Stmt if the
@@ -216,6 +226,36 @@ type Rows interface {
 	NextRowPacket() (RawPacket, error)
 }
 
+// RowsNextResultSet extends Rows by providing a way to signal the driver to
+// advance to the next result, for the statements returning several results,
+// e.g. CALL of the stored procedures.
+type RowsNextResultSet interface {
+	Rows
+
+	// HasNextResultSet is called at the end of the current result and
+	// reports whether there is another result after the current one.
+	HasNextResultSet() bool
+
+	// NextResultSet advances the driver to the next result even if there
+	// are remaining rows in the current result. It should return io.EOF
+	// when there are no more results.
+	NextResultSet() error
+
+	// Result returns the result of the OK packet if the current result
+	// has no columns, otherwise nil.
+	Result() Result
+}
+
+// RowsEOFResult is implemented by Rows keeping the status of the packet at
+// the end of the rows, the EOF packet or the OK packet replacing it.
+type RowsEOFResult interface {
+	Rows
+
+	// EOFResult returns the status at the end of the current result, nil
+	// before the end is read.
+	EOFResult() Result
+}
+
 // Tx is a transaction.
 type Tx interface {
 	Commit() error
@@ -0,0 +1,423 @@
+// Package metrics implements the counters, gauges and histograms of the
+// proxy and exposes them in the Prometheus text format.
+//
+// All the metrics are registered in a default registry when created, the
+// registry is written by WriteText or served by Handler.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Collector writes the samples of one metric family
+type Collector interface {
+	// Name returns the metric family name
+	Name() string
+	// Collect writes the HELP, TYPE and sample lines of the family
+	Collect(w io.Writer)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Collector)
+)
+
+// Register adds c to the default registry, it panics if a collector with
+// the same name already registered.
+func Register(c Collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if _, ok := registry[c.Name()]; ok {
+		panic(fmt.Sprintf("metrics: duplicate metric %s", c.Name()))
+	}
+	registry[c.Name()] = c
+}
+
+// Unregister removes the collector with the given name
+func Unregister(name string) {
+	registryMu.Lock()
+	delete(registry, name)
+	registryMu.Unlock()
+}
+
+// WriteText writes all registered metrics ordered by name in the Prometheus
+// text exposition format.
+func WriteText(w io.Writer) error {
+	registryMu.RLock()
+	collectors := make([]Collector, 0, len(registry))
+	for _, c := range registry {
+		collectors = append(collectors, c)
+	}
+	registryMu.RUnlock()
+
+	sort.Sort(collectorsByName(collectors))
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.Collect(bw)
+	}
+	return bw.Flush()
+}
+
+// Handler returns the http handler serves the registered metrics
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		WriteText(w)
+	})
+}
+
+type collectorsByName []Collector
+
+func (s collectorsByName) Len() int           { return len(s) }
+func (s collectorsByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s collectorsByName) Less(i, j int) bool { return s[i].Name() < s[j].Name() }
+
+// desc is the common part of all the metric families
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d *desc) Name() string {
+	return d.name
+}
+
+func (d *desc) writeHeader(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// writeSample writes a sample line, extra is a label pair appended after the
+// labels of the family, such as le="0.1" of a histogram bucket.
+func (d *desc) writeSample(w io.Writer, suffix string, values []string, extra string, v float64) {
+	io.WriteString(w, d.name)
+	io.WriteString(w, suffix)
+
+	if len(values) > 0 || extra != "" {
+		io.WriteString(w, "{")
+		for i, label := range d.labels {
+			if i > 0 {
+				io.WriteString(w, ",")
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				io.WriteString(w, ",")
+			}
+			io.WriteString(w, extra)
+		}
+		io.WriteString(w, "}")
+	}
+
+	io.WriteString(w, " ")
+	io.WriteString(w, formatFloat(v))
+	io.WriteString(w, "\n")
+}
+
+func (d *desc) checkLabels(values []string) {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+}
+
+// value is a float64 could be updated atomically
+type value struct {
+	bits uint64
+}
+
+func (v *value) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		n := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, n) {
+			return
+		}
+	}
+}
+
+func (v *value) Set(f float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(f))
+}
+
+func (v *value) Get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Counter is a value only goes up
+type Counter struct {
+	value
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Gauge is a value could go up and down
+type Gauge struct {
+	value
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// vec holds the children of a family keyed by their label values
+type vec struct {
+	desc
+	mu       sync.RWMutex
+	children map[string]interface{}
+	values   map[string][]string
+	newChild func() interface{}
+}
+
+func newVec(name, help, typ string, labels []string, newChild func() interface{}) vec {
+	return vec{
+		desc:     desc{name: name, help: help, typ: typ, labels: labels},
+		children: make(map[string]interface{}),
+		values:   make(map[string][]string),
+		newChild: newChild,
+	}
+}
+
+func (v *vec) child(values []string) interface{} {
+	v.checkLabels(values)
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	c, ok := v.children[key]
+	v.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if c, ok = v.children[key]; !ok {
+		c = v.newChild()
+		v.children[key] = c
+		v.values[key] = append([]string(nil), values...)
+	}
+	return c
+}
+
+// Delete removes the child with the given label values
+func (v *vec) Delete(values ...string) {
+	key := strings.Join(values, "\xff")
+
+	v.mu.Lock()
+	delete(v.children, key)
+	delete(v.values, key)
+	v.mu.Unlock()
+}
+
+// each calls f with the children ordered by their label values
+func (v *vec) each(f func(values []string, c interface{})) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	children := make([]interface{}, len(keys))
+	values := make([][]string, len(keys))
+	for i, key := range keys {
+		children[i] = v.children[key]
+		values[i] = v.values[key]
+	}
+	v.mu.RUnlock()
+
+	for i := range children {
+		f(values[i], children[i])
+	}
+}
+
+// CounterVec is a family of counters partitioned by labels
+type CounterVec struct {
+	vec
+}
+
+// NewCounterVec creates and registers a CounterVec
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{newVec(name, help, "counter", labels, func() interface{} { return new(Counter) })}
+	Register(v)
+	return v
+}
+
+func (v *CounterVec) WithLabelValues(values ...string) *Counter {
+	return v.child(values).(*Counter)
+}
+
+func (v *CounterVec) Collect(w io.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, c interface{}) {
+		v.writeSample(w, "", values, "", c.(*Counter).Get())
+	})
+}
+
+// GaugeVec is a family of gauges partitioned by labels
+type GaugeVec struct {
+	vec
+}
+
+// NewGaugeVec creates and registers a GaugeVec
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{newVec(name, help, "gauge", labels, func() interface{} { return new(Gauge) })}
+	Register(v)
+	return v
+}
+
+func (v *GaugeVec) WithLabelValues(values ...string) *Gauge {
+	return v.child(values).(*Gauge)
+}
+
+func (v *GaugeVec) Collect(w io.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, c interface{}) {
+		v.writeSample(w, "", values, "", c.(*Gauge).Get())
+	})
+}
+
+// DefBuckets are the default histogram buckets in seconds, from 1ms to 10s
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts the observations in buckets
+type Histogram struct {
+	// count and sum are accessed atomically, keep them 64-bit aligned
+	count       uint64
+	sum         value
+	upperBounds []float64
+	counts      []uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		upperBounds: buckets,
+		counts:      make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upperBounds, v)
+	if i < len(h.counts) {
+		atomic.AddUint64(&h.counts[i], 1)
+	}
+	atomic.AddUint64(&h.count, 1)
+	h.sum.Add(v)
+}
+
+// HistogramVec is a family of histograms partitioned by labels
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogramVec creates and registers a HistogramVec, buckets must be
+// sorted in increasing order, DefBuckets is used if buckets is nil.
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+
+	v := &HistogramVec{buckets: buckets}
+	v.vec = newVec(name, help, "histogram", labels, func() interface{} { return newHistogram(buckets) })
+	Register(v)
+	return v
+}
+
+func (v *HistogramVec) WithLabelValues(values ...string) *Histogram {
+	return v.child(values).(*Histogram)
+}
+
+func (v *HistogramVec) Collect(w io.Writer) {
+	v.writeHeader(w)
+	v.each(func(values []string, c interface{}) {
+		h := c.(*Histogram)
+
+		var cumulative uint64
+		for i, upper := range h.upperBounds {
+			cumulative += atomic.LoadUint64(&h.counts[i])
+			v.writeSample(w, "_bucket", values, "le=\""+formatFloat(upper)+"\"", float64(cumulative))
+		}
+
+		count := atomic.LoadUint64(&h.count)
+		v.writeSample(w, "_bucket", values, "le=\"+Inf\"", float64(count))
+		v.writeSample(w, "_sum", values, "", h.sum.Get())
+		v.writeSample(w, "_count", values, "", float64(count))
+	})
+}
+
+// FuncVec is a family whose samples are computed by a function at scrape
+// time, it is used to export values kept by other modules such as the pool
+// statistics.
+type FuncVec struct {
+	desc
+	collect func(emit func(v float64, values ...string))
+}
+
+// NewGaugeFunc creates and registers a gauge family computed by collect
+func NewGaugeFunc(name, help string, labels []string, collect func(emit func(v float64, values ...string))) *FuncVec {
+	return newFuncVec(name, help, "gauge", labels, collect)
+}
+
+// NewCounterFunc creates and registers a counter family computed by collect
+func NewCounterFunc(name, help string, labels []string, collect func(emit func(v float64, values ...string))) *FuncVec {
+	return newFuncVec(name, help, "counter", labels, collect)
+}
+
+func newFuncVec(name, help, typ string, labels []string, collect func(emit func(v float64, values ...string))) *FuncVec {
+	v := &FuncVec{desc{name: name, help: help, typ: typ, labels: labels}, collect}
+	Register(v)
+	return v
+}
+
+func (v *FuncVec) Collect(w io.Writer) {
+	v.writeHeader(w)
+	v.collect(func(f float64, values ...string) {
+		v.checkLabels(values)
+		v.writeSample(w, "", values, "", f)
+	})
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var (
+	helpReplacer  = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
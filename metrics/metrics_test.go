@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func collect(c Collector) string {
+	var buf bytes.Buffer
+	c.Collect(&buf)
+	return buf.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	v := NewCounterVec("test_queries_total", "Total queries.", "type")
+	defer Unregister(v.Name())
+
+	v.WithLabelValues("select").Inc()
+	v.WithLabelValues("select").Add(2)
+	v.WithLabelValues("insert").Inc()
+
+	expect := `# HELP test_queries_total Total queries.
+# TYPE test_queries_total counter
+test_queries_total{type="insert"} 1
+test_queries_total{type="select"} 3
+`
+	if got := collect(v); got != expect {
+		t.Fatalf("expect:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	v := NewGaugeVec("test_sessions", "Active sessions.")
+	defer Unregister(v.Name())
+
+	g := v.WithLabelValues()
+	g.Inc()
+	g.Inc()
+	g.Dec()
+
+	if got := collect(v); !strings.Contains(got, "\ntest_sessions 1\n") {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+
+	g.Set(0.5)
+	if got := collect(v); !strings.Contains(got, "\ntest_sessions 0.5\n") {
+		t.Fatalf("unexpected output:\n%s", got)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	v := NewHistogramVec("test_duration_seconds", "Duration.", []float64{0.1, 1}, "type")
+	defer Unregister(v.Name())
+
+	h := v.WithLabelValues("select")
+	h.Observe(0.05)
+	h.Observe(0.1)
+	h.Observe(0.5)
+	h.Observe(3)
+
+	expect := `# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{type="select",le="0.1"} 2
+test_duration_seconds_bucket{type="select",le="1"} 3
+test_duration_seconds_bucket{type="select",le="+Inf"} 4
+test_duration_seconds_sum{type="select"} 3.65
+test_duration_seconds_count{type="select"} 4
+`
+	if got := collect(v); got != expect {
+		t.Fatalf("expect:\n%s\ngot:\n%s", expect, got)
+	}
+}
+
+func TestFuncVecAndHandler(t *testing.T) {
+	v := NewGaugeFunc("test_open_connections", "Open connections.", []string{"node"},
+		func(emit func(v float64, values ...string)) {
+			emit(3, `10.0.0.1:3306`)
+			emit(1, "a\"b")
+		})
+	defer Unregister(v.Name())
+
+	c := NewCounterVec("test_a_total", "A.")
+	defer Unregister(c.Name())
+	c.WithLabelValues().Inc()
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.String()
+	if !strings.Contains(body, `test_open_connections{node="10.0.0.1:3306"} 3`) ||
+		!strings.Contains(body, `test_open_connections{node="a\"b"} 1`) {
+		t.Fatalf("unexpected output:\n%s", body)
+	}
+
+	// metrics are ordered by name
+	if strings.Index(body, "test_a_total") > strings.Index(body, "test_open_connections") {
+		t.Fatalf("metrics are not ordered by name:\n%s", body)
+	}
+}
+
+func TestDuplicateRegister(t *testing.T) {
+	v := NewCounterVec("test_dup_total", "Dup.")
+	defer Unregister(v.Name())
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expect panic when register a duplicate metric")
+		}
+	}()
+	NewCounterVec("test_dup_total", "Dup.")
+}
@@ -5,5 +5,15 @@ type IStatement interface {
 }
 
 func SetParseTree(yylex interface{}, stmt IStatement) {
-	yylex.(*SQLLexer).ParseTree = stmt
+	lex := yylex.(*SQLLexer)
+	if h, ok := stmt.(IHintedStatement); ok && len(lex.hints) > 0 {
+		h.setHints(lex.hints)
+	}
+	lex.ParseTree = stmt
+}
+
+// EndOfStatement stops the parser at the ';' ending the statement, if the
+// lexer allows multi statements and more statements follow.
+func EndOfStatement(yylex interface{}) {
+	yylex.(*SQLLexer).endOfStatement()
 }
@@ -22,6 +22,7 @@ func (*Union) IStatement()       {}
 func (*SubQuery) IStatement()    {}
 
 type Union struct {
+	StmtHints
 	Left, Right ISelect
 }
 
@@ -70,6 +71,7 @@ func (s *SubQuery) GetSchemas() []string {
 
 // Select -----------
 type Select struct {
+	StmtHints
 	From     ITables
 	LockType LockType
 }
@@ -96,6 +98,7 @@ func (s *Select) GetSchemas() []string {
 
 // ParenSelect ------
 type ParenSelect struct {
+	StmtHints
 	Select ISelect
 }
 
@@ -151,6 +154,7 @@ func (i *Insert) GetSchemas() []string {
 }
 
 type Insert struct {
+	StmtHints
 	Table ISimpleTable
 	// can be `values(x,y,z)` list or `select` statement
 	InsertFields interface{}
@@ -170,7 +174,11 @@ func (u *Update) GetSchemas() []string {
 }
 
 type Update struct {
-	Tables ITables
+	StmtHints
+	Tables  ITables
+	Where   IExpr
+	OrderBy OrderBy
+	Limit   *Limit
 }
 
 /*********************************
@@ -178,8 +186,13 @@ type Update struct {
  ********************************/
 func (*Delete) IStatement() {}
 
+// ORDER BY and LIMIT are only allowed for the single table syntax
 type Delete struct {
-	Tables ITables
+	StmtHints
+	Tables  ITables
+	Where   IExpr
+	OrderBy OrderBy
+	Limit   *Limit
 }
 
 func (d *Delete) GetSchemas() []string {
@@ -223,6 +236,7 @@ func (r *Replace) GetSchemas() []string {
 }
 
 type Replace struct {
+	StmtHints
 	Table ITable
 	// can be `values(x,y,z)` list or `select` statement
 	ReplaceFields interface{}
@@ -332,7 +332,7 @@ type OrderBy []*Order
 
 // Order represents an ordering expression.
 type Order struct {
-	Expr      IValExpr
+	Expr      IExpr
 	Direction string
 }
 
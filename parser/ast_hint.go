@@ -0,0 +1,74 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+const hintPrefix = "dbatman:"
+
+// Hint is given in a `/*+ ... */` or `/* dbatman: ... */` comment of the
+// statement, such as `master`, `slave`, `node=10.1.1.1:3306` and
+// `timeout=100`. Hints unknown to the proxy, like the optimizer hints of
+// MySQL, are kept as well and left to the backend.
+type Hint struct {
+	Name  string // in lower case
+	Value string
+}
+
+type Hints []*Hint
+
+// Get returns the last hint of the name
+func (hs Hints) Get(name string) (*Hint, bool) {
+	for i := len(hs) - 1; i >= 0; i-- {
+		if hs[i].Name == name {
+			return hs[i], true
+		}
+	}
+	return nil, false
+}
+
+// IHintedStatement is implemented by the statements could carry hints
+type IHintedStatement interface {
+	IStatement
+	GetHints() Hints
+	setHints(Hints)
+}
+
+// StmtHints is embedded in the DML statements to carry the hints
+type StmtHints struct {
+	Hints Hints
+}
+
+func (s *StmtHints) GetHints() Hints {
+	return s.Hints
+}
+
+func (s *StmtHints) setHints(hints Hints) {
+	s.Hints = hints
+}
+
+var hintAssignRegexp = regexp.MustCompile(`\s*=\s*`)
+
+// ParseHints parses the hints separated by spaces or commas, a hint is either
+// a single name or a `name=value` pair.
+func ParseHints(s string) Hints {
+	s = hintAssignRegexp.ReplaceAllString(s, "=")
+
+	var hints Hints
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	}) {
+		h := new(Hint)
+		if i := strings.IndexByte(field, '='); i >= 0 {
+			h.Name, h.Value = field[:i], field[i+1:]
+		} else {
+			h.Name = field
+		}
+
+		h.Name = strings.ToLower(h.Name)
+		hints = append(hints, h)
+	}
+
+	return hints
+}
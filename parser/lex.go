@@ -46,6 +46,14 @@ type SQLLexer struct {
 
 	errorToken *string
 
+	// hints kept from the `/*+ ... */` and `/* dbatman: ... */` comments
+	hints Hints
+
+	// multi_statements allows the statements after the first one, the
+	// parser stops at found_semicolon, where the next statement begins
+	multi_statements bool
+	found_semicolon  uint
+
 	ParseTree IStatement
 	LastError string
 }
@@ -402,13 +410,18 @@ func (lex *SQLLexer) Lex(lval *MySQLSymType) (retstate int) {
 			}
 
 			// scan util match `*/`
+			start := lex.ptr
 			for c = lex.yyNext(); c != EOF && !(c == '*' && lex.yyPeek() == '/'); c = lex.yyNext() {
 			}
 
+			end := lex.ptr
 			if c == '*' {
+				end = lex.ptr - 1
 				lex.yySkip() // skip for '*/'
 			}
 
+			lex.keepHints(lex.buf[start:end])
+
 			state = MY_LEX_START
 
 		case MY_LEX_END_LONG_COMMENT:
@@ -536,6 +549,23 @@ TG_RET:
 	return
 }
 
+// endOfStatement is called right after the ';' ending a statement, the lexer
+// returns the end of input then if there are more statements, like MySQL
+// does for CLIENT_MULTI_STATEMENTS.
+func (lex *SQLLexer) endOfStatement() {
+	if !lex.multi_statements {
+		return
+	}
+
+	for i := lex.ptr; i < uint(len(lex.buf)); i++ {
+		if lex.cs.StateMap[lex.buf[i]] != MY_LEX_SKIP {
+			lex.found_semicolon = lex.ptr
+			lex.next_state = MY_LEX_END
+			return
+		}
+	}
+}
+
 // return current char
 func (lex *SQLLexer) yyNext() (b byte) {
 
@@ -581,6 +611,23 @@ func (lex *SQLLexer) yySkip() {
 	return
 }
 
+// keepHints keeps the hints if the comment body is a `+ ...` or
+// a `dbatman: ...` one, other comments are dropped.
+func (lex *SQLLexer) keepHints(comment []byte) {
+	body := string(comment)
+	if strings.HasPrefix(body, "+") {
+		body = body[1:]
+	} else {
+		body = strings.TrimSpace(body)
+		if len(body) < len(hintPrefix) || !strings.EqualFold(body[:len(hintPrefix)], hintPrefix) {
+			return
+		}
+		body = body[len(hintPrefix):]
+	}
+
+	lex.hints = append(lex.hints, ParseHints(body)...)
+}
+
 // Error is called by go yacc if there's a parsing error.
 func (lexer *SQLLexer) Error(err string) {
 	buf := bytes.NewBuffer(make([]byte, 0, 32))
@@ -13,3 +13,23 @@ func Parse(sql string) (IStatement, error) {
 
 	return lexer.ParseTree, nil
 }
+
+// ParseMulti parses the first statement of sql, which may be followed by more
+// statements separated by ';' like the queries of the clients with
+// CLIENT_MULTI_STATEMENTS. It returns the statement, its text and the text of
+// the statements left, which is empty after the last statement. A nil
+// statement is returned if sql has no statement, e.g. comments only.
+func ParseMulti(sql string) (stmt IStatement, query string, rest string, err error) {
+	lexer := NewSQLLexer(sql)
+	lexer.multi_statements = true
+	if MySQLParse(lexer) != 0 {
+		return nil, "", "", errors.New(lexer.LastError)
+	}
+
+	if lexer.found_semicolon == 0 {
+		return lexer.ParseTree, sql, "", nil
+	}
+
+	// the text of the statement without the ';'
+	return lexer.ParseTree, sql[:lexer.found_semicolon-1], sql[lexer.found_semicolon:], nil
+}
@@ -32,6 +32,24 @@ func TestSet(t *testing.T) {
 	}
 
 	testParse(`set @var=(1 in (select * from t1))`, t, false)
+
+	st = testParse(`set time_zone = '+08:00', @uid = 1, @@session.sql_mode = ''`, t, false)
+	set = st.(*Set)
+
+	for i, expect := range []struct {
+		typ  VarType
+		life LifeType
+		name string
+	}{
+		{Type_Sys, Life_Unknown, "time_zone"},
+		{Type_Usr, Life_Unknown, "uid"},
+		{Type_Sys, Life_Session, "sql_mode"},
+	} {
+		v = set.VarList[i]
+		if v.Type != expect.typ || v.Life != expect.life || v.Name != expect.name {
+			t.Fatalf("expect %v, got %v", expect, *v)
+		}
+	}
 }
 
 func TestShow(t *testing.T) {
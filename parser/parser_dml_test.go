@@ -111,6 +111,50 @@ func TestSelect(t *testing.T) {
 	testParse(`SELECT ?,?,? from t1;`, t, false)
 }
 
+func matchHints(t *testing.T, st IStatement, hints ...string) {
+	h, ok := st.(IHintedStatement)
+	if !ok {
+		t.Fatalf("statement %T can not carry hints", st)
+	}
+
+	var ret []string
+	for _, hint := range h.GetHints() {
+		if hint.Value != "" {
+			ret = append(ret, hint.Name+"="+hint.Value)
+		} else {
+			ret = append(ret, hint.Name)
+		}
+	}
+
+	if !reflect.DeepEqual(ret, hints) && !(len(ret) == 0 && len(hints) == 0) {
+		t.Fatalf("expect hints%v not match return%v", hints, ret)
+	}
+}
+
+func TestHints(t *testing.T) {
+	st := testParse(`SELECT /*+ master */ * FROM t1`, t, false)
+	matchHints(t, st, "master")
+
+	st = testParse(`/* dbatman: node = 10.1.1.1:3306, timeout=100 */ SELECT * FROM t1`, t, false)
+	matchHints(t, st, "node=10.1.1.1:3306", "timeout=100")
+
+	st = testParse(`SELECT /*+ MAX_EXECUTION_TIME(1000) SLAVE */ * FROM t1 UNION SELECT * FROM t2`, t, false)
+	matchHints(t, st, "max_execution_time(1000)", "slave")
+
+	st = testParse(`INSERT /*+ timeout=50 */ INTO t1 VALUES(1)`, t, false)
+	matchHints(t, st, "timeout=50")
+
+	st = testParse(`UPDATE /* dbatman:master */ t1 SET a = 1`, t, false)
+	matchHints(t, st, "master")
+
+	st = testParse(`DELETE /*+ master */ FROM t1`, t, false)
+	matchHints(t, st, "master")
+
+	// normal comments are not hints
+	st = testParse(`SELECT /*mark for picman*/ * FROM t1`, t, false)
+	matchHints(t, st)
+}
+
 func TestInsert(t *testing.T) {
 	st := testParse(`INSERT INTO db1.tbl_temp2 (fld_id)
         SELECT tempdb.tbl_temp1.fld_order_id
@@ -128,12 +172,35 @@ func TestUpdate(t *testing.T) {
 	st = testParse(`UPDATE db1.items,db2.month SET items.price=month.price
     WHERE items.id=month.id;`, t, false)
 	matchSchemas(t, st, "db1", "db2")
+	if st.(*Update).Where == nil {
+		t.Fatal("expect the where clause")
+	}
+
+	st = testParse(`UPDATE t1 SET col1 = 0 WHERE col2 > 1 ORDER BY id DESC, col2 LIMIT 10`, t, false)
+	u := st.(*Update)
+	if _, ok := u.Where.(*CompareExpr); !ok {
+		t.Fatalf("expect compare expr, got %T", u.Where)
+	}
+	if len(u.OrderBy) != 2 || u.OrderBy[0].Direction != OP_DESC || u.OrderBy[1].Direction != OP_ASC {
+		t.Fatalf("expect order by id desc, col2, got %v", u.OrderBy)
+	}
+	if u.Limit == nil || string(u.Limit.Rowcount.(NumVal)) != "10" {
+		t.Fatalf("expect limit 10, got %v", u.Limit)
+	}
+
+	st = testParse(`UPDATE t1 SET col1 = 0`, t, false)
+	if u := st.(*Update); u.Where != nil || u.OrderBy != nil || u.Limit != nil {
+		t.Fatalf("expect no where, order by or limit, got %v", u)
+	}
 }
 
 func TestDelete(t *testing.T) {
 	st := testParse(`DELETE FROM db.somelog WHERE user = 'jcole'
     ORDER BY timestamp_column LIMIT 1;`, t, false)
 	matchSchemas(t, st, "db")
+	if d := st.(*Delete); d.Where == nil || len(d.OrderBy) != 1 || d.Limit == nil {
+		t.Fatalf("expect where, order by and limit, got %v", d)
+	}
 
 	st = testParse(`DELETE FROM db1.t1, db2.t2 USING t1 INNER JOIN t2 INNER JOIN db3.t3
     WHERE t1.id=t2.id AND t2.id=t3.id;`, t, false)
@@ -142,6 +209,14 @@ func TestDelete(t *testing.T) {
 	st = testParse(`DELETE FROM a1, a2 USING db1.t1 AS a1 INNER JOIN t2 AS a2
     WHERE a1.id=a2.id;`, t, false)
 	matchSchemas(t, st, "db1")
+	if st.(*Delete).Where == nil {
+		t.Fatal("expect the where clause")
+	}
+
+	st = testParse(`DELETE FROM t1`, t, false)
+	if d := st.(*Delete); d.Where != nil || d.Limit != nil {
+		t.Fatalf("expect no where or limit, got %v", d)
+	}
 }
 
 func TestReplace(t *testing.T) {
@@ -49,3 +49,41 @@ func TestTokenName(t *testing.T) {
 		t.Fatal("get token name error")
 	}
 }
+
+func TestParseMulti(t *testing.T) {
+	sql := "select 1; update t set a = ';' ;\n  select /* ; */ 2 ; "
+
+	var queries []string
+	for sql != "" {
+		stmt, query, rest, err := ParseMulti(sql)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		if stmt == nil {
+			t.Fatalf("expect a statement in %q", sql)
+		}
+		queries = append(queries, query)
+		sql = rest
+	}
+
+	expect := []string{"select 1", " update t set a = ';' ", "\n  select /* ; */ 2 ; "}
+	if fmt.Sprintf("%q", queries) != fmt.Sprintf("%q", expect) {
+		t.Fatalf("expect %q, got %q", expect, queries)
+	}
+
+	// the compound statements are not split
+	if _, _, rest, err := ParseMulti("CREATE PROCEDURE p() BEGIN SELECT 1; SELECT 2; END"); err != nil {
+		t.Fatalf("%v", err)
+	} else if rest != "" {
+		t.Fatalf("expect one statement, got rest %q", rest)
+	}
+
+	if _, _, _, err := ParseMulti("select 1 select 2; select 3"); err == nil {
+		t.Fatal("expect syntax error")
+	}
+
+	// multi statements are not allowed by Parse
+	if _, err := Parse("select 1; select 2"); err == nil {
+		t.Fatal("expect syntax error")
+	}
+}
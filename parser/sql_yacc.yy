@@ -61,6 +61,10 @@ import ()
 
     like_or_where *LikeOrWhere
 
+    order *Order
+    order_by OrderBy
+    limit *Limit
+
     variable *Variable
     vars Vars
     var_type VarType
@@ -789,24 +793,26 @@ import ()
 
 %type <like_or_where> wild_and_where
 
-%type <str> internal_variable_name comp_op
+%type <str> internal_variable_name comp_op order_dir
 %type <variable> option_value_no_option_type option_value_following_option_type option_value
 %type <vars> option_value_list_continued option_value_list
 
 %type <life_type> option_type opt_var_ident_type
 %type <var_type> 
 
-%type <expr> expr set_expr_or_default
+%type <expr> expr set_expr_or_default where_clause order_ident
+%type <order_by> opt_order_clause order_clause order_list
+%type <limit> delete_limit_clause
 %type <exprs> expr_list
 %type <boolexpr> bool_pri
-%type <valexpr> predicate bit_expr simple_expr simple_ident literal param_marker variable text_literal temporal_literal NUM_literal simple_ident_q 
+%type <valexpr> predicate bit_expr simple_expr simple_ident literal param_marker variable text_literal temporal_literal NUM_literal simple_ident_q limit_option 
 
 %%
 
 
 query:
   END_OF_INPUT { SetParseTree(yylex, nil) } 
-| verb_clause ';' opt_end_of_input { SetParseTree(yylex, $1) } 
+| verb_clause ';' { EndOfStatement(yylex) } opt_end_of_input { SetParseTree(yylex, $1) } 
 | verb_clause END_OF_INPUT { SetParseTree(yylex, $1) }
 ; 
 
@@ -3295,8 +3301,8 @@ opt_all:
 | ALL;
 
 where_clause:
- 
-| WHERE expr;
+  { $$ = nil }
+| WHERE expr { $$ = $2 };
 
 having_clause:
  
@@ -3330,20 +3336,20 @@ alter_order_item:
   simple_ident_nospvar order_dir;
 
 opt_order_clause:
- 
+  { $$ = nil }
 | order_clause;
 
 order_clause:
-  ORDER_SYM BY order_list;
+  ORDER_SYM BY order_list { $$ = $3 };
 
 order_list:
-  order_list ',' order_ident order_dir
-| order_ident order_dir;
+  order_list ',' order_ident order_dir { $$ = append($1, &Order{Expr: $3, Direction: $4}) }
+| order_ident order_dir { $$ = OrderBy{&Order{Expr: $1, Direction: $2}} };
 
 order_dir:
- 
-| ASC
-| DESC;
+  { $$ = OP_ASC }
+| ASC { $$ = OP_ASC }
+| DESC { $$ = OP_DESC };
 
 opt_limit_clause_init:
  
@@ -3362,15 +3368,15 @@ limit_options:
 | limit_option OFFSET_SYM limit_option;
 
 limit_option:
-  ident
+  ident { $$ = &SchemaObject{Column: $1} }
 | param_marker
-| ULONGLONG_NUM
-| LONG_NUM
-| NUM;
+| ULONGLONG_NUM { $$ = NumVal($1) }
+| LONG_NUM { $$ = NumVal($1) }
+| NUM { $$ = NumVal($1) };
 
 delete_limit_clause:
- 
-| LIMIT limit_option;
+  { $$ = nil }
+| LIMIT limit_option { $$ = &Limit{Rowcount: $2} };
 
 ulong_num:
   NUM
@@ -3603,7 +3609,7 @@ opt_insert_update:
 update:
   UPDATE_SYM opt_low_priority opt_ignore join_table_list SET update_list where_clause opt_order_clause delete_limit_clause 
   { 
-    $$ = &Update{Tables: $4}
+    $$ = &Update{Tables: $4, Where: $7, OrderBy: $8, Limit: $9}
   }
 ;
 
@@ -3631,11 +3637,11 @@ delete:
 
 single_multi:
   FROM table_ident opt_use_partition where_clause opt_order_clause delete_limit_clause 
-  { $$ = &Delete{Tables: ITables{$2}} }
+  { $$ = &Delete{Tables: ITables{$2}, Where: $4, OrderBy: $5, Limit: $6} }
 | table_wild_list FROM join_table_list where_clause 
-  { $$ = &Delete{Tables: append($1, $3...)} }
+  { $$ = &Delete{Tables: append($1, $3...), Where: $4} }
 | FROM table_alias_ref_list USING join_table_list where_clause
-  { $$ = &Delete{Tables: append($2, $4...)} }
+  { $$ = &Delete{Tables: append($2, $4...), Where: $5} }
 ;
 
 table_wild_list:
@@ -4464,7 +4470,7 @@ start_option_value_list:
     if $2 == nil {
         $$ = &Set{VarList: Vars{$1}}
     } else {
-        $$ = &Set{VarList: append($2, $1)}
+        $$ = &Set{VarList: append(Vars{$1}, $2...)}
     }
   }
 | TRANSACTION_SYM transaction_characteristics { $$ = &SetTrans{} }
@@ -4529,11 +4535,11 @@ opt_var_ident_type:
 
 option_value_following_option_type:
   internal_variable_name equal set_expr_or_default 
-  { $$ = &Variable{Type: Type_Usr, Name: $1, Value: $3} };
+  { $$ = &Variable{Type: Type_Sys, Name: $1, Value: $3} };
 
 option_value_no_option_type:
   internal_variable_name equal set_expr_or_default 
-  { $$ = &Variable{Type: Type_Usr, Name: $1, Value: $3} }
+  { $$ = &Variable{Type: Type_Sys, Name: $1, Value: $3} }
 | '@' ident_or_text equal expr 
   { $$ = &Variable{Type: Type_Usr, Name: string($2), Value: $4} }
 | '@' '@' opt_var_ident_type internal_variable_name equal set_expr_or_default
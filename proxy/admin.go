@@ -0,0 +1,409 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/dbatman/cmd/version"
+	"github.com/bytedance/dbatman/config"
+	"github.com/bytedance/dbatman/database/cluster"
+	"github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/hack"
+	"github.com/ngaut/log"
+)
+
+// AdminSession is a client connection on the manage port, it speaks the
+// MySQL protocol so the proxy can be managed by the mysql cli.
+type AdminSession struct {
+	server  *Server
+	config  *config.ProxyConfig
+	salt    []byte
+	cliAddr string
+	fc      *mysql.MySQLServerConn
+}
+
+type adminHandler func(session *AdminSession, args []string) error
+
+// adminCommands maps the admin statements to their handlers, a "?" in the
+// pattern matches any token and is passed to the handler as argument.
+var adminCommands = []struct {
+	pattern []string
+	handler adminHandler
+}{
+	{[]string{"SHOW", "PROXY", "SESSIONS"}, (*AdminSession).handleShowSessions},
+	{[]string{"SHOW", "PROXY", "CLUSTERS"}, (*AdminSession).handleShowClusters},
+	{[]string{"SHOW", "PROXY", "FINGERPRINTS"}, (*AdminSession).handleShowFingerprints},
+	{[]string{"KILL", "SESSION", "?"}, (*AdminSession).handleKillSession},
+	{[]string{"RELOAD", "CONFIG"}, (*AdminSession).handleReloadConfig},
+	{[]string{"FAILOVER", "CLUSTER", "?"}, (*AdminSession).handleFailover},
+	{[]string{"FAILOVER", "CLUSTER", "?", "TO", "?"}, (*AdminSession).handleFailover},
+}
+
+func (s *Server) listenManage() error {
+	gc := s.cfg.GetConfig().Global
+	if gc.ManagePort <= 0 || gc.ManageUser == "" {
+		log.Info("manage_port or manage_user is not set, admin interface is disabled")
+		return nil
+	}
+
+	var err error
+	if s.manageListener, err = net.Listen("tcp", fmt.Sprintf(":%d", gc.ManagePort)); err != nil {
+		return err
+	}
+
+	log.Infof("Dbatman Manage Listen(tcp) at [%d]", gc.ManagePort)
+	return nil
+}
+
+func (s *Server) closeManage() {
+	if s.manageListener != nil {
+		s.manageListener.Close()
+	}
+}
+
+func (s *Server) serveManage(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				log.Warnf("manage accept error %s", err.Error())
+				continue
+			}
+
+			log.Infof("manage listener closed: %s", err.Error())
+			return
+		}
+
+		go s.onManageConn(conn)
+	}
+}
+
+func (s *Server) onManageConn(c net.Conn) {
+	session := new(AdminSession)
+	session.server = s
+	session.config = s.cfg.GetConfig()
+	session.salt, _ = RandomBuf(20)
+	session.cliAddr = clientHost(c.RemoteAddr())
+	session.fc = s.newServerConn(session, c)
+
+	defer session.fc.Close()
+
+	if err := session.fc.Handshake(); err != nil {
+		log.Warnf("admin session %s handshake error: %s", session.cliAddr, err)
+		return
+	}
+
+	log.Infof("admin session %s connected", session.cliAddr)
+	if err := session.Run(); err != nil && err != errSessionQuit {
+		log.Warnf("admin session %s closed: %s", session.cliAddr, err)
+	}
+}
+
+func (session *AdminSession) Salt() []byte {
+	return session.salt
+}
+
+func (session *AdminSession) ServerName() []byte {
+	return hack.Slice(version.Version)
+}
+
+func (session *AdminSession) CheckAuth(username string, passwd []byte, db string) error {
+	gc := session.config.Global
+
+	if err := session.server.ipRules(session.config).checkGlobal(session.cliAddr); err != nil {
+		return err
+	}
+
+	if username != gc.ManageUser {
+		return mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, username, session.cliAddr, "Yes")
+	}
+	if err := checkPassword(session.fc.AuthPlugin(), session.salt, passwd, gc.ManagePassword); err != nil {
+		if err == mysql.ErrFullAuthRequired {
+			return err
+		}
+		return mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, username, session.cliAddr, "Yes")
+	}
+
+	return nil
+}
+
+func (session *AdminSession) Run() error {
+	for {
+		data, err := session.fc.ReadPacket()
+		if err != nil {
+			return err
+		}
+
+		if data[0] == mysql.ComQuit {
+			return errSessionQuit
+		}
+
+		if err := session.dispatch(data); err != nil {
+			return err
+		}
+
+		session.fc.ResetSequence()
+	}
+}
+
+func (session *AdminSession) dispatch(data []byte) (err error) {
+	cmd := data[0]
+	data = data[1:]
+
+	defer func() {
+		flush_error := session.fc.Flush()
+		if err == nil {
+			err = flush_error
+		}
+	}()
+
+	switch cmd {
+	case mysql.ComQuery:
+		err = session.comQuery(hack.String(data))
+	case mysql.ComPing, mysql.ComInitDB:
+		err = session.fc.WriteOK(nil)
+	default:
+		err = session.handleMySQLError(mysql.NewDefaultError(mysql.ER_UNKNOWN_COM_ERROR))
+	}
+
+	return
+}
+
+func (session *AdminSession) comQuery(sqlstmt string) error {
+	log.Infof("admin session %s: %s", session.cliAddr, sqlstmt)
+
+	tokens := strings.Fields(strings.TrimRight(strings.TrimSpace(sqlstmt), ";"))
+	if len(tokens) == 0 {
+		return session.handleMySQLError(mysql.NewDefaultError(mysql.ER_EMPTY_QUERY))
+	}
+
+	for _, cmd := range adminCommands {
+		if args, ok := matchAdminCommand(cmd.pattern, tokens); ok {
+			return cmd.handler(session, args)
+		}
+	}
+
+	// statements sent by the mysql cli and the drivers after connected
+	switch strings.ToUpper(tokens[0]) {
+	case "SELECT":
+		return session.handleSelectVariables(tokens[1:])
+	case "SET":
+		return session.fc.WriteOK(nil)
+	}
+
+	return session.handleMySQLError(mysql.NewDefaultError(mysql.ER_SYNTAX_ERROR, sqlstmt))
+}
+
+func matchAdminCommand(pattern []string, tokens []string) ([]string, bool) {
+	if len(pattern) != len(tokens) {
+		return nil, false
+	}
+
+	var args []string
+	for i, p := range pattern {
+		if p == "?" {
+			args = append(args, tokens[i])
+		} else if !strings.EqualFold(p, tokens[i]) {
+			return nil, false
+		}
+	}
+
+	return args, true
+}
+
+func (session *AdminSession) handleShowSessions(args []string) error {
+	names := []string{"Id", "User", "Host", "DB", "Autocommit", "InTransaction", "Time"}
+	var values [][]interface{}
+
+	for _, s := range session.server.Sessions() {
+		state := s.snapshot()
+		values = append(values, []interface{}{
+			s.sessionId,
+			state.user,
+			s.cliAddr,
+			state.db,
+			yesOrNo(state.autocommit),
+			yesOrNo(state.inTrans),
+			int64(time.Since(s.startTime).Seconds()),
+		})
+	}
+
+	return session.writeResultset(names, values)
+}
+
+func (session *AdminSession) handleShowClusters(args []string) error {
+	names := []string{"Cluster", "DB", "Role", "Node", "Alive", "OpenConnections", "FreeConnections",
+		"ReplicationLag", "Lagging"}
+	var values [][]interface{}
+
+	for _, c := range cluster.Clusters() {
+		for _, node := range c.Stats() {
+			values = append(values, []interface{}{
+				c.Name(),
+				c.DBName,
+				node.Role,
+				node.Addr,
+				yesOrNo(node.AliveStatus),
+				node.OpenConnections,
+				node.FreeConnections,
+				node.ReplicationLag,
+				yesOrNo(node.Lagging),
+			})
+		}
+	}
+
+	return session.writeResultset(names, values)
+}
+
+func (session *AdminSession) handleShowFingerprints(args []string) error {
+	names := []string{"Fingerprint", "Count", "QPS", "Rejected"}
+
+	fps := session.server.fingerprints.all()
+	sort.Sort(limitReqNodesByCount(fps))
+
+	values := make([][]interface{}, 0, len(fps))
+	for _, lr := range fps {
+		values = append(values, []interface{}{lr.Query(), lr.Count(), lr.QPS(), lr.Rejected()})
+	}
+
+	return session.writeResultset(names, values)
+}
+
+func (session *AdminSession) handleKillSession(args []string) error {
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return session.handleMySQLError(mysql.NewDefaultError(mysql.ER_SYNTAX_ERROR, args[0]))
+	}
+
+	if err := session.server.KillSession(id); err != nil {
+		e := mysql.NewDefaultError(mysql.ER_NO_SUCH_THREAD)
+		e.Message = err.Error()
+		return session.handleMySQLError(e)
+	}
+
+	log.Infof("admin session %s kill session %d", session.cliAddr, id)
+	return session.fc.WriteOK(nil)
+}
+
+func (session *AdminSession) handleReloadConfig(args []string) error {
+	if err := session.server.cfg.Reload(); err != nil {
+		log.Errorf("admin session %s reload config error %s", session.cliAddr, err.Error())
+		e := mysql.NewDefaultError(mysql.ER_UNKNOWN_ERROR)
+		e.Message = err.Error()
+		return session.handleMySQLError(e)
+	}
+
+	// let the clusters reload their nodes, unless a reload is pending
+	select {
+	case cluster.NotifyChan <- true:
+	default:
+	}
+
+	session.config = session.server.cfg.GetConfig()
+	log.Infof("admin session %s reload config", session.cliAddr)
+	return session.fc.WriteOK(nil)
+}
+
+// handleFailover promotes a slave of the cluster to master once the master
+// is down, the slave is the one given by "TO host:port", or a candidate.
+func (session *AdminSession) handleFailover(args []string) error {
+	c, err := cluster.New(args[0])
+	if err != nil {
+		e := mysql.NewDefaultError(mysql.ER_UNKNOWN_ERROR)
+		e.Message = err.Error()
+		return session.handleMySQLError(e)
+	}
+
+	var addr string
+	if len(args) > 1 {
+		addr = args[1]
+	}
+
+	if err := c.Failover(addr); err != nil {
+		log.Errorf("admin session %s failover cluster %s error %s", session.cliAddr, args[0], err.Error())
+		e := mysql.NewDefaultError(mysql.ER_UNKNOWN_ERROR)
+		e.Message = err.Error()
+		return session.handleMySQLError(e)
+	}
+
+	log.Infof("admin session %s failover cluster %s", session.cliAddr, args[0])
+	return session.fc.WriteOK(nil)
+}
+
+// handleSelectVariables answers the system variables queried by the clients
+// after connected, such as "select @@version_comment limit 1".
+func (session *AdminSession) handleSelectVariables(tokens []string) error {
+	var exprs []string
+	for _, token := range tokens {
+		if strings.EqualFold(token, "LIMIT") {
+			break
+		}
+		for _, expr := range strings.Split(token, ",") {
+			if expr != "" {
+				exprs = append(exprs, expr)
+			}
+		}
+	}
+
+	if len(exprs) == 0 {
+		return session.handleMySQLError(mysql.NewDefaultError(mysql.ER_SYNTAX_ERROR, "SELECT"))
+	}
+
+	row := make([]interface{}, len(exprs))
+	for i, expr := range exprs {
+		name := strings.ToLower(strings.TrimPrefix(expr, "@@"))
+		name = strings.TrimPrefix(strings.TrimPrefix(name, "session."), "global.")
+
+		switch name {
+		case "version":
+			row[i] = version.Version
+		case "version_comment":
+			row[i] = "dbatman admin"
+		case "max_allowed_packet":
+			row[i] = mysql.MaxPacketSize
+		default:
+			return session.handleMySQLError(mysql.NewDefaultError(mysql.ER_UNKNOWN_SYSTEM_VARIABLE, expr))
+		}
+	}
+
+	return session.writeResultset(exprs, [][]interface{}{row})
+}
+
+func (session *AdminSession) writeResultset(names []string, values [][]interface{}) error {
+	rs, err := buildResultset(session.fc.Collation(), names, values)
+	if err != nil {
+		return err
+	}
+
+	if err := writeResultset(session.fc, rs); err != nil {
+		return session.handleMySQLError(err)
+	}
+
+	return nil
+}
+
+func (session *AdminSession) handleMySQLError(e error) error {
+	switch inst := e.(type) {
+	case *mysql.MySQLError:
+		return session.fc.WriteError(inst)
+	default:
+		return e
+	}
+}
+
+type limitReqNodesByCount []*LimitReqNode
+
+func (s limitReqNodesByCount) Len() int           { return len(s) }
+func (s limitReqNodesByCount) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s limitReqNodesByCount) Less(i, j int) bool { return s[i].Count() > s[j].Count() }
+
+func yesOrNo(b bool) string {
+	if b {
+		return "Yes"
+	}
+	return "No"
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/bytedance/dbatman/config"
+	"github.com/bytedance/dbatman/database/mysql"
+)
+
+var testAdminDSN = "proxy_admin:proxy_admin_passwd@tcp(127.0.0.1:4308)/"
+
+func TestAdmin_ShowSessions(t *testing.T) {
+	proxy := newSqlDB(testProxyDSN)
+	defer proxy.Close()
+
+	admin := newSqlDB(testAdminDSN)
+	defer admin.Close()
+
+	rows, err := admin.Query("show proxy sessions")
+	if err != nil {
+		t.Fatalf("show proxy sessions failed: %s", err.Error())
+	}
+	defer rows.Close()
+
+	found := false
+	for rows.Next() {
+		var id, seconds int64
+		var user, host, db, autocommit, intrans string
+		if err := rows.Scan(&id, &user, &host, &db, &autocommit, &intrans, &seconds); err != nil {
+			t.Fatal(err)
+		}
+
+		if user == "proxy_mysql_user" && db == "dbatman_test" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatal("expect the session of proxy_mysql_user in show proxy sessions")
+	}
+}
+
+// TestAdmin_SessionSnapshot reads the state of the session while it runs
+// commands, run it with -race
+func TestAdmin_SessionSnapshot(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+
+	session := &Session{user: &config.UserConfig{Username: "a"}}
+	session.fc = mysql.NewMySQLServerConn(session, server)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			session.fc.XORStatus(uint16(mysql.StatusInAutocommit))
+			session.publishState()
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		if state := session.snapshot(); state.user != "a" && state.user != "" {
+			t.Fatalf("unexpected user %q", state.user)
+		}
+	}
+	wg.Wait()
+
+	if state := session.snapshot(); state.user != "a" || state.inTrans {
+		t.Fatalf("expect user a out of the transaction, got %+v", state)
+	}
+}
+
+func TestAdmin_ShowClusters(t *testing.T) {
+	admin := newSqlDB(testAdminDSN)
+	defer admin.Close()
+
+	rows, err := admin.Query("SHOW PROXY CLUSTERS;")
+	if err != nil {
+		t.Fatalf("show proxy clusters failed: %s", err.Error())
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var name, db, role, node, alive, lagging string
+		var open, free, lag int
+		if err := rows.Scan(&name, &db, &role, &node, &alive, &open, &free, &lag, &lagging); err != nil {
+			t.Fatal(err)
+		}
+
+		if name == "dbatman_test_cluster" {
+			roles = append(roles, role)
+		}
+	}
+
+	if len(roles) != 2 || roles[0] != "master" || roles[1] != "slave" {
+		t.Fatalf("expect a master and a slave, got %v", roles)
+	}
+}
+
+func TestAdmin_KillSession(t *testing.T) {
+	conn := newRawProxyConn(t)
+	defer conn.Close()
+
+	admin := newSqlDB(testAdminDSN)
+	defer admin.Close()
+
+	var id int64
+	for _, s := range testServer.Sessions() {
+		if s.sessionId > id {
+			id = s.sessionId
+		}
+	}
+
+	if _, err := admin.Exec("kill session 99999999"); err == nil {
+		t.Fatal("expect an error when kill a session not exists")
+	}
+
+	if _, err := admin.Exec("kill session " + strconv.FormatInt(id, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.WriteCommandPacket(mysql.ComPing); err == nil {
+		if _, err := conn.ReadPacket(); err == nil {
+			t.Fatal("expect the killed session can not be used any more")
+		}
+	}
+}
+
+func TestAdmin_UnknownStatement(t *testing.T) {
+	admin := newSqlDB(testAdminDSN)
+	defer admin.Close()
+
+	if _, err := admin.Exec("show proxy nothing"); err == nil {
+		t.Fatal("expect an error result packet")
+	} else if e, ok := err.(*mysql.MySQLError); !ok {
+		t.Fatal(err)
+	} else if e.Number != mysql.ER_SYNTAX_ERROR {
+		t.Fatalf("expect a syntax error, got %d", e.Number)
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"io"
+	"strings"
 
 	. "github.com/bytedance/dbatman/database/mysql"
 	"github.com/ngaut/log"
@@ -62,71 +66,159 @@ func CalcPassword(scramble, password []byte) []byte {
 	return scramble
 }
 
+// CalcCachingSha2Password returns the scramble of caching_sha2_password:
+// XOR(SHA256(password), SHA256(SHA256(SHA256(password)), scramble))
+func CalcCachingSha2Password(scramble, password []byte) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+
+	crypt := sha256.New()
+	crypt.Write(password)
+	stage1 := crypt.Sum(nil)
+
+	crypt.Reset()
+	crypt.Write(stage1)
+	stage2 := crypt.Sum(nil)
+
+	crypt.Reset()
+	crypt.Write(stage2)
+	crypt.Write(scramble)
+	hash := crypt.Sum(nil)
+
+	for i := range hash {
+		hash[i] ^= stage1[i]
+	}
+	return hash
+}
+
+// HashPassword returns the password hashed as mysql_native_password stores
+// it, * and the hex of SHA1(SHA1(password)), e.g. the output of `dbatman
+// passwd`. The hash instead of the password can be set in the config.
+func HashPassword(password string) string {
+	if len(password) == 0 {
+		return ""
+	}
+
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	return "*" + strings.ToUpper(hex.EncodeToString(stage2[:]))
+}
+
+// parseHashedPassword returns SHA1(SHA1(password)) of the password hashed by
+// HashPassword, false if the password is not hashed
+func parseHashedPassword(password string) ([]byte, bool) {
+	if len(password) != 1+2*sha1.Size || password[0] != '*' {
+		return nil, false
+	}
+
+	stage2, err := hex.DecodeString(password[1:])
+	if err != nil {
+		return nil, false
+	}
+	return stage2, true
+}
+
+// checkNativePassword checks the scramble of mysql_native_password against
+// SHA1(SHA1(password)), the scramble is
+// SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password)))
+func checkNativePassword(salt, auth, stage2 []byte) bool {
+	if len(auth) != sha1.Size {
+		return false
+	}
+
+	crypt := sha1.New()
+	crypt.Write(salt)
+	crypt.Write(stage2)
+	stage1 := crypt.Sum(nil)
+	for i := range stage1 {
+		stage1[i] ^= auth[i]
+	}
+
+	hash := sha1.Sum(stage1)
+	return bytes.Equal(hash[:], stage2)
+}
+
+var errPasswordMismatch = errors.New("password mismatch")
+
+// checkPassword checks the auth data sent by the client against the
+// password, by the plugin the auth data is computed. The password may be
+// hashed by HashPassword, the caching_sha2_password clients are asked to
+// send the password by ErrFullAuthRequired then.
+func checkPassword(plugin string, salt, auth []byte, password string) error {
+	stage2, hashed := parseHashedPassword(password)
+
+	var ok bool
+	switch plugin {
+	case AuthCachingSha2Password:
+		if hashed {
+			return ErrFullAuthRequired
+		}
+		ok = bytes.Equal(auth, CalcCachingSha2Password(salt, []byte(password)))
+	case AuthClearPassword:
+		if hashed {
+			stage1 := sha1.Sum(auth)
+			hash := sha1.Sum(stage1[:])
+			ok = bytes.Equal(hash[:], stage2)
+		} else {
+			ok = string(auth) == password
+		}
+	default:
+		if hashed {
+			ok = checkNativePassword(salt, auth, stage2)
+		} else {
+			ok = bytes.Equal(auth, CalcPassword(salt, []byte(password)))
+		}
+	}
+
+	if !ok {
+		return errPasswordMismatch
+	}
+	return nil
+}
+
 func (session *Session) CheckAuth(username string, passwd []byte, db string) error {
 
 	var err error
 	//check the global authip
-	gc, err := session.config.GetGlobalConfig()
+	rules := session.server.ipRules(session.config)
 	cliAddr := session.cliAddr
-	if gc.AuthIPActive == true {
-		if len(gc.AuthIPs) > 0 {
-			//TODO white and black ip logic
-			globalAuthIp := &gc.AuthIPs
-			authIpFlag := false
-			for _, ip := range *globalAuthIp {
-				if ip == cliAddr {
-					authIpFlag = true
-					break
-				}
-			}
-
-			if authIpFlag != true {
-				// log.Info("This user's Ip is not in the list of User's auth_Ip")
-				return NewDefaultError(ER_NO, "IP Is not in the auth_ip list of the global config")
-			}
-
-		}
+	if err := rules.checkGlobal(cliAddr); err != nil {
+		return err
 	}
 	//global auth pass
 	// There is no user named with parameter username
 	if session.user, err = session.config.GetUserByName(username); err != nil {
 		if session.user == nil {
-			return NewDefaultError(ER_ACCESS_DENIED_ERROR, username, session.fc.RemoteAddr().String(), "Yes")
+			return NewDefaultError(ER_ACCESS_DENIED_ERROR, username, cliAddr, "Yes")
 		}
-		return NewDefaultError(ER_ACCESS_DENIED_ERROR, session.user.Username, session.fc.RemoteAddr().String(), "Yes")
+		return NewDefaultError(ER_ACCESS_DENIED_ERROR, session.user.Username, cliAddr, "Yes")
 	}
 
 	if db != "" && session.user.DBName != db {
 		log.Debugf("request db: %s, user's db: %s", db, session.user.DBName)
 		return NewDefaultError(ER_BAD_DB_ERROR, db)
 	}
-	//TODO add the IP auth module to check the global auth_ip
-	//check user config auth_IP with current Session Ip
-	if gc.AuthIPActive == true {
-		authIpFlag := false
-		if len(session.user.AuthIPs) > 0 {
-			userIPs := &session.user.AuthIPs
-
-			// log.Debug("client IP : ", session.cliAddr)
-			// log.Debug("User's Auth IP is: ", userIPs)
-			for _, ip := range *userIPs {
-				if cliAddr == ip {
-					authIpFlag = true
-					break
-				}
-			}
-		}
-		if authIpFlag != true {
-			log.Debug("This user's Ip is not in the list of User's auth_Ip")
-
-			return NewDefaultError(ER_NO, "IP Is not in the auth_ip list of the user")
+	//check the auth_ips and black_list_ips of the user with current Session Ip
+	if err := rules.checkUser(username, cliAddr); err != nil {
+		log.Debugf("user %s from %s rejected by the IP rules", username, cliAddr)
+		return err
+	}
+	if err := checkPassword(session.fc.AuthPlugin(), session.salt, passwd, session.user.Password); err != nil {
+		if err == ErrFullAuthRequired {
+			return err
 		}
-
+		return NewDefaultError(ER_ACCESS_DENIED_ERROR, session.user.Username, cliAddr, "Yes")
 	}
-	if !bytes.Equal(passwd, CalcPassword(session.salt, []byte(session.user.Password))) {
-		return NewDefaultError(ER_ACCESS_DENIED_ERROR, session.user.Username, session.fc.RemoteAddr().String(), "Yes")
+	// the user counted before COM_CHANGE_USER, if any
+	counted, countedUser := session.counted, session.countedUser
+	if err := session.server.acquireConn(session); err != nil {
+		return err
 	}
 	if err := session.useDB(session.user.DBName); err != nil {
+		if counted {
+			session.server.restoreConn(session, countedUser)
+		}
 		return err
 	}
 
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"testing"
+
+	. "github.com/bytedance/dbatman/database/mysql"
+)
+
+func TestHashPassword(t *testing.T) {
+	// SELECT PASSWORD('password') of MySQL 5.x
+	if hash := HashPassword("password"); hash != "*2470C0C06DEE42FD1618BB99005ADCA2EC9D1E19" {
+		t.Fatalf("expect the hash of MySQL, got %s", hash)
+	}
+	if hash := HashPassword(""); hash != "" {
+		t.Fatalf("expect no hash of the empty password, got %s", hash)
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	salt := []byte("01234567890123456789")
+	hash := HashPassword("secret")
+
+	for i, test := range []struct {
+		plugin   string
+		auth     []byte
+		password string
+		err      error
+	}{
+		{AuthNativePassword, CalcPassword(salt, []byte("secret")), "secret", nil},
+		{AuthNativePassword, CalcPassword(salt, []byte("secret")), hash, nil},
+		// the hash in lower case
+		{AuthNativePassword, CalcPassword(salt, []byte("secret")), "*14e65567abdb5135d0cfd9a70b3032c179a49ee7", nil},
+		{AuthNativePassword, CalcPassword(salt, []byte("wrong")), hash, errPasswordMismatch},
+		{AuthNativePassword, nil, hash, errPasswordMismatch},
+		{AuthNativePassword, nil, "", nil},
+		{AuthCachingSha2Password, CalcCachingSha2Password(salt, []byte("secret")), "secret", nil},
+		{AuthCachingSha2Password, CalcCachingSha2Password(salt, []byte("secret")), hash, ErrFullAuthRequired},
+		{AuthClearPassword, []byte("secret"), hash, nil},
+		{AuthClearPassword, []byte("wrong"), hash, errPasswordMismatch},
+		{AuthClearPassword, []byte("secret"), "secret", nil},
+	} {
+		if err := checkPassword(test.plugin, salt, test.auth, test.password); err != test.err {
+			t.Fatalf("%d: expect %v, got %v", i, test.err, err)
+		}
+	}
+}
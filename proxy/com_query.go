@@ -13,21 +13,21 @@ import (
 	"github.com/percona/go-mysql/query"
 )
 
-//we just go the microsecond timestamp
-func getTimestamp() int64 {
-	return time.Now().UnixNano() / int64(time.Millisecond)
-}
-
 func (c *Session) comQuery(sqlstmt string) error {
+	if c.fc.Flags()&uint32(ClientMultiStatements) != 0 && strings.Contains(sqlstmt, ";") {
+		return c.comMultiQuery(sqlstmt)
+	}
 
-	//TODO accerlate the flow control module and the figerprint module
-	// err := c.intercept(sqlstmt)
-	// if err != nil {
-	// return err
-	// }
-	// c.updatefp(sqlstmt)
 	log.Infof("session %d: %s", c.sessionId, sqlstmt)
 	sqlFp := query.Fingerprint(sqlstmt)
+	if err := c.flowControl(sqlFp); err != nil {
+		return c.handleMySQLError(err)
+	}
+
+	typ := "unknown"
+	defer func(start time.Time) {
+		observeQuery(typ, start)
+	}(time.Now())
 
 	stmt, err := c.getParserStmt(sqlFp, sqlstmt)
 	if err != nil {
@@ -35,6 +35,79 @@ func (c *Session) comQuery(sqlstmt string) error {
 		return c.handleMySQLError(
 			NewDefaultError(ER_SYNTAX_ERROR, err.Error()))
 	}
+
+	typ = stmtType(stmt)
+	if err := c.checkFirewall(stmt, sqlFp, sqlstmt); err != nil {
+		return c.handleMySQLError(err)
+	}
+	if err := c.checkSafeUpdates(stmt, sqlstmt); err != nil {
+		return c.handleMySQLError(err)
+	}
+	return c.handleStmt(stmt, sqlstmt)
+}
+
+// comMultiQuery runs the statements of a multi-statement query one by one,
+// each one is routed by itself like a single query. The results are chained
+// by SERVER_MORE_RESULTS_EXISTS, and the statements after a failed one are
+// not run, like MySQL does.
+func (c *Session) comMultiQuery(sqlstmt string) error {
+	defer c.fc.SetMoreResults(false)
+
+	stmt, text, rest, err := parser.ParseMulti(sqlstmt)
+	for {
+		if err != nil {
+			log.Warningf(`parse sql "%s" error "%s"`, sqlstmt, err.Error())
+			return c.handleMySQLError(
+				NewDefaultError(ER_SYNTAX_ERROR, err.Error()))
+		}
+
+		// parse the next statement ahead to tell if more results follow,
+		// the comments at the end are not a statement
+		var next parser.IStatement
+		var nextText, nextRest string
+		var nextErr error
+		if len(rest) > 0 {
+			next, nextText, nextRest, nextErr = parser.ParseMulti(rest)
+		}
+		more := next != nil || nextErr != nil
+
+		c.fc.SetMoreResults(more)
+		c.stmtFailed = false
+		if err := c.comQueryStmt(stmt, text); err != nil {
+			return err
+		}
+		if !more || c.stmtFailed {
+			return nil
+		}
+
+		sqlstmt = rest
+		stmt, text, rest, err = next, nextText, nextRest, nextErr
+	}
+}
+
+// comQueryStmt runs a statement of a multi-statement query
+func (c *Session) comQueryStmt(stmt parser.IStatement, sqlstmt string) error {
+	log.Infof("session %d: %s", c.sessionId, sqlstmt)
+	sqlFp := query.Fingerprint(sqlstmt)
+	if err := c.flowControl(sqlFp); err != nil {
+		return c.handleMySQLError(err)
+	}
+
+	defer func(typ string, start time.Time) {
+		observeQuery(typ, start)
+	}(stmtType(stmt), time.Now())
+
+	if err := c.checkFirewall(stmt, sqlFp, sqlstmt); err != nil {
+		return c.handleMySQLError(err)
+	}
+	if err := c.checkSafeUpdates(stmt, sqlstmt); err != nil {
+		return c.handleMySQLError(err)
+	}
+
+	return c.handleStmt(stmt, sqlstmt)
+}
+
+func (c *Session) handleStmt(stmt parser.IStatement, sqlstmt string) error {
 	switch v := stmt.(type) {
 	case parser.ISelect:
 		return c.handleQuery(v, sqlstmt)
@@ -56,16 +129,20 @@ func (c *Session) comQuery(sqlstmt string) error {
 		return c.handleShow(sqlstmt, v)
 	case parser.IDDLStatement:
 		return c.handleDDL(v, sqlstmt)
-	case *parser.Do, *parser.Call, *parser.FlushTables:
+	case *parser.Call:
+		return c.handleCall(v, sqlstmt)
+	case *parser.Do, *parser.FlushTables:
 		return c.handleExec(stmt, sqlstmt, false)
 		//add the describe table module
 	case *parser.DescribeTable, *parser.DescribeStmt:
 		return c.handleQuery(v, sqlstmt)
 	case *parser.Use:
 
-		if err := c.useDB(hack.String(stmt.(*parser.Use).DB)); err != nil {
+		db := hack.String(stmt.(*parser.Use).DB)
+		if err := c.useDB(db); err != nil {
 			return c.handleMySQLError(err)
 		} else {
+			c.trackSchema(proceDbName(db))
 			return c.fc.WriteOK(nil)
 		}
 	case *parser.SavePoint:
@@ -83,12 +160,15 @@ func (c *Session) comQuery(sqlstmt string) error {
 
 	return nil
 }
+
 func (c *Session) getParserStmt(sqlPrintFinger string, sqlstmt string) (parser.IStatement, error) {
 	var err error
 	var stmt parser.IStatement
 	var ok bool
-	if strings.HasPrefix(sqlPrintFinger, "select") || strings.HasPrefix(sqlPrintFinger, "update") ||
-		strings.HasPrefix(sqlPrintFinger, "insert") {
+	// the fingerprint drops the comments, statements with hints can not
+	// share the ast with others
+	if !strings.Contains(sqlstmt, "/*") && (strings.HasPrefix(sqlPrintFinger, "select") ||
+		strings.HasPrefix(sqlPrintFinger, "update") || strings.HasPrefix(sqlPrintFinger, "insert")) {
 		stmt, ok = c.sqlParserAst[sqlPrintFinger]
 		if !ok {
 			// fmt.Printf("%s ast doesn;t  in the seesion's cache ,execute parser\n", sqlPrintFinger)
@@ -124,7 +204,12 @@ func (session *Session) handleExec(stmt parser.IStatement, sqlstmt string, isrea
 		return session.handleMySQLError(err)
 	}
 
-	return session.exec(sqlstmt, isread)
+	rs, err := session.HintExecutor(stmt, isread).Exec(sqlstmt)
+	if err != nil {
+		return session.handleMySQLError(err)
+	}
+
+	return session.fc.WriteOK(rs)
 }
 
 // handleDDL process DDL Statements where
@@ -159,87 +244,62 @@ func (session *Session) checkDB(stmt parser.IStatement) error {
 	return nil
 }
 
-func (session *Session) exec(sqlstmt string, isread bool) error {
+// handleCall relays the results of the stored procedure, a result set for
+// each SELECT in the procedure chained by SERVER_MORE_RESULTS_EXISTS, and the
+// status of the CALL at last.
+func (session *Session) handleCall(stmt *parser.Call, sqlstmt string) error {
+	if err := session.checkDB(stmt); err != nil {
+		return session.handleMySQLError(err)
+	}
 
-	rs, err := session.Executor(isread).Exec(sqlstmt)
+	rows, err := session.HintExecutor(stmt, false).Query(sqlstmt)
 	if err != nil {
 		return session.handleMySQLError(err)
 	}
+	defer rows.Close()
 
-	return session.fc.WriteOK(rs)
-}
+	// the result sets are always followed by the status
+	more := session.fc.MoreResults()
+	defer session.fc.SetMoreResults(more)
 
-func (c *Session) intercept(sqlstmt string) error {
-	var excess int64
-	now := getTimestamp()
-	c.server.mu.Lock()
-	if qpsOnServer := c.server.qpsOnServer; qpsOnServer != nil {
-
-		//how many microsecond elapsed since last query
-		ms := now - qpsOnServer.last
-		//TODO modify here
-		//Default, we have 1 r/s and  *1000 add the switch to ms 1000 means 1 req per ms
-		excess = qpsOnServer.excess - (c.config.Global.ReqRate*1000*ms)/1000 + 1000
-		// if excess < 0 {
-		// excess = 0
-		// }
-		// log.Info("current qps excess is : ", excess)
-		//If we need caculate every second speed,
-		//Shouldn't reset to zero;
-
-		//the race out the max Burst?
-		if excess > c.config.Global.ReqBurst {
-			//Just close the client or
-			err := fmt.Errorf(`the query excess(%d) over the reqBurst(%d), sql: %s "`, excess, c.config.Global.ReqBurst, sqlstmt)
-			log.Warn(err)
-			//TODO: more gracefully add a Timer and retry?
+	for {
+		if rs := rows.Result(); rs != nil {
+			session.fc.SetMoreResults(more)
+			return session.fc.WriteOK(rs)
 		}
-		qpsOnServer.excess = excess
-		qpsOnServer.last = now
-		qpsOnServer.count++
-	} else {
-		qpsOnServer := &LimitReqNode{}
-		qpsOnServer.count = 1
-		qpsOnServer.currentcount = 1
-		qpsOnServer.last = now
-		qpsOnServer.excess = 0
-		c.server.qpsOnServer = qpsOnServer
-
-	}
-
-	c.server.mu.Unlock()
-	return nil
-}
 
-//only collect the qps of the Fingerprint on server
-func (c *Session) updatefp(sqlstmt string) {
-	now := getTimestamp()
-	//*necessary to lock the server
-
-	fp := query.Fingerprint(sqlstmt)
+		if session.fc.Flags()&uint32(ClientMultiResults) == 0 {
+			// the client could not read the results after the first one
+			var name string
+			if stmt.Spname != nil {
+				name = string(stmt.Spname.Name)
+			}
+			return session.handleMySQLError(NewDefaultError(ER_SP_BADSELECT, name))
+		}
 
-	c.server.mu.Lock()
+		session.fc.SetMoreResults(true)
+		if err := writeResultset(session.fc, rows); err != nil {
+			return session.handleMySQLError(err)
+		}
 
-	if lr, ok := c.server.fingerprints[fp]; ok {
-		//how many microsecond elapsed since last query
-		interval := now - lr.start
+		if !rows.NextResultSet() {
+			if err := rows.Err(); err != nil {
+				return session.handleMySQLError(err)
+			}
 
-		if interval < 1000 {
-			lr.currentcount++
-		} else {
-			lr.lastcount = lr.currentcount
-			lr.start = lr.start + interval/1000*1000
-			lr.currentcount = 1
+			// the status is missing, end the results anyway
+			session.fc.SetMoreResults(more)
+			return session.fc.WriteOK(nil)
 		}
-		lr.count++ //total num of printfinger
+	}
+}
 
-	} else {
-		lr := &LimitReqNode{}
-		lr.start = now
-		lr.lastcount = 0
-		lr.currentcount = 1
-		lr.count = 1
-		c.server.fingerprints[fp] = lr
+func (session *Session) exec(sqlstmt string, isread bool) error {
+
+	rs, err := session.Executor(isread).Exec(sqlstmt)
+	if err != nil {
+		return session.handleMySQLError(err)
 	}
-	c.server.mu.Unlock()
+
+	return session.fc.WriteOK(rs)
 }
@@ -2,6 +2,9 @@ package proxy
 
 import (
 	"testing"
+	"time"
+
+	"github.com/bytedance/dbatman/database/mysql"
 )
 
 func TestProxy_Query(t *testing.T) {
@@ -148,3 +151,216 @@ func TestProxy_Use(t *testing.T) {
 		t.Fatalf("use mysql for this user expect deny, got pass")
 	}
 }
+
+func TestProxy_QueryHints(t *testing.T) {
+
+	db := newSqlDB(testProxyDSN)
+	defer db.Close()
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS go_proxy_test_hints (
+			id BIGINT(64) UNSIGNED NOT NULL,
+			PRIMARY KEY (id)
+		) ENGINE=InnoDB DEFAULT CHARSET=utf8`); err != nil {
+		t.Fatal("create table failed: ", err)
+	}
+	defer db.Exec("DROP TABLE IF EXISTS go_proxy_test_hints")
+
+	if _, err := db.Exec(`insert into go_proxy_test_hints (id) values(1)`); err != nil {
+		t.Fatal("insert failed: ", err)
+	}
+
+	// read your writes from the master without a transaction
+	var count int
+	if err := db.QueryRow(`select /*+ master */ count(*) from go_proxy_test_hints where id = 1`).Scan(&count); err != nil {
+		t.Fatal("select failed: ", err)
+	} else if count != 1 {
+		t.Fatalf("expect 1 row from the master, got %d", count)
+	}
+
+	// sleep returns 1 if it is killed
+	start := time.Now()
+	var ret int
+	if err := db.QueryRow(`select /* dbatman: timeout=100 */ sleep(3)`).Scan(&ret); err != nil {
+		t.Fatal("select failed: ", err)
+	} else if ret != 1 || time.Since(start) > 2*time.Second {
+		t.Fatalf("expect the query killed after 100ms, got %d in %s", ret, time.Since(start))
+	}
+}
+
+func TestProxy_SessionVars(t *testing.T) {
+
+	db := newSqlDB(testProxyDSN)
+	defer db.Close()
+	// all the statements run in one session
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`set time_zone = '+08:00', @uid = 42`); err != nil {
+		t.Fatal("set failed: ", err)
+	}
+	if _, err := db.Exec(`set @uid = @uid + 1`); err != nil {
+		t.Fatal("set failed: ", err)
+	}
+
+	// the variables follow the session to the backend connections
+	for i := 0; i < 10; i++ {
+		var tz string
+		var uid int
+		if err := db.QueryRow(`select @@time_zone, @uid`).Scan(&tz, &uid); err != nil {
+			t.Fatal("select failed: ", err)
+		} else if tz != "+08:00" || uid != 43 {
+			t.Fatalf("expect +08:00 and 43, got %s and %d", tz, uid)
+		}
+	}
+
+	// but not to the other sessions
+	other := newSqlDB(testProxyDSN)
+	defer other.Close()
+
+	for i := 0; i < 10; i++ {
+		var tz string
+		var uid mysql.NullInt64
+		if err := other.QueryRow(`select @@time_zone, @uid`).Scan(&tz, &uid); err != nil {
+			t.Fatal("select failed: ", err)
+		} else if tz == "+08:00" || uid.Valid {
+			t.Fatalf("expect the variables of another session reset, got %s and %v", tz, uid)
+		}
+	}
+}
+
+func TestProxy_SetNames(t *testing.T) {
+
+	db := newSqlDB(testProxyDSN)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(`set names utf8mb4 collate utf8mb4_unicode_ci`); err != nil {
+		t.Fatal("set names failed: ", err)
+	}
+
+	// every backend connection is brought to the charset
+	for i := 0; i < 10; i++ {
+		var client, results, collation string
+		if err := db.QueryRow(`select @@character_set_client, @@character_set_results, @@collation_connection`).Scan(&client, &results, &collation); err != nil {
+			t.Fatal("select failed: ", err)
+		} else if client != "utf8mb4" || results != "utf8mb4" || collation != "utf8mb4_unicode_ci" {
+			t.Fatalf("expect utf8mb4, got %s, %s and %s", client, results, collation)
+		}
+	}
+
+	if _, err := db.Exec(`set names no_such_charset`); err == nil {
+		t.Fatal("expect an unknown charset error")
+	}
+}
+
+func TestProxy_MultiStatements(t *testing.T) {
+
+	db := newSqlDB(testProxyDSN + "?multiStatements=true")
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	rows, err := db.Query(`select 1; select 2 ; `)
+	if err != nil {
+		t.Fatal("multi statements failed: ", err)
+	}
+	defer rows.Close()
+
+	for i := 1; i <= 2; i++ {
+		if i > 1 && !rows.NextResultSet() {
+			t.Fatalf("expect result %d: %v", i, rows.Err())
+		}
+
+		var n int
+		if !rows.Next() {
+			t.Fatalf("expect a row of result %d: %v", i, rows.Err())
+		}
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		} else if n != i {
+			t.Fatalf("expect %d, got %d", i, n)
+		}
+		if rows.Next() {
+			t.Fatalf("expect one row of result %d", i)
+		}
+	}
+	if rows.NextResultSet() {
+		t.Fatal("expect no more results")
+	}
+
+	// the statements after a failed one are not run
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS dbatman_test_multi (id int primary key);
+		DELETE FROM dbatman_test_multi`); err != nil {
+		t.Fatal("create table failed: ", err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS dbatman_test_multi`)
+
+	if _, err := db.Exec(`insert into dbatman_test_multi values (1);
+		insert into dbatman_test_multi values (1);
+		insert into dbatman_test_multi values (2)`); err == nil {
+		t.Fatal("expect duplicate entry")
+	}
+
+	var count int
+	if err := db.QueryRow(`select count(*) from dbatman_test_multi`).Scan(&count); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Fatalf("expect 1 row inserted, got %d", count)
+	}
+}
+
+func TestProxy_CallMultiResults(t *testing.T) {
+
+	db := newSqlDB(testProxyDSN)
+	defer db.Close()
+
+	if _, err := db.Exec(`DROP PROCEDURE IF EXISTS dbatman_test_results`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`CREATE PROCEDURE dbatman_test_results()
+		BEGIN
+			SELECT 1;
+			SELECT 2, 3;
+		END`); err != nil {
+		t.Fatal("create procedure failed: ", err)
+	}
+	defer db.Exec(`DROP PROCEDURE IF EXISTS dbatman_test_results`)
+
+	rows, err := db.Query(`CALL dbatman_test_results()`)
+	if err != nil {
+		t.Fatal("call failed: ", err)
+	}
+	defer rows.Close()
+
+	for i, expect := range []int{1, 2} {
+		if i > 0 && !rows.NextResultSet() {
+			t.Fatalf("expect result set %d: %v", i+1, rows.Err())
+		}
+
+		cols, err := rows.Columns()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(cols) != expect {
+			t.Fatalf("expect %d columns, got %d", expect, len(cols))
+		}
+
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		if n != 1 {
+			t.Fatalf("expect 1 row, got %d", n)
+		}
+	}
+
+	// the status of the CALL at last
+	if !rows.NextResultSet() {
+		t.Fatalf("expect the status of the call: %v", rows.Err())
+	}
+	if rows.Result() == nil {
+		t.Fatal("expect the OK packet")
+	}
+	if rows.NextResultSet() {
+		t.Fatal("expect no more results")
+	}
+}
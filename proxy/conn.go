@@ -4,6 +4,7 @@ import (
 	"errors"
 
 	"github.com/bytedance/dbatman/database/mysql"
+	"github.com/ngaut/log"
 )
 
 // Wrap the connection
@@ -13,6 +14,10 @@ type SqlConn struct {
 	stmts  map[uint32]*mysql.Stmt
 	tx     *mysql.Tx
 
+	// the cursors opened by the prepared statements, each one holds a
+	// backend connection until it is closed
+	cursors map[uint32]*mysql.Cursor
+
 	session *Session
 }
 
@@ -22,7 +27,7 @@ func (bc *SqlConn) begin(s *Session) error {
 	}
 
 	var err error
-	bc.tx, err = bc.master.Begin()
+	bc.tx, err = s.master().WithVars(s.vars).Begin()
 	if err != nil {
 		return err
 	}
@@ -41,6 +46,11 @@ func (bc *SqlConn) commit(inAutoCommit bool) error {
 		}
 	}()
 
+	if inAutoCommit {
+		// the connection of the transaction is put back to the pool
+		bc.closeCursors()
+	}
+
 	if err := bc.tx.Commit(inAutoCommit); err != nil {
 		// fmt.Println("commit err :", err)
 		return err
@@ -60,6 +70,10 @@ func (bc *SqlConn) rollback(inAutoCommit bool) error {
 		}
 	}()
 
+	if inAutoCommit {
+		bc.closeCursors()
+	}
+
 	if err := bc.tx.Rollback(inAutoCommit); err != nil {
 		return err
 	}
@@ -67,6 +81,22 @@ func (bc *SqlConn) rollback(inAutoCommit bool) error {
 	return nil
 }
 
+// closeCursor closes the cursor of the prepared statement if it is opened
+func (bc *SqlConn) closeCursor(id uint32) {
+	if cursor, ok := bc.cursors[id]; ok {
+		if err := cursor.Close(); err != nil {
+			log.Warnf("session %d close cursor of stmt %d error: %s", bc.session.sessionId, id, err.Error())
+		}
+		delete(bc.cursors, id)
+	}
+}
+
+func (bc *SqlConn) closeCursors() {
+	for id := range bc.cursors {
+		bc.closeCursor(id)
+	}
+}
+
 func (session *Session) Executor(isread bool) mysql.Executor {
 
 	// TODO set autocommit
@@ -75,8 +105,28 @@ func (session *Session) Executor(isread bool) mysql.Executor {
 	}
 
 	if isread {
-		return session.bc.slave
+		return session.slave().WithVars(session.vars)
 	}
 
+	return session.master().WithVars(session.vars)
+}
+
+// master returns the master of the session, it follows the master of the
+// cluster which could be replaced by failover
+func (session *Session) master() *mysql.DB {
+	if db, err := session.cluster.Master(); err == nil {
+		session.bc.master = db
+	}
 	return session.bc.master
 }
+
+// slave returns the slave serving a read of the session out of the
+// transactions, it is picked by the balancer of the cluster for each read so
+// the weights changed apply to the sessions open at once. The last one
+// picked is kept if no slave could be picked.
+func (session *Session) slave() *mysql.DB {
+	if db, err := session.cluster.Slave(); err == nil {
+		session.bc.slave = db
+	}
+	return session.bc.slave
+}
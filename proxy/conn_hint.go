@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/parser"
+	"github.com/ngaut/log"
+)
+
+// HintExecutor returns the executor of the statement with the routing hints
+// in its comments applied:
+//
+//   master          read from the master, e.g. read your writes
+//   slave           read from a slave even if the select is locked
+//   node=<addr>     read from the node at host:port, or the addr of a dsn
+//   timeout=<ms>    kill the query if it runs longer than the timeout
+//
+// Writes always go to the master, and the hints are ignored in a
+// transaction. Invalid hints are logged and ignored like MySQL does.
+func (session *Session) HintExecutor(stmt parser.IStatement, isread bool) mysql.Executor {
+	hinted, ok := stmt.(parser.IHintedStatement)
+	if !ok || len(hinted.GetHints()) == 0 || session.isInTransaction() {
+		return session.Executor(isread)
+	}
+
+	_, isSelect := stmt.(parser.ISelect)
+
+	var node *mysql.DB
+	var timeout time.Duration
+	for _, h := range hinted.GetHints() {
+		switch h.Name {
+		case "master":
+			isread = false
+		case "slave":
+			if isSelect {
+				isread = true
+			}
+		case "node":
+			if !isSelect {
+				continue
+			}
+
+			db, err := session.cluster.Node(hintNodeAddr(h.Value))
+			if err != nil {
+				log.Warnf("session %d: ignore hint node=%s: %s", session.sessionId, h.Value, err.Error())
+				continue
+			}
+			node = db
+		case "timeout":
+			ms, err := strconv.ParseInt(h.Value, 10, 64)
+			if err != nil || ms <= 0 {
+				log.Warnf("session %d: ignore invalid hint timeout=%s", session.sessionId, h.Value)
+				continue
+			}
+			timeout = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	db := session.master()
+	if node != nil {
+		db = node
+	} else if isread {
+		db = session.slave()
+	}
+
+	return db.WithVars(session.vars).WithTimeout(timeout)
+}
+
+// hintNodeAddr returns the host:port of the node hint, which could be an
+// addr or a dsn
+func hintNodeAddr(value string) string {
+	if strings.Contains(value, "@") {
+		if cfg, err := mysql.ParseDSN(value); err == nil {
+			return cfg.Addr
+		}
+	}
+	return value
+}
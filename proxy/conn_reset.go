@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"errors"
+
+	. "github.com/bytedance/dbatman/database/mysql"
+	"github.com/ngaut/log"
+)
+
+var errChangeUser = errors.New("change user failed")
+
+// resetSession rolls back the transaction, closes the prepared statements and
+// resets the session to the state right after connected, it is used by
+// COM_CHANGE_USER and COM_RESET_CONNECTION from the connection pools.
+func (session *Session) resetSession() {
+	if session.bc != nil {
+		if session.bc.tx != nil {
+			// roll back before autocommit is turned on, which commits the
+			// transaction open
+			inAutoCommit := session.isAutoCommit()
+			if err := session.bc.rollback(inAutoCommit); err != nil {
+				log.Warnf("session %d rollback error: %s", session.sessionId, err.Error())
+			}
+
+			// the backend connection is put back to the pool in autocommit
+			if !inAutoCommit {
+				if _, err := session.bc.tx.Exec("set autocommit = 1"); err != nil {
+					// don't put the connection back
+					log.Warnf("session %d reset autocommit error: %s", session.sessionId, err.Error())
+					session.bc.tx = nil
+				} else if err := session.bc.rollback(true); err != nil {
+					log.Warnf("session %d rollback error: %s", session.sessionId, err.Error())
+				}
+			}
+		}
+
+		session.bc.closeCursors()
+		for id, stmt := range session.bc.stmts {
+			stmt.Close()
+			delete(session.bc.stmts, id)
+		}
+	}
+
+	session.fc.AndStatus(^uint16(StatusInTrans))
+	session.fc.XORStatus(uint16(StatusInAutocommit))
+	session.autoCommit = 0
+	session.txIsolationInDef = true
+	session.txIsolationStmt = ""
+	session.fc.SetCollation(DEFAULT_COLLATION_ID)
+	session.vars.Reset()
+	session.bindCharset()
+}
+
+// handleChangeUser authenticates the user again with a fresh salt, and binds
+// the session to the cluster of the user. The connection is closed if the
+// authentication fails, like MySQL does, the old user stays bound until then.
+func (session *Session) handleChangeUser(data []byte) error {
+	// the transaction, cursors and prepared statements holding the backend
+	// connections are closed, bc keeps nothing but the nodes then
+	session.resetSession()
+
+	user, cluster, bc := session.user, session.cluster, session.bc
+	session.salt, _ = RandomBuf(20)
+	// bound to the ones of the new user by the authentication
+	session.cluster = nil
+	session.bc = nil
+
+	if err := session.fc.ChangeUser(data); err != nil {
+		session.user, session.cluster, session.bc = user, cluster, bc
+		log.Warnf("session %d change user error: %s", session.sessionId, err.Error())
+		if e, ok := err.(*MySQLError); ok {
+			session.fc.WriteError(e)
+			return errChangeUser
+		}
+		return err
+	}
+
+	// the charset of COM_CHANGE_USER
+	session.bindCharset()
+
+	log.Infof("session %d change user to %s", session.sessionId, session.user.Username)
+	return session.fc.WriteOK(nil)
+}
+
+func (session *Session) handleResetConnection() error {
+	session.resetSession()
+	return session.fc.WriteOK(nil)
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/bytedance/dbatman/config"
+	"github.com/bytedance/dbatman/database/mysql"
+)
+
+// TestSession_ChangeUserFailed keeps the old user bound to the session if
+// the authentication of COM_CHANGE_USER fails, it is released on close.
+func TestSession_ChangeUserFailed(t *testing.T) {
+	s := &Server{mu: &sync.Mutex{}, userConns: make(map[string]int)}
+	old := &config.UserConfig{Username: "old", Password: "old"}
+	cfg := &config.ProxyConfig{
+		Global: &config.GlobalConfig{},
+		Users: map[string]*config.UserConfig{
+			"old": old,
+			"new": {Username: "new", Password: "new"},
+		},
+	}
+
+	server, client := net.Pipe()
+	defer client.Close()
+
+	session := &Session{server: s, config: cfg, user: old, vars: mysql.NewSessionVars()}
+	session.fc = mysql.NewMySQLServerConn(session, server)
+	bc := &SqlConn{session: session}
+	session.bc = bc
+	if err := s.acquireConn(session); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client answers the auth switch request with a wrong password
+	go func() {
+		header := make([]byte, 4)
+		readPacket := func() error {
+			if _, err := io.ReadFull(client, header); err != nil {
+				return err
+			}
+			data := make([]byte, int(header[0])|int(header[1])<<8|int(header[2])<<16)
+			_, err := io.ReadFull(client, data)
+			return err
+		}
+
+		if err := readPacket(); err != nil {
+			return
+		}
+		auth := CalcPassword(session.Salt(), []byte("wrong"))
+		client.Write(append([]byte{byte(len(auth)), 0, 0, header[3] + 1}, auth...))
+		for readPacket() == nil {
+		}
+	}()
+
+	// user, auth with the old salt, db
+	if err := session.handleChangeUser([]byte("new\x00\x00\x00")); err != errChangeUser {
+		t.Fatalf("expect change user failed, got %v", err)
+	}
+	session.fc.Flush()
+	server.Close()
+
+	if session.user != old || session.bc != bc {
+		t.Fatalf("expect the old user and backends kept, got %v", session.user)
+	}
+	if s.Connections() != 1 || s.UserConnections("old") != 1 || s.UserConnections("new") != 0 {
+		t.Fatalf("expect the session counted for the old user, got %d, %d and %d",
+			s.Connections(), s.UserConnections("old"), s.UserConnections("new"))
+	}
+
+	s.releaseConn(session)
+	if s.Connections() != 0 || s.UserConnections("old") != 0 {
+		t.Fatalf("expect the session released, got %d connections", s.Connections())
+	}
+}
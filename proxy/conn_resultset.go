@@ -2,10 +2,47 @@ package proxy
 
 import (
 	"fmt"
-	"github.com/bytedance/dbatman/hack"
 	"strconv"
+
+	"github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/hack"
 )
 
+// buildResultset build a text resultset whose columns are all strings, each
+// row of values must have the same length as names.
+func buildResultset(collation mysql.CollationId, names []string, values [][]interface{}) (mysql.Rows, error) {
+	r := new(SimpleRows)
+
+	r.Cols = make([]*mysql.MySQLField, len(names))
+	for i, name := range names {
+		r.Cols[i] = &mysql.MySQLField{
+			Name:      hack.Slice(name),
+			Charset:   uint16(collation),
+			FieldType: mysql.FieldTypeVarString,
+		}
+	}
+
+	for i, vs := range values {
+		if len(vs) != len(names) {
+			return nil, fmt.Errorf("row %d has %d column not equal %d", i, len(vs), len(names))
+		}
+
+		var row []byte
+		for _, value := range vs {
+			b, err := formatValue(value)
+			if err != nil {
+				return nil, err
+			}
+
+			row = mysql.AppendLengthEncodedString(row, b)
+		}
+
+		r.Rows = append(r.Rows, row)
+	}
+
+	return r, nil
+}
+
 func formatValue(value interface{}) ([]byte, error) {
 	switch v := value.(type) {
 	case int8:
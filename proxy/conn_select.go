@@ -20,7 +20,7 @@ func (session *Session) handleQuery(stmt parser.IStatement, sqlstmt string) erro
 		isread = true
 	}
 
-	rs, err := session.Executor(isread).Query(sqlstmt)
+	rs, err := session.HintExecutor(stmt, isread).Query(sqlstmt)
 	// TODO here should handler error
 	if err != nil {
 		return session.handleMySQLError(err)
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"encoding/binary"
 	"fmt"
 
 	"strings"
@@ -16,10 +17,12 @@ func (c *Session) handleSet(stmt *parser.Set, sql string) error {
 	}
 
 	var err error
+	var autoCommit bool
 	for _, v := range stmt.VarList {
 		if strings.ToUpper(v.Name) == "AUTOCOMMIT" {
 			log.Debug("handle autocommit")
 			err = c.handleSetAutoCommit(v.Value) //??
+			autoCommit = true
 		}
 	}
 
@@ -27,6 +30,15 @@ func (c *Session) handleSet(stmt *parser.Set, sql string) error {
 		return err
 	}
 
+	if autoCommit {
+		// autoCommit 1 means the autocommit is turned on after the statement
+		if c.autoCommit == 1 || c.isAutoCommit() {
+			c.trackSysVar("autocommit", "ON")
+		} else {
+			c.trackSysVar("autocommit", "OFF")
+		}
+	}
+
 	defer func() {
 		//only execute when the autocommit 0->1 //clear
 		if c.autoCommit == 1 {
@@ -117,23 +129,134 @@ func (c *Session) handleSetAutoCommit(val parser.IExpr) error {
 	return nil
 }
 
-func (c *Session) handleSetNames(val parser.IValExpr) error {
-	value, ok := val.(parser.StrVal)
+// the variables changed by SET NAMES and SET CHARACTER SET
+var charsetVars = []string{
+	"character_set_client",
+	"character_set_connection",
+	"character_set_results",
+	"collation_connection",
+}
+
+// bindCharset tracks the charset of the client in the session variables, so
+// the backend connections are brought to the same charset before the queries.
+func (c *Session) bindCharset() {
+	id := c.fc.Collation()
+	c.vars.SetString(SysVar("character_set_client"), id.Charset())
+	c.vars.SetString(SysVar("character_set_connection"), id.Charset())
+	c.vars.SetString(SysVar("character_set_results"), id.Charset())
+	c.vars.SetString(SysVar("collation_connection"), id.Name())
+}
+
+// syncCollation sets the collation of the client connection to the charset
+// of the results, which is used in the column definitions made by the proxy.
+func (c *Session) syncCollation() {
+	charset, ok := c.vars.GetString(SysVar("character_set_results"))
 	if !ok {
-		return fmt.Errorf("set names charset error")
+		// NULL means no conversion, keep the collation
+		return
+	}
+	if charset == "utf8mb3" {
+		charset = "utf8"
 	}
 
-	charset := strings.ToLower(string(value))
-	cid, ok := CharsetIds[charset]
+	id, ok := CharsetIds[charset]
 	if !ok {
-		return fmt.Errorf("invalid charset %s", charset)
+		log.Warnf("session %d: unknown charset %s", c.sessionId, charset)
+		return
+	}
+
+	// the collation of SET NAMES x COLLATE y
+	if name, ok := c.vars.GetString(SysVar("collation_connection")); ok {
+		if coll, ok := CollationNames[strings.Replace(name, "utf8mb3_", "utf8_", 1)]; ok && coll.Charset() == id.Charset() {
+			id = coll
+		}
+	}
+
+	c.fc.SetCollation(id)
+}
+
+// handleOtherSet runs the SET statement on the master, the session variables
+// set are tracked and replayed on the backend connections the later
+// statements run on.
+func (c *Session) handleOtherSet(stmt *parser.Set, sql string) error {
+	names := sessionVarNames(stmt)
+
+	var rs Result
+	var err error
+	if c.isInTransaction() {
+		rs, err = c.bc.tx.SetVars(c.vars, sql, names)
+	} else {
+		rs, err = c.master().WithVars(c.vars).SetVars(sql, names)
+	}
+	if err != nil {
+		return c.handleMySQLError(err)
 	}
 
-	c.fc.SetCollation(cid)
+	for _, name := range names {
+		if name == SysVar("character_set_results") || name == SysVar("collation_connection") {
+			c.syncCollation()
+			break
+		}
+	}
 
-	return c.fc.WriteOK(nil)
+	return c.fc.WriteOK(rs)
 }
 
-func (c *Session) handleOtherSet(stmt parser.IStatement, sql string) error {
-	return c.handleExec(stmt, sql, false)
+// sessionVarNames returns the names of the session variables set by the
+// statement, SET NAMES and SET CHARACTER SET change the charset variables.
+// autocommit is handled by the session itself, and the global variables are
+// not tracked.
+func sessionVarNames(stmt *parser.Set) []string {
+	var names []string
+	for _, v := range stmt.VarList {
+		if v.Life == parser.Life_Global {
+			continue
+		}
+
+		if v.Type == parser.Type_Usr {
+			names = append(names, UserVar(v.Name))
+			continue
+		}
+
+		switch strings.ToUpper(v.Name) {
+		case "AUTOCOMMIT", "PASSWORD":
+			continue
+		case "NAMES", "CHARACTER SET":
+			for _, name := range charsetVars {
+				names = append(names, SysVar(name))
+			}
+			continue
+		}
+		if strings.Contains(v.Name, ".") {
+			// the structured variables like key caches are global
+			continue
+		}
+		names = append(names, SysVar(v.Name))
+	}
+	return names
+}
+
+// the options of COM_SET_OPTION
+const (
+	optionMultiStatementsOn  = 0
+	optionMultiStatementsOff = 1
+)
+
+// handleSetOption turns the multi statements of the client on or off
+// http://dev.mysql.com/doc/internals/en/com-set-option.html
+func (c *Session) handleSetOption(data []byte) error {
+	if len(data) < 2 {
+		return c.handleMySQLError(ErrMalformPkt)
+	}
+
+	switch binary.LittleEndian.Uint16(data) {
+	case optionMultiStatementsOn:
+		c.fc.SetMultiStatements(true)
+	case optionMultiStatementsOff:
+		c.fc.SetMultiStatements(false)
+	default:
+		return c.handleMySQLError(NewDefaultError(ER_UNKNOWN_COM_ERROR))
+	}
+
+	return c.fc.WriteEOF()
 }
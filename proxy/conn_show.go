@@ -4,7 +4,6 @@ import (
 	"bytes"
 
 	"github.com/bytedance/dbatman/database/mysql"
-	"github.com/bytedance/dbatman/hack"
 	"github.com/bytedance/dbatman/parser"
 	"github.com/ngaut/log"
 )
@@ -31,7 +30,7 @@ func (session *Session) handleFieldList(data []byte) error {
 	table := string(data[0:index])
 	wildcard := string(data[index+1:])
 
-	rs, err := session.bc.master.FieldList(table, wildcard)
+	rs, err := session.master().WithVars(session.vars).FieldList(table, wildcard)
 	// TODO here should handler error
 	if err != nil {
 		return session.handleMySQLError(err)
@@ -58,7 +57,7 @@ func (session *Session) writeFieldList(rs mysql.Rows) error {
 		}
 	}
 
-	// TODO Write a ok packet
+	// the OK packet with the EOF header if the EOF is deprecated
 	if err = session.fc.WriteEOF(); err != nil {
 		return err
 	}
@@ -78,28 +77,10 @@ func (session *Session) handleShowDatabases() error {
 }
 
 func (session *Session) buildSimpleShowResultset(values []interface{}, name string) (mysql.Rows, error) {
-
-	r := new(SimpleRows)
-
-	r.Cols = []*mysql.MySQLField{
-		&mysql.MySQLField{
-			Name:      hack.Slice(name),
-			Charset:   uint16(session.fc.Collation()),
-			FieldType: mysql.FieldTypeVarString,
-		},
-	}
-
-	var row []byte
-	var err error
-
-	for _, value := range values {
-		row, err = formatValue(value)
-		if err != nil {
-			return nil, err
-		}
-
-		r.Rows = append(r.Rows, mysql.AppendLengthEncodedString(make([]byte, 0, len(row)+9), row))
+	rows := make([][]interface{}, len(values))
+	for i, value := range values {
+		rows[i] = []interface{}{value}
 	}
 
-	return r, nil
+	return buildResultset(session.fc.Collation(), []string{name}, rows)
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/bytedance/dbatman/database/sql/driver"
 	"github.com/bytedance/dbatman/parser"
 	"github.com/ngaut/log"
+	"github.com/percona/go-mysql/query"
 )
 
 func (c *Session) handleComStmtPrepare(sqlstmt string) error {
@@ -21,6 +22,15 @@ func (c *Session) handleComStmtPrepare(sqlstmt string) error {
 			mysql.NewDefaultError(mysql.ER_SYNTAX_ERROR, err.Error()))
 	}
 
+	// the statements prepared are checked by the firewall and safe updates
+	// like the queries
+	if err := c.checkFirewall(stmt, query.Fingerprint(sqlstmt), sqlstmt); err != nil {
+		return c.handleMySQLError(err)
+	}
+	if err := c.checkSafeUpdates(stmt, sqlstmt); err != nil {
+		return c.handleMySQLError(err)
+	}
+
 	// Only a few statements supported by prepare statements
 	// http://dev.mysql.com/worklog/task/?id=2871
 	switch v := stmt.(type) {
@@ -66,6 +76,7 @@ func (session *Session) prepare(istmt parser.IStatement, sqlstmt string) error {
 
 	//	record the sql
 	stmt.SQL = istmt
+	stmt.Fingerprint = query.Fingerprint(sqlstmt)
 
 	// TODO duplicate
 	session.bc.stmts[stmt.ID] = stmt
@@ -106,7 +117,7 @@ func (session *Session) writePrepareResult(stmt *mysql.Stmt) error {
 			}
 		}
 
-		if err := session.fc.WriteEOF(); err != nil {
+		if err := session.fc.WriteColumnsEOF(); err != nil {
 			return session.handleMySQLError(err)
 		}
 
@@ -119,7 +130,7 @@ func (session *Session) writePrepareResult(stmt *mysql.Stmt) error {
 			}
 		}
 
-		if err := session.fc.WriteEOF(); err != nil {
+		if err := session.fc.WriteColumnsEOF(); err != nil {
 			return session.handleMySQLError(err)
 		}
 	}
@@ -143,17 +154,26 @@ func (session *Session) handleComStmtExecute(data []byte) error {
 			strconv.FormatUint(uint64(id), 10), "stmt_execute")
 	}
 
+	// the executions are limited like the queries of the same fingerprint
+	if err := session.flowControl(stmt.Fingerprint); err != nil {
+		return session.handleMySQLError(err)
+	}
+
 	flag := data[pos]
 	pos++
 
-	//now we only support CURSOR_TYPE_NO_CURSOR flag
-	if flag != 0 {
-		return mysql.NewDefaultError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("unsupported flag %d", flag))
+	//now we support CURSOR_TYPE_NO_CURSOR and CURSOR_TYPE_READ_ONLY flag
+	if flag != mysql.CursorTypeNoCursor && flag != mysql.CursorTypeReadOnly {
+		return session.handleMySQLError(
+			mysql.NewDefaultError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("unsupported flag %d", flag)))
 	}
 
 	//skip iteration-count, always 1
 	pos += 4
 
+	// executing the statement again closes its cursor
+	session.bc.closeCursor(id)
+
 	var err error
 	switch stmt.SQL.(type) {
 	case parser.ISelect,
@@ -162,8 +182,13 @@ func (session *Session) handleComStmtExecute(data []byte) error {
 		*parser.ShowColumns,
 		*parser.ShowIndex,
 		*parser.DescribeTable:
-		err = session.handleStmtQuery(stmt, data[pos:])
+		if flag == mysql.CursorTypeReadOnly {
+			err = session.handleStmtCursor(id, stmt, data[pos:])
+		} else {
+			err = session.handleStmtQuery(stmt, data[pos:])
+		}
 	default:
+		// the cursor flag is ignored by the statements without result set
 		err = session.handleStmtExec(stmt, data[pos:])
 	}
 
@@ -205,6 +230,93 @@ func (session *Session) handleStmtQuery(stmt *mysql.Stmt, data []byte) error {
 	return session.writeRows(rows)
 }
 
+// handleStmtCursor executes the statement with a read only cursor, the
+// backend connection is held by the cursor until the last row is fetched or
+// the statement is reset, closed or executed again. The rows are sent as
+// usual if the backend does not open the cursor, e.g. for SHOW statements.
+func (session *Session) handleStmtCursor(id uint32, stmt *mysql.Stmt, data []byte) error {
+	var args []interface{}
+	if len(data) > 0 {
+		args = append(args, driver.RawStmtParams(data))
+	}
+
+	cursor, rows, err := stmt.OpenCursor(args...)
+	if err != nil {
+		return session.handleMySQLError(err)
+	}
+
+	if cursor == nil {
+		return session.writeRows(rows)
+	}
+
+	if err := session.writeCursorColumns(cursor); err != nil {
+		cursor.Close()
+		return err
+	}
+
+	session.bc.cursors[id] = cursor
+	return nil
+}
+
+// writeCursorColumns writes the columns of the cursor, the client fetches the
+// rows by COM_STMT_FETCH then
+func (session *Session) writeCursorColumns(cursor *mysql.Cursor) error {
+	cols := cursor.ColumnPackets()
+
+	data := make([]byte, 4, 32)
+	data = mysql.AppendLengthEncodedInteger(data, uint64(len(cols)))
+	if err := session.fc.WritePacket(data); err != nil {
+		return err
+	}
+
+	for _, col := range cols {
+		if err := session.fc.WritePacket(col); err != nil {
+			return err
+		}
+	}
+
+	return session.fc.WriteEOFStatus(mysql.StatusCursorExists)
+}
+
+func (session *Session) handleComStmtFetch(data []byte) error {
+	if len(data) < 8 {
+		return session.handleMySQLError(mysql.ErrMalformPkt)
+	}
+
+	id := binary.LittleEndian.Uint32(data[0:4])
+	n := binary.LittleEndian.Uint32(data[4:8])
+
+	if _, ok := session.bc.stmts[id]; !ok {
+		return session.handleMySQLError(mysql.NewDefaultError(mysql.ER_UNKNOWN_STMT_HANDLER,
+			strconv.FormatUint(uint64(id), 10), "mysqld_stmt_fetch"))
+	}
+
+	cursor, ok := session.bc.cursors[id]
+	if !ok {
+		return session.handleMySQLError(
+			mysql.NewDefaultError(mysql.ER_STMT_HAS_NO_OPEN_CURSOR, id))
+	}
+
+	rows, last, err := cursor.Fetch(n)
+	if err != nil {
+		// the cursor is closed by the error
+		delete(session.bc.cursors, id)
+		return session.handleMySQLError(err)
+	}
+
+	for _, row := range rows {
+		if err := session.fc.WritePacket(row); err != nil {
+			return err
+		}
+	}
+
+	if last {
+		delete(session.bc.cursors, id)
+		return session.fc.WriteEOFStatus(mysql.StatusLastRowSent)
+	}
+	return session.fc.WriteEOFStatus(mysql.StatusCursorExists)
+}
+
 func (session *Session) handleComStmtSendLongData(data []byte) error {
 	if len(data) < 6 {
 		return session.handleMySQLError(mysql.ErrMalformPkt)
@@ -240,6 +352,9 @@ func (session *Session) handleComStmtReset(data []byte) error {
 			strconv.FormatUint(uint64(id), 10), "stmt_reset")
 	}
 
+	// COM_STMT_RESET closes the cursor of the statement
+	session.bc.closeCursor(id)
+
 	if rs, err := stmt.Reset(); err != nil {
 		return session.handleMySQLError(err)
 	} else {
@@ -255,6 +370,7 @@ func (c *Session) handleComStmtClose(data []byte) error {
 	id := binary.LittleEndian.Uint32(data[0:4])
 
 	if cstmt, ok := c.bc.stmts[id]; ok {
+		c.bc.closeCursor(id)
 		cstmt.Close()
 	}
 
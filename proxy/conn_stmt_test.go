@@ -44,6 +44,100 @@ func TestProxy_Stmt(t *testing.T) {
 	}
 }
 
+func TestProxy_StmtCursor(t *testing.T) {
+	db := newSqlDB(testProxyDSN)
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS dbatman_test_proxy_cursor (
+          id BIGINT(64) UNSIGNED  NOT NULL,
+          str VARCHAR(256),
+          PRIMARY KEY (id)
+        ) ENGINE=InnoDB DEFAULT CHARSET=utf8`); err != nil {
+		t.Fatal("create cursor table failed: ", err)
+	}
+	defer db.Exec("DROP TABLE IF EXISTS dbatman_test_proxy_cursor")
+
+	for i := 1; i <= 5; i++ {
+		if _, err := db.Exec("REPLACE INTO dbatman_test_proxy_cursor VALUES (?, ?)", i, "a"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stmt, err := db.Prepare(`select * from dbatman_test_proxy_cursor where id > ? order by id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+
+	cursor, _, err := stmt.OpenCursor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cursor == nil {
+		t.Fatal("expect a cursor opened")
+	}
+	if cols := cursor.ColumnPackets(); len(cols) != 2 {
+		t.Fatalf("expect 2 columns, got %d", len(cols))
+	}
+
+	// fetch 2 rows each time
+	var fetched []int
+	for {
+		rows, last, err := cursor.Fetch(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fetched = append(fetched, len(rows))
+		if last {
+			break
+		}
+		if len(fetched) > 5 {
+			t.Fatalf("expect the last row sent, got %v", fetched)
+		}
+	}
+
+	total := 0
+	for _, n := range fetched {
+		if n > 2 {
+			t.Fatalf("expect at most 2 rows fetched each time, got %v", fetched)
+		}
+		total += n
+	}
+	if total != 5 {
+		t.Fatalf("expect 5 rows, got %d", total)
+	}
+
+	if _, _, err := cursor.Fetch(2); err == nil {
+		t.Fatal("expect the cursor closed after the last row")
+	}
+
+	// close the cursor before the last row, COM_STMT_RESET closes it
+	cursor, _, err = stmt.OpenCursor(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := cursor.Fetch(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := stmt.Query(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("expect 2 rows, got %d", n)
+	}
+}
+
 // TODO fix send long test case
 /*
 func TestProxy_Stmt_SendLong(t *testing.T) {
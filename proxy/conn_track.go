@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"strings"
+
+	. "github.com/bytedance/dbatman/database/mysql"
+)
+
+// the system variables tracked if session_track_system_variables is not set
+const defaultTrackedSysVars = "time_zone,autocommit,character_set_client," +
+	"character_set_results,character_set_connection"
+
+// The session state changes made by the statements the proxy answers itself
+// are reported in the OK packets to the clients using CLIENT_SESSION_TRACK,
+// following the session_track_* variables set by the client. The changes
+// made on the backends are passed through in the OK packets from them.
+
+// trackSchema reports the change of the default database
+func (session *Session) trackSchema(db string) {
+	if !session.trackVarOn("session_track_schema", true) {
+		return
+	}
+
+	session.fc.TrackSessionState(SessionStateChange{Type: SessionTrackSchema, Value: db})
+	session.trackStateChange()
+}
+
+// trackSysVar reports the change of the system variable if it is listed in
+// session_track_system_variables
+func (session *Session) trackSysVar(name, value string) {
+	tracked, ok := session.vars.GetString(SysVar("session_track_system_variables"))
+	if !ok {
+		tracked = defaultTrackedSysVars
+	}
+
+	for _, v := range strings.Split(tracked, ",") {
+		if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, name) {
+			session.fc.TrackSessionState(SessionStateChange{
+				Type:  SessionTrackSystemVariables,
+				Name:  name,
+				Value: value,
+			})
+			session.trackStateChange()
+			return
+		}
+	}
+}
+
+// trackTransaction reports the transaction state, e.g. T_______ after BEGIN
+// and ________ after COMMIT, and the characteristics replaying the
+// transaction started, if session_track_transaction_info is not OFF
+func (session *Session) trackTransaction(state, characteristics string) {
+	info, ok := session.vars.GetString(SysVar("session_track_transaction_info"))
+	if !ok || strings.EqualFold(info, "OFF") {
+		return
+	}
+
+	session.fc.TrackSessionState(SessionStateChange{Type: SessionTrackTransactionState, Value: state})
+	if strings.EqualFold(info, "CHARACTERISTICS") {
+		session.fc.TrackSessionState(SessionStateChange{
+			Type:  SessionTrackTransactionCharacteristics,
+			Value: characteristics,
+		})
+	}
+	session.trackStateChange()
+}
+
+// trackTx reports the session state changes of the COMMIT or ROLLBACK run on
+// the backend, e.g. the GTID of the transaction if session_track_gtids is set
+func (session *Session) trackTx(tx *Tx) {
+	for _, change := range tx.SessionState() {
+		session.fc.TrackSessionState(change)
+	}
+}
+
+// trackStateChange reports that the session state changed if
+// session_track_state_change is on
+func (session *Session) trackStateChange() {
+	if session.trackVarOn("session_track_state_change", false) {
+		session.fc.TrackSessionState(SessionStateChange{Type: SessionTrackStateChange, Value: "1"})
+	}
+}
+
+// trackVarOn returns the value of the boolean session_track_* variable, or
+// def if the client has not set it
+func (session *Session) trackVarOn(name string, def bool) bool {
+	v, ok := session.vars.Get(SysVar(name))
+	if !ok {
+		return def
+	}
+	if s, ok := session.vars.GetString(SysVar(name)); ok {
+		return strings.EqualFold(s, "ON")
+	}
+	return v == "1"
+}
@@ -22,6 +22,7 @@ func (c *Session) handleBegin() error {
 		return c.handleMySQLError(err)
 	}
 
+	c.trackTransaction("T_______", "START TRANSACTION;")
 	return c.fc.WriteOK(nil)
 }
 
@@ -42,9 +43,12 @@ func (c *Session) handleCommit() (err error) {
 
 	// fmt.Println("commit")
 	// fmt.Println("this is a autocommit tx:", !c.isAutoCommit())
+	tx := c.bc.tx
 	if err := c.bc.commit(c.isAutoCommit()); err != nil {
 		return c.handleMySQLError(err)
 	} else {
+		c.trackTx(tx)
+		c.trackTransaction("________", "")
 		return c.fc.WriteOK(nil)
 	}
 }
@@ -64,9 +68,12 @@ func (c *Session) handleRollback() (err error) {
 	}()
 	// fmt.Println("rollback")
 	// fmt.Println("this is a autocommit tx:", !c.isAutoCommit())
+	tx := c.bc.tx
 	if err := c.bc.rollback(c.isAutoCommit()); err != nil {
 		return c.handleMySQLError(err)
 	}
 
+	c.trackTx(tx)
+	c.trackTransaction("________", "")
 	return c.fc.WriteOK(nil)
 }
@@ -44,6 +44,7 @@ func (session *Session) dispatch(data []byte) (err error) {
 		if err := session.useDB(hack.String(data)); err != nil {
 			err = session.handleMySQLError(err)
 		} else {
+			session.trackSchema(proceDbName(hack.String(data)))
 			err = session.fc.WriteOK(nil)
 		}
 	case mysql.ComFieldList:
@@ -58,6 +59,14 @@ func (session *Session) dispatch(data []byte) (err error) {
 		err = session.handleComStmtSendLongData(data)
 	case mysql.ComStmtReset:
 		err = session.handleComStmtReset(data)
+	case mysql.ComStmtFetch:
+		err = session.handleComStmtFetch(data)
+	case mysql.ComChangeUser:
+		err = session.handleChangeUser(data)
+	case mysql.ComResetConnection:
+		err = session.handleResetConnection()
+	case mysql.ComSetOption:
+		err = session.handleSetOption(data)
 	default:
 		msg := fmt.Sprintf("command %d not supported now", cmd)
 		log.Warnf(msg)
@@ -113,6 +122,7 @@ func (session *Session) useDB(dbName string) error {
 			master:  master,
 			slave:   slave,
 			stmts:   make(map[uint32]*mysql.Stmt),
+			cursors: make(map[uint32]*mysql.Cursor),
 			tx:      nil,
 			session: session,
 		}
@@ -126,31 +136,40 @@ func (session *Session) IsAutoCommit() bool {
 }
 
 func (session *Session) writeRows(rs mysql.Rows) error {
+	if err := writeResultset(session.fc, rs); err != nil {
+		return session.handleMySQLError(err)
+	}
+
+	return nil
+}
+
+// writeResultset write the columns and rows of rs to the client, errors
+// returned by rs are returned to the caller without any process.
+func writeResultset(fc *mysql.MySQLServerConn, rs mysql.Rows) error {
 	var cols []driver.RawPacket
 	var err error
 	cols, err = rs.ColumnPackets()
 
 	if err != nil {
-		return session.handleMySQLError(err)
+		return err
 	}
 
 	// Send a packet contains column length
 	data := make([]byte, 4, 32)
 	data = mysql.AppendLengthEncodedInteger(data, uint64(len(cols)))
-	if err = session.fc.WritePacket(data); err != nil {
+	if err = fc.WritePacket(data); err != nil {
 		return err
 	}
 
 	// Write Columns Packet
 	for _, col := range cols {
-		if err := session.fc.WritePacket(col); err != nil {
+		if err := fc.WritePacket(col); err != nil {
 			log.Debugf("write columns packet error %v", err)
 			return err
 		}
 	}
 
-	// TODO Write a ok packet
-	if err = session.fc.WriteEOF(); err != nil {
+	if err = fc.WriteColumnsEOF(); err != nil {
 		return err
 	}
 
@@ -161,16 +180,16 @@ func (session *Session) writeRows(rs mysql.Rows) error {
 
 		// Handle Error
 
-		//warnging if in cli_deprecate_mode will get a ok_packet
+		// the end of the rows is translated to the form the client uses,
+		// the EOF packet or the OK packet with the EOF header
 		if err != nil {
 			if err == io.EOF {
-				return session.fc.WriteEOF()
-			} else {
-				return session.handleMySQLError(err)
+				return fc.WriteEOFResult(rs.EOFResult())
 			}
+			return err
 		}
 
-		if err := session.fc.WritePacket(packet); err != nil {
+		if err := fc.WritePacket(packet); err != nil {
 			return err
 		}
 	}
@@ -182,6 +201,7 @@ func (session *Session) handleMySQLError(e error) error {
 
 	switch inst := e.(type) {
 	case *mysql.MySQLError:
+		session.stmtFailed = true
 		session.fc.WriteError(inst)
 		return nil
 	default:
@@ -1,7 +1,10 @@
 package proxy
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/database/sql/driver"
 	"testing"
 )
 
@@ -45,3 +48,119 @@ func TestProxy_ComInitDB(t *testing.T) {
 		t.Fatal("expect an Unknow DB error")
 	}
 }
+
+func TestProxy_ComResetConnection(t *testing.T) {
+	conn := newRawProxyConn(t)
+	defer conn.Close()
+
+	exec := func(query string) {
+		if _, err := conn.Exec(query, nil); err != nil {
+			t.Fatalf("%s failed: %s", query, err)
+		}
+	}
+
+	exec("CREATE TABLE IF NOT EXISTS go_proxy_test_reset (id BIGINT PRIMARY KEY) ENGINE=InnoDB")
+	exec("DELETE FROM go_proxy_test_reset")
+
+	// the transaction open, the variables and prepared statements of the
+	// session are gone after reset
+	exec("set autocommit = 0")
+	exec("INSERT INTO go_proxy_test_reset VALUES (1)")
+	exec("set @uid = 42")
+	stmt, err := conn.Prepare("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.WriteCommandPacket(mysql.ComResetConnection); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.ReadResultOK(); err != nil {
+		t.Fatal(err)
+	}
+
+	// rolled back, not committed by turning autocommit on
+	db := newSqlDB(testProxyDSN)
+	defer db.Close()
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM go_proxy_test_reset").Scan(&count); err != nil {
+		t.Fatal(err)
+	} else if count != 0 {
+		t.Fatalf("expect the insert rolled back, got %d rows", count)
+	}
+
+	rows, err := conn.Query("SELECT @uid, @@autocommit", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([]driver.Value, 2)
+	if err := rows.Next(values); err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+	if values[0] != nil {
+		t.Fatalf("expect @uid cleared, got %v", values[0])
+	}
+	// the text protocol returns the values in bytes
+	if fmt.Sprintf("%s", values[1]) != "1" {
+		t.Fatalf("expect autocommit on, got %v", values[1])
+	}
+
+	if _, err := stmt.Exec(nil); err == nil {
+		t.Fatal("expect the prepared statement closed")
+	}
+}
+
+func TestProxy_ComChangeUser(t *testing.T) {
+	conn := newRawProxyConn(t)
+	defer conn.Close()
+
+	changeUser := func(password string) error {
+		// user, empty auth, db, charset, plugin
+		payload := "proxy_mysql_user\x00\x00dbatman_test\x00\x21\x00mysql_native_password\x00"
+		if err := conn.WriteCommandPacketStr(mysql.ComChangeUser, payload); err != nil {
+			return err
+		}
+
+		// auth switch request with the fresh salt
+		data, err := conn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		if data[0] != 0xfe {
+			t.Fatalf("expect an auth switch request, got % x", data)
+		}
+		data = data[1:]
+		salt := data[bytes.IndexByte(data, 0)+1:]
+		salt = salt[:bytes.IndexByte(salt, 0)]
+
+		if err := conn.WritePacket(append(make([]byte, 4), CalcPassword(salt, []byte(password))...)); err != nil {
+			return err
+		}
+		return conn.ReadResultOK()
+	}
+
+	if err := changeUser("proxy_mysql_passwd"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := changeUser("wrong_passwd"); err == nil {
+		t.Fatal("expect an access denied error")
+	}
+
+	// the connection is closed, the old user released without harm to the
+	// other sessions
+	if _, err := conn.ReadPacket(); err == nil {
+		t.Fatal("expect the connection closed")
+	}
+
+	other := newRawProxyConn(t)
+	defer other.Close()
+	if err := other.WriteCommandPacket(mysql.ComPing); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ReadResultOK(); err != nil {
+		t.Fatal(err)
+	}
+}
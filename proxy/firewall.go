@@ -0,0 +1,289 @@
+package proxy
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/bytedance/dbatman/config"
+	. "github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/parser"
+	"github.com/ngaut/log"
+	"github.com/percona/go-mysql/query"
+)
+
+// The firewall checks the queries against the rules of the global config and
+// then the ones of the user, the first rule of each list matched by the query
+// decides. The query is denied if either of them is a deny rule, and allowed
+// if none matches.
+
+// firewallRule is a FirewallRule parsed, in lower case
+type firewallRule struct {
+	index        int
+	deny         bool
+	statements   map[string]bool
+	tables       []string
+	fingerprints map[string]bool
+}
+
+func newFirewallRules(rules []*config.FirewallRule) []*firewallRule {
+	ret := make([]*firewallRule, 0, len(rules))
+	for i, rule := range rules {
+		r := &firewallRule{
+			index:        i,
+			deny:         strings.EqualFold(rule.Action, "deny"),
+			statements:   make(map[string]bool),
+			fingerprints: make(map[string]bool),
+		}
+		for _, s := range rule.Statements {
+			r.statements[strings.ToLower(s)] = true
+		}
+		for _, t := range rule.Tables {
+			r.tables = append(r.tables, strings.ToLower(t))
+		}
+		for _, fp := range rule.Fingerprints {
+			r.fingerprints[query.Fingerprint(fp)] = true
+		}
+		ret = append(ret, r)
+	}
+	return ret
+}
+
+// match tells if the query matches the rule, and returns the table matched
+// if the rule is on the tables
+func (r *firewallRule) match(q *firewallQuery) (bool, string) {
+	if len(r.statements) > 0 {
+		matched := false
+		for _, class := range q.classes {
+			if r.statements[class] {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, ""
+		}
+	}
+
+	if len(r.fingerprints) > 0 && !r.fingerprints[q.fingerprint] {
+		return false, ""
+	}
+
+	if len(r.tables) == 0 {
+		return true, ""
+	}
+	for _, table := range q.tables {
+		for _, pattern := range r.tables {
+			if matchTable(pattern, table) {
+				return true, table
+			}
+		}
+	}
+	return false, ""
+}
+
+// matchTable matches db.name to the pattern name, db.name or db.*
+func matchTable(pattern, table string) bool {
+	if !strings.Contains(pattern, ".") {
+		return pattern == table[strings.LastIndex(table, ".")+1:]
+	}
+	if strings.HasSuffix(pattern, ".*") {
+		return strings.HasPrefix(table, pattern[:len(pattern)-1])
+	}
+	return pattern == table
+}
+
+// firewall is the firewall rules parsed from a ProxyConfig
+type firewall struct {
+	cfg *config.ProxyConfig
+
+	global []*firewallRule
+	users  map[string][]*firewallRule
+}
+
+func newFirewall(cfg *config.ProxyConfig) *firewall {
+	fw := &firewall{
+		cfg:   cfg,
+		users: make(map[string][]*firewallRule),
+	}
+	if cfg.Global != nil {
+		fw.global = newFirewallRules(cfg.Global.Firewall)
+	}
+	for username, user := range cfg.Users {
+		if len(user.Firewall) > 0 {
+			fw.users[username] = newFirewallRules(user.Firewall)
+		}
+	}
+	return fw
+}
+
+// check returns the deny rule matched by the query of the user, and the
+// table matched, the scope of the rule is "global" or the username.
+func (fw *firewall) check(username string, q *firewallQuery) (*firewallRule, string, string) {
+	for _, scope := range []string{"global", username} {
+		rules := fw.global
+		if scope != "global" {
+			rules = fw.users[username]
+		}
+
+		for _, rule := range rules {
+			if ok, table := rule.match(q); ok {
+				if rule.deny {
+					return rule, scope, table
+				}
+				break
+			}
+		}
+	}
+	return nil, "", ""
+}
+
+// empty tells if there are no rules for the user, the queries are not
+// inspected then
+func (fw *firewall) empty(username string) bool {
+	return len(fw.global) == 0 && len(fw.users[username]) == 0
+}
+
+// firewallQuery is what the rules match of a query
+type firewallQuery struct {
+	// stmtType of the statement and the finer classes, e.g. ddl and drop
+	classes     []string
+	tables      []string // db.name in lower case
+	fingerprint string
+}
+
+func newFirewallQuery(stmt parser.IStatement, fp string, db string) *firewallQuery {
+	q := &firewallQuery{
+		classes:     stmtClasses(stmt),
+		fingerprint: fp,
+	}
+
+	for _, t := range stmtTables(stmt) {
+		qualifier := db
+		if len(t.Qualifier) > 0 {
+			qualifier = string(t.Qualifier)
+		}
+		q.tables = append(q.tables, strings.ToLower(qualifier+"."+string(t.Name)))
+	}
+	return q
+}
+
+// stmtClasses returns stmtType of the statement followed by the finer
+// classes of the DDL and account management statements
+func stmtClasses(stmt parser.IStatement) []string {
+	classes := []string{stmtType(stmt)}
+
+	switch stmt.(type) {
+	case *parser.DropDatabase, *parser.DropEvent, *parser.DropFunction,
+		*parser.DropIndex, *parser.DropLogfile, *parser.DropProcedure,
+		*parser.DropServer, *parser.DropTables, *parser.DropTablespace,
+		*parser.DropTrigger, *parser.DropUser, *parser.DropView:
+		classes = append(classes, "drop")
+	case *parser.CreateDatabase, *parser.CreateEvent, *parser.CreateFunction,
+		*parser.CreateIndex, *parser.CreateLog, *parser.CreateProcedure,
+		*parser.CreateServer, *parser.CreateTable, *parser.CreateTablespace,
+		*parser.CreateTrigger, *parser.CreateUDF, *parser.CreateUser,
+		*parser.CreateView:
+		classes = append(classes, "create")
+	case *parser.AlterDatabase, *parser.AlterEvent, *parser.AlterFunction,
+		*parser.AlterLogfile, *parser.AlterProcedure, *parser.AlterServer,
+		*parser.AlterTable, *parser.AlterTablespace, *parser.AlterUser,
+		*parser.AlterView:
+		classes = append(classes, "alter")
+	case *parser.TruncateTable:
+		classes = append(classes, "truncate")
+	case *parser.RenameTable:
+		classes = append(classes, "rename")
+	}
+
+	if _, ok := stmt.(parser.IAccountMgrStmt); ok {
+		classes = append(classes, "grant")
+	}
+	return classes
+}
+
+var simpleTableType = reflect.TypeOf(&parser.SimpleTable{})
+
+// stmtTables returns the tables the statement refers to, found by walking
+// the AST as GetSchemas only tells the schemas
+func stmtTables(stmt parser.IStatement) []*parser.SimpleTable {
+	var tables []*parser.SimpleTable
+	walkAST(reflect.ValueOf(stmt), func(v reflect.Value) bool {
+		if v.Type() != simpleTableType {
+			return true
+		}
+		tables = append(tables, v.Interface().(*parser.SimpleTable))
+		return false
+	})
+	return tables
+}
+
+// walkAST calls visit with the non-nil pointers of the AST depth first, the
+// ones they point to are skipped if visit returns false
+func walkAST(v reflect.Value, visit func(reflect.Value) bool) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if v.Kind() == reflect.Ptr && !visit(v) {
+			return
+		}
+		walkAST(v.Elem(), visit)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.CanInterface() {
+				walkAST(f, visit)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		// the identifiers and literals
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			walkAST(v.Index(i), visit)
+		}
+	}
+}
+
+// firewall returns the firewall rules of current config, they are parsed
+// again only when the config is reloaded.
+func (s *Server) firewall() *firewall {
+	cfg := s.cfg.GetConfig()
+	if fw, ok := s.fw.Load().(*firewall); ok && fw.cfg == cfg {
+		return fw
+	}
+
+	fw := newFirewall(cfg)
+	s.fw.Store(fw)
+	return fw
+}
+
+// checkFirewall returns the error of the query denied by the firewall
+func (session *Session) checkFirewall(stmt parser.IStatement, fp string, sqlstmt string) error {
+	fw := session.server.firewall()
+	username := session.user.Username
+	if fw.empty(username) {
+		return nil
+	}
+
+	var db string
+	if session.cluster != nil {
+		db = session.cluster.DBName
+	}
+
+	q := newFirewallQuery(stmt, fp, db)
+	rule, scope, table := fw.check(username, q)
+	if rule == nil {
+		return nil
+	}
+
+	log.Warnf("session %d: query of user %s denied by the firewall rule %d of %s: %s",
+		session.sessionId, username, rule.index, scope, sqlstmt)
+
+	if table != "" {
+		command := strings.ToUpper(q.classes[len(q.classes)-1])
+		return NewDefaultError(ER_TABLEACCESS_DENIED_ERROR, command, username, session.cliAddr, table)
+	}
+	return NewDefaultError(ER_OPTION_PREVENTS_STATEMENT, "--firewall")
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/bytedance/dbatman/config"
+	"github.com/bytedance/dbatman/parser"
+	"github.com/percona/go-mysql/query"
+)
+
+func TestFirewall(t *testing.T) {
+	fw := newFirewall(&config.ProxyConfig{
+		Global: &config.GlobalConfig{
+			Firewall: []*config.FirewallRule{
+				{Action: "deny", Statements: []string{"grant"}},
+			},
+		},
+		Users: map[string]*config.UserConfig{
+			"app": {
+				Username: "app",
+				Firewall: []*config.FirewallRule{
+					{Action: "allow", Tables: []string{"tmp.*"}},
+					{Action: "deny", Statements: []string{"drop", "truncate"}},
+					{Action: "deny", Statements: []string{"select"}, Tables: []string{"secret"}},
+					{Action: "deny", Fingerprints: []string{"select * from t where name = 'x'"}},
+				},
+			},
+			"admin": {Username: "admin"},
+		},
+	})
+
+	for _, test := range []struct {
+		user  string
+		sql   string
+		deny  bool
+		table string
+	}{
+		{"app", "select * from t where id = 1", false, ""},
+		// the table is told only by the rules on the tables
+		{"app", "drop table t", true, ""},
+		{"app", "drop database app", true, ""},
+		{"app", "truncate table db.t", true, ""},
+		// allowed by the first rule
+		{"app", "drop table tmp.t", false, ""},
+		{"app", "select * from t join db.Secret on t.id = Secret.id", true, "db.secret"},
+		{"app", "select * from (select id from secret) s", true, "app.secret"},
+		{"app", "insert into secret values (1)", false, ""},
+		{"app", "SELECT * FROM t WHERE name = 'x'", true, ""},
+		{"app", "grant all on *.* to 'app'@'%'", true, ""},
+		// the allow rules of the user do not override the global ones
+		{"app", "grant all on tmp.* to 'app'@'%'", true, ""},
+		{"admin", "drop table t", false, ""},
+		{"admin", "create user 'x'@'%'", true, ""},
+	} {
+		stmt, err := parser.Parse(test.sql)
+		if err != nil {
+			t.Fatalf("%s: %v", test.sql, err)
+		}
+
+		q := newFirewallQuery(stmt, query.Fingerprint(test.sql), test.user)
+		rule, _, table := fw.check(test.user, q)
+		if deny := rule != nil; deny != test.deny {
+			t.Fatalf("%s of %s: expect denied %v, got %v", test.sql, test.user, test.deny, deny)
+		}
+		if deny := rule != nil; deny && table != test.table {
+			t.Fatalf("%s of %s: expect table %q, got %q", test.sql, test.user, test.table, table)
+		}
+	}
+
+	if fw.empty("other") {
+		t.Fatal("expect the global rules for any user")
+	}
+}
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/dbatman/config"
+	. "github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/metrics"
+	"github.com/bytedance/dbatman/ratelimit"
+	"github.com/ngaut/log"
+	"github.com/percona/go-mysql/query"
+)
+
+var limitedQueries = metrics.NewCounterVec("dbatman_queries_limited_total",
+	"Total number of queries rejected by the flow control.", "scope")
+
+// LimitReqNode is the statistics and the token bucket of the queries of a
+// fingerprint, a user or the whole server. All the counters are accessed
+// atomically.
+type LimitReqNode struct {
+	count    int64 // total requests
+	rejected int64 // total requests rejected by the flow control
+
+	second       int64 // the unix second the currentcount belongs to
+	currentcount int64 // requests in current second
+	lastcount    int64 // requests in last second, means qps
+
+	query  string
+	bucket *ratelimit.Bucket
+}
+
+func newLimitReqNode(query string) *LimitReqNode {
+	return &LimitReqNode{
+		query:  query,
+		bucket: ratelimit.NewBucket(0, 0),
+	}
+}
+
+// hit counts a request at the given unix second
+func (lr *LimitReqNode) hit(now int64) {
+	atomic.AddInt64(&lr.count, 1)
+
+	second := atomic.LoadInt64(&lr.second)
+	if second != now && atomic.CompareAndSwapInt64(&lr.second, second, now) {
+		current := atomic.SwapInt64(&lr.currentcount, 0)
+		if now-second > 1 {
+			// no request in last second
+			current = 0
+		}
+		atomic.StoreInt64(&lr.lastcount, current)
+	}
+
+	atomic.AddInt64(&lr.currentcount, 1)
+}
+
+func (lr *LimitReqNode) Query() string {
+	return lr.query
+}
+
+func (lr *LimitReqNode) Count() int64 {
+	return atomic.LoadInt64(&lr.count)
+}
+
+func (lr *LimitReqNode) Rejected() int64 {
+	return atomic.LoadInt64(&lr.rejected)
+}
+
+// QPS returns the requests of the last second
+func (lr *LimitReqNode) QPS() int64 {
+	if atomic.LoadInt64(&lr.second) < time.Now().Unix()-1 {
+		return 0
+	}
+	return atomic.LoadInt64(&lr.lastcount)
+}
+
+const limitTableShards = 32
+
+// limitTable is a map of LimitReqNode sharded by the hash of the key, so
+// the queries of different fingerprints rarely contend on the same lock.
+type limitTable struct {
+	shards [limitTableShards]struct {
+		sync.RWMutex
+		nodes map[string]*LimitReqNode
+	}
+}
+
+func newLimitTable() *limitTable {
+	t := new(limitTable)
+	for i := range t.shards {
+		t.shards[i].nodes = make(map[string]*LimitReqNode)
+	}
+	return t
+}
+
+// get returns the node of key, creates it if not exists
+func (t *limitTable) get(key string) *LimitReqNode {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := &t.shards[h.Sum32()%limitTableShards]
+
+	shard.RLock()
+	lr, ok := shard.nodes[key]
+	shard.RUnlock()
+	if ok {
+		return lr
+	}
+
+	shard.Lock()
+	defer shard.Unlock()
+	if lr, ok = shard.nodes[key]; !ok {
+		lr = newLimitReqNode(key)
+		shard.nodes[key] = lr
+	}
+	return lr
+}
+
+func (t *limitTable) all() []*LimitReqNode {
+	var ret []*LimitReqNode
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.RLock()
+		for _, lr := range shard.nodes {
+			ret = append(ret, lr)
+		}
+		shard.RUnlock()
+	}
+	return ret
+}
+
+// flowRules is the flow control config parsed from a ProxyConfig
+type flowRules struct {
+	cfg *config.ProxyConfig
+
+	active           bool
+	fingerprintRate  int64
+	fingerprintBurst int64
+	fingerprints     map[string]*config.LimitConfig
+	queueSize        int64
+	waitTimeout      time.Duration
+}
+
+func newFlowRules(cfg *config.ProxyConfig) *flowRules {
+	gc := cfg.Global
+	r := &flowRules{
+		cfg:              cfg,
+		active:           gc.LimitActive,
+		fingerprintRate:  gc.FingerprintRate,
+		fingerprintBurst: gc.FingerprintBurst,
+		fingerprints:     make(map[string]*config.LimitConfig),
+		queueSize:        gc.LimitQueueSize,
+		waitTimeout:      time.Duration(gc.LimitWaitTimeout) * time.Millisecond,
+	}
+
+	for _, l := range gc.FingerprintLimits {
+		r.fingerprints[query.Fingerprint(l.Fingerprint)] = l
+	}
+
+	return r
+}
+
+// fingerprintLimit returns the rate and burst of the fingerprint
+func (r *flowRules) fingerprintLimit(fp string) (int64, int64) {
+	if l, ok := r.fingerprints[fp]; ok {
+		return l.Rate, l.Burst
+	}
+	return r.fingerprintRate, r.fingerprintBurst
+}
+
+// flowRules returns the rules of current config, they are parsed again only
+// when the config is reloaded.
+func (s *Server) flowRules() *flowRules {
+	cfg := s.cfg.GetConfig()
+	if r, ok := s.rules.Load().(*flowRules); ok && r.cfg == cfg {
+		return r
+	}
+
+	r := newFlowRules(cfg)
+	s.rules.Store(r)
+	return r
+}
+
+// flowControl counts the query and checks the server-wide, user and
+// fingerprint limits. Queries over a limit wait in a bounded queue for at
+// most limit_wait_timeout, or are rejected with ER_USER_LIMIT_REACHED.
+func (session *Session) flowControl(fp string) error {
+	s := session.server
+	now := time.Now()
+
+	fpNode := s.fingerprints.get(fp)
+	fpNode.hit(now.Unix())
+	s.qpsOnServer.hit(now.Unix())
+
+	rules := s.flowRules()
+	if !rules.active {
+		return nil
+	}
+
+	userNode := s.users.get(session.user.Username)
+	userNode.hit(now.Unix())
+
+	fpNode.bucket.SetRate(rules.fingerprintLimit(fp))
+	userNode.bucket.SetRate(session.user.ReqRate, session.user.ReqBurst)
+	s.qpsOnServer.bucket.SetRate(rules.cfg.Global.ReqRate, rules.cfg.Global.ReqBurst)
+
+	// from the most specific limit to the server-wide one
+	limits := []flowLimit{
+		{"fingerprint", fpNode},
+		{"user", userNode},
+		{"server", s.qpsOnServer},
+	}
+
+	wait, rejected := reserveLimits(limits, now, 0)
+
+	// the query over a limit takes a place in the queue to wait, if any
+	if rejected >= 0 && rules.waitTimeout > 0 && rules.queueSize > 0 {
+		if atomic.AddInt64(&s.limitWaiting, 1) <= rules.queueSize {
+			defer atomic.AddInt64(&s.limitWaiting, -1)
+			wait, rejected = reserveLimits(limits, now, rules.waitTimeout)
+		} else {
+			atomic.AddInt64(&s.limitWaiting, -1)
+		}
+	}
+
+	if rejected >= 0 {
+		l := limits[rejected]
+		atomic.AddInt64(&l.node.rejected, 1)
+		limitedQueries.WithLabelValues(l.scope).Inc()
+
+		rate, _ := l.node.bucket.Rate()
+		log.Warnf("session %d: query over the %s rate limit %d: %s", session.sessionId, l.scope, rate, fp)
+
+		e := NewDefaultError(ER_USER_LIMIT_REACHED)
+		e.Message = fmt.Sprintf("User '%s' has exceeded the '%s rate' resource (current value: %d)",
+			session.user.Username, l.scope, rate)
+		return e
+	}
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+
+	return nil
+}
+
+// flowLimit is a limit checked by flowControl
+type flowLimit struct {
+	scope string
+	node  *LimitReqNode
+}
+
+// reserveLimits takes a token of each limit and returns the longest delay,
+// the delays are waited for together so each one could be up to maxWait. If
+// a limit rejects, the tokens taken of the others are given back and the
+// index of the limit is returned, or -1.
+func reserveLimits(limits []flowLimit, now time.Time, maxWait time.Duration) (time.Duration, int) {
+	var wait time.Duration
+	for i, l := range limits {
+		delay, ok := l.node.bucket.Reserve(now, maxWait)
+		if !ok {
+			for _, reserved := range limits[:i] {
+				reserved.node.bucket.Cancel()
+			}
+			return 0, i
+		}
+
+		if delay > wait {
+			wait = delay
+		}
+	}
+	return wait, -1
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFlowControl_LimitTable(t *testing.T) {
+	table := newLimitTable()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				table.get("select * from t where id = ?").hit(time.Now().Unix())
+			}
+		}()
+	}
+	wg.Wait()
+
+	table.get("select ?").hit(time.Now().Unix())
+
+	nodes := table.all()
+	if len(nodes) != 2 {
+		t.Fatalf("expect 2 fingerprints, got %d", len(nodes))
+	}
+
+	if c := table.get("select * from t where id = ?").Count(); c != 800 {
+		t.Fatalf("expect count 800, got %d", c)
+	}
+}
+
+func TestFlowControl_QPS(t *testing.T) {
+	lr := newLimitReqNode("select ?")
+
+	now := time.Now().Unix()
+	for i := 0; i < 10; i++ {
+		lr.hit(now - 1)
+	}
+	lr.hit(now)
+
+	if qps := lr.QPS(); qps != 10 {
+		t.Fatalf("expect qps 10, got %d", qps)
+	}
+
+	// a gap of seconds without request
+	lr.hit(now + 5)
+	if lr.lastcount != 0 {
+		t.Fatalf("expect qps 0 after idle, got %d", lr.lastcount)
+	}
+}
+
+func TestFlowControl_ReserveLimits(t *testing.T) {
+	newLimit := func(scope string, rate, burst int64) flowLimit {
+		l := flowLimit{scope, newLimitReqNode(scope)}
+		l.node.bucket.SetRate(rate, burst)
+		return l
+	}
+
+	now := time.Now()
+	fp, user, server := newLimit("fingerprint", 10, 1), newLimit("user", 10, 1), newLimit("server", 10, 1)
+	server.node.bucket.Take()
+
+	// the tokens of the fingerprint and user are given back
+	limits := []flowLimit{fp, user, server}
+	if _, rejected := reserveLimits(limits, now, 0); rejected != 2 {
+		t.Fatalf("expect rejected by the server limit, got %d", rejected)
+	}
+	if !fp.node.bucket.Take() || !user.node.bucket.Take() {
+		t.Fatal("expect the tokens of the fingerprint and user kept")
+	}
+
+	// each limit could take up to the max wait, they are waited together
+	fp, user = newLimit("fingerprint", 10, 1), newLimit("user", 10, 1)
+	fp.node.bucket.Reserve(now, 0)
+	user.node.bucket.Reserve(now, 0)
+	wait, rejected := reserveLimits([]flowLimit{fp, user}, now, 150*time.Millisecond)
+	if rejected >= 0 || wait != 100*time.Millisecond {
+		t.Fatalf("expect wait 100ms, got %s and rejected %d", wait, rejected)
+	}
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"net"
+
+	"github.com/bytedance/dbatman/config"
+	. "github.com/bytedance/dbatman/database/mysql"
+)
+
+// ipNetworks are the networks of auth_ips or black_list_ips
+type ipNetworks []*net.IPNet
+
+func parseIPNetworks(list []string) ipNetworks {
+	// validated when the config is loaded
+	networks, _ := config.ParseNetworks(list)
+	return networks
+}
+
+func (n ipNetworks) contains(ip net.IP) bool {
+	for _, network := range n {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipRules is the IP access control of the clients parsed from a ProxyConfig.
+// The clients in black_list_ips of the global config or the user are
+// rejected, then the ones not in auth_ips if authip_active is on. The
+// global auth_ips is not checked if empty, the one of a user is.
+type ipRules struct {
+	cfg *config.ProxyConfig
+
+	active bool
+	allow  ipNetworks
+	deny   ipNetworks
+	users  map[string]*userIPRules
+}
+
+type userIPRules struct {
+	allow ipNetworks
+	deny  ipNetworks
+}
+
+func newIPRules(cfg *config.ProxyConfig) *ipRules {
+	gc := cfg.Global
+	r := &ipRules{
+		cfg:    cfg,
+		active: gc.AuthIPActive,
+		allow:  parseIPNetworks(gc.AuthIPs),
+		deny:   parseIPNetworks(gc.BlackListIPs),
+		users:  make(map[string]*userIPRules),
+	}
+
+	for username, user := range cfg.Users {
+		r.users[username] = &userIPRules{
+			allow: parseIPNetworks(user.AuthIPs),
+			deny:  parseIPNetworks(user.BlackListIPs),
+		}
+	}
+
+	return r
+}
+
+// checkGlobal checks the client against the lists of the global config,
+// before the user is known
+func (r *ipRules) checkGlobal(host string) error {
+	ip := hostIP(host)
+	if r.deny.contains(ip) {
+		return NewDefaultError(ER_HOST_NOT_PRIVILEGED, host)
+	}
+
+	if r.active && len(r.allow) > 0 && !r.allow.contains(ip) {
+		return NewDefaultError(ER_HOST_NOT_PRIVILEGED, host)
+	}
+
+	return nil
+}
+
+// checkUser checks the client against the lists of the user
+func (r *ipRules) checkUser(username, host string) error {
+	u, ok := r.users[username]
+	if !ok {
+		return NewDefaultError(ER_ACCESS_DENIED_ERROR, username, host, "Yes")
+	}
+
+	ip := hostIP(host)
+	if u.deny.contains(ip) {
+		return NewDefaultError(ER_ACCESS_DENIED_ERROR, username, host, "Yes")
+	}
+
+	if r.active && !u.allow.contains(ip) {
+		return NewDefaultError(ER_ACCESS_DENIED_ERROR, username, host, "Yes")
+	}
+
+	return nil
+}
+
+// hostIP returns the IP of the client host, the clients of the unix socket
+// are from the loopback address
+func hostIP(host string) net.IP {
+	if host == "localhost" {
+		return net.IPv4(127, 0, 0, 1)
+	}
+	return net.ParseIP(host)
+}
+
+// ipRules returns the IP rules of the config the session started with,
+// they are parsed again only when the config is reloaded.
+func (s *Server) ipRules(cfg *config.ProxyConfig) *ipRules {
+	if r, ok := s.acl.Load().(*ipRules); ok && r.cfg == cfg {
+		return r
+	}
+
+	r := newIPRules(cfg)
+	s.acl.Store(r)
+	return r
+}
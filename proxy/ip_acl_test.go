@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/bytedance/dbatman/config"
+	. "github.com/bytedance/dbatman/database/mysql"
+)
+
+func TestIPRules(t *testing.T) {
+	cfg := &config.ProxyConfig{
+		Global: &config.GlobalConfig{
+			AuthIPActive: true,
+			AuthIPs:      []string{"10.0.0.0/8", "fd00::/8", "127.0.0.1"},
+			BlackListIPs: []string{"10.9.0.0/16"},
+		},
+		Users: map[string]*config.UserConfig{
+			"app": {
+				Username:     "app",
+				AuthIPs:      []string{"10.1.0.0/16", "fd00::1", "127.0.0.1"},
+				BlackListIPs: []string{"10.1.2.3"},
+			},
+			"nobody": {Username: "nobody"},
+		},
+	}
+
+	for _, test := range []struct {
+		active bool
+		user   string
+		host   string
+		code   uint16
+	}{
+		{true, "app", "10.1.0.1", 0},
+		{true, "app", "fd00::1", 0},
+		{true, "app", "localhost", 0},
+		{true, "app", "192.168.0.1", ER_HOST_NOT_PRIVILEGED},
+		// denied by the global black list though in the allowed networks
+		{true, "app", "10.9.0.1", ER_HOST_NOT_PRIVILEGED},
+		{true, "app", "10.1.2.3", ER_ACCESS_DENIED_ERROR},
+		{true, "app", "10.2.0.1", ER_ACCESS_DENIED_ERROR},
+		{true, "app", "fd00::2", ER_ACCESS_DENIED_ERROR},
+		{true, "nobody", "10.1.0.1", ER_ACCESS_DENIED_ERROR},
+		{true, "unknown", "10.1.0.1", ER_ACCESS_DENIED_ERROR},
+		// only the black lists are checked if authip_active is off
+		{false, "app", "192.168.0.1", 0},
+		{false, "app", "10.2.0.1", 0},
+		{false, "app", "10.9.0.1", ER_HOST_NOT_PRIVILEGED},
+		{false, "app", "10.1.2.3", ER_ACCESS_DENIED_ERROR},
+	} {
+		cfg.Global.AuthIPActive = test.active
+		r := newIPRules(cfg)
+
+		err := r.checkGlobal(test.host)
+		if err == nil {
+			err = r.checkUser(test.user, test.host)
+		}
+
+		var code uint16
+		if err != nil {
+			code = err.(*MySQLError).Number
+		}
+		if code != test.code {
+			t.Fatalf("%s@%s active %v: expect error %d, got %v", test.user, test.host, test.active, test.code, err)
+		}
+	}
+}
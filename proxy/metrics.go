@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"time"
+
+	"github.com/bytedance/dbatman/metrics"
+	"github.com/bytedance/dbatman/parser"
+)
+
+var (
+	activeSessions = metrics.NewGaugeVec("dbatman_sessions_active",
+		"Number of client sessions passed the handshake.")
+
+	queryCount = metrics.NewCounterVec("dbatman_queries_total",
+		"Total number of queries by statement type.", "type")
+
+	queryDuration = metrics.NewHistogramVec("dbatman_query_duration_seconds",
+		"Latency of queries by statement type.", nil, "type")
+)
+
+// stmtType returns the statement type label of the query metrics
+func stmtType(stmt parser.IStatement) string {
+	switch stmt.(type) {
+	case parser.ISelect:
+		return "select"
+	case *parser.Insert:
+		return "insert"
+	case *parser.Update:
+		return "update"
+	case *parser.Delete:
+		return "delete"
+	case *parser.Replace:
+		return "replace"
+	case *parser.Set, *parser.SetTrans:
+		return "set"
+	case *parser.Begin, *parser.StartTrans:
+		return "begin"
+	case *parser.Commit:
+		return "commit"
+	case *parser.Rollback:
+		return "rollback"
+	case parser.IShow:
+		return "show"
+	case parser.IDDLStatement:
+		return "ddl"
+	case *parser.Use:
+		return "use"
+	case *parser.Call:
+		return "call"
+	case *parser.DescribeTable, *parser.DescribeStmt:
+		return "describe"
+	default:
+		return "other"
+	}
+}
+
+func observeQuery(typ string, start time.Time) {
+	queryCount.WithLabelValues(typ).Inc()
+	queryDuration.WithLabelValues(typ).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The load balancers in proxy_protocol_networks send the PROXY protocol
+// header before the handshake, the address of the client in it is used for
+// the auth and the logs instead of the one of the load balancer.
+// http://www.haproxy.org/download/1.8/doc/proxy-protocol.txt
+
+const (
+	proxyHeaderTimeout = 5 * time.Second
+
+	// the longest v1 header with the CRLF
+	proxyHeaderV1MaxLength = 107
+	proxyHeaderV2Length    = 16
+)
+
+var proxyHeaderV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errProxyHeader = errors.New("malformed PROXY protocol header")
+
+// proxyConn is the client connection after the PROXY protocol header, the
+// remote address is the one of the client in the header
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// trustedProxy tells if the peer is allowed to send the PROXY protocol header
+func (s *Server) trustedProxy(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, network := range s.proxyNetworks {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// readProxyHeader reads the PROXY protocol header the trusted load balancers
+// must send, the connections from the other peers are returned as is
+func (s *Server) readProxyHeader(c net.Conn) (net.Conn, error) {
+	if !s.trustedProxy(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	c.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.SetReadDeadline(time.Time{})
+
+	pc := &proxyConn{Conn: c, r: bufio.NewReader(c), remote: c.RemoteAddr()}
+	addr, err := parseProxyHeader(pc.r)
+	if err != nil {
+		return nil, err
+	}
+
+	// the health checks of the load balancer, UNKNOWN in v1 or LOCAL in v2
+	if addr != nil {
+		pc.remote = addr
+	}
+	return pc, nil
+}
+
+// parseProxyHeader reads the v1 or v2 header, the returned address is nil
+// if the header doesn't carry the one of the client
+func parseProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyHeaderV2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(sig, proxyHeaderV2Signature) {
+		return parseProxyHeaderV2(r)
+	}
+	return parseProxyHeaderV1(r)
+}
+
+// parseProxyHeaderV1 reads the header in text, e.g.
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 3306\r\n
+func parseProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		if err == bufio.ErrBufferFull {
+			return nil, errProxyHeader
+		}
+		return nil, err
+	}
+	if len(line) > proxyHeaderV1MaxLength || !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errProxyHeader
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, errProxyHeader
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, errProxyHeader
+	}
+
+	if len(fields) != 6 {
+		return nil, errProxyHeader
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, errProxyHeader
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// parseProxyHeaderV2 reads the binary header, the TLVs after the addresses
+// are skipped
+func parseProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, proxyHeaderV2Length)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	// the version in the high 4 bits, the command in the low ones
+	if header[12]>>4 != 2 {
+		return nil, errProxyHeader
+	}
+
+	data := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+
+	switch header[12] & 0x0f {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, errProxyHeader
+	}
+
+	// the address family in the high 4 bits, the transport in the low ones
+	switch header[13] >> 4 {
+	case 0x1: // AF_INET
+		if len(data) < 12 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(data[:4]), Port: int(binary.BigEndian.Uint16(data[8:]))}, nil
+	case 0x2: // AF_INET6
+		if len(data) < 36 {
+			return nil, errProxyHeader
+		}
+		return &net.TCPAddr{IP: net.IP(data[:16]), Port: int(binary.BigEndian.Uint16(data[32:]))}, nil
+	default:
+		// AF_UNSPEC and AF_UNIX, the address of the peer is kept
+		return nil, nil
+	}
+}
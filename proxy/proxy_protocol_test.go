@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestParseProxyHeader(t *testing.T) {
+	v2 := func(cmd, family byte, addrs ...byte) []byte {
+		header := append([]byte{}, proxyHeaderV2Signature...)
+		header = append(header, 0x20|cmd, family, 0, byte(len(addrs)))
+		return append(header, addrs...)
+	}
+
+	ipv4 := []byte{192, 168, 0, 1, 192, 168, 0, 11, 0xdc, 0x04, 0x0c, 0xea}
+	ipv6 := append(append(net.ParseIP("fd00::1"), net.ParseIP("fd00::2")...), 0xdc, 0x04, 0x0c, 0xea)
+	// a TLV after the addresses
+	tlv := append(append([]byte{}, ipv4...), 0x04, 0x00, 0x01, 0x00)
+
+	for i, test := range []struct {
+		header []byte
+		addr   string
+		fail   bool
+	}{
+		{[]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 3306\r\n"), "192.168.0.1:56324", false},
+		{[]byte("PROXY TCP6 fd00::1 fd00::2 56324 3306\r\n"), "[fd00::1]:56324", false},
+		{[]byte("PROXY UNKNOWN\r\n"), "", false},
+		{[]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324\r\n"), "", true},
+		{[]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 3306\n"), "", true},
+		{[]byte("PROXY UDP4 192.168.0.1 192.168.0.11 56324 3306\r\n"), "", true},
+		{[]byte("GET / HTTP/1.1\r\n"), "", true},
+		{v2(0x1, 0x11, ipv4...), "192.168.0.1:56324", false},
+		{v2(0x1, 0x21, ipv6...), "[fd00::1]:56324", false},
+		{v2(0x1, 0x11, tlv...), "192.168.0.1:56324", false},
+		{v2(0x0, 0x00), "", false},
+		{v2(0x1, 0x11, ipv4[:8]...), "", true},
+	} {
+		// the handshake packets following the header are kept
+		r := bufio.NewReader(bytes.NewReader(append(test.header, "next"...)))
+		addr, err := parseProxyHeader(r)
+		if test.fail {
+			if err == nil {
+				t.Fatalf("%d: expect error of header %q", i, test.header)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: %s", i, err)
+		}
+
+		if test.addr == "" {
+			if addr != nil {
+				t.Fatalf("%d: expect no address, got %s", i, addr)
+			}
+		} else if addr == nil || addr.String() != test.addr {
+			t.Fatalf("%d: expect address %s, got %v", i, test.addr, addr)
+		}
+
+		if next, _ := r.Peek(4); string(next) != "next" {
+			t.Fatalf("%d: expect the data after the header kept, got %q", i, next)
+		}
+	}
+}
@@ -61,3 +61,15 @@ func (rs *SimpleRows) Close() error {
 func (rs *SimpleRows) Err() error {
 	return nil
 }
+
+func (rs *SimpleRows) NextResultSet() bool {
+	return false
+}
+
+func (rs *SimpleRows) Result() Result {
+	return nil
+}
+
+func (rs *SimpleRows) EOFResult() Result {
+	return nil
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"reflect"
+
+	. "github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/parser"
+	"github.com/ngaut/log"
+)
+
+var schemaObjectType = reflect.TypeOf(&parser.SchemaObject{})
+
+// checkSafeUpdates rejects the UPDATE and DELETE of all the rows if
+// safe_updates of the user is on, as sql_safe_updates of MySQL. The proxy
+// does not know the keys of the tables, so a statement is rejected if it has
+// neither LIMIT nor a WHERE on any column, e.g. WHERE 1 = 1.
+func (session *Session) checkSafeUpdates(stmt parser.IStatement, sqlstmt string) error {
+	if !session.user.SafeUpdates {
+		return nil
+	}
+
+	var where parser.IExpr
+	var limit *parser.Limit
+	switch v := stmt.(type) {
+	case *parser.Update:
+		where, limit = v.Where, v.Limit
+	case *parser.Delete:
+		where, limit = v.Where, v.Limit
+	default:
+		return nil
+	}
+
+	if limit != nil || hasColumn(where) {
+		return nil
+	}
+
+	log.Warnf("session %d: full table update of user %s rejected in safe update mode: %s",
+		session.sessionId, session.user.Username, sqlstmt)
+	return NewDefaultError(ER_UPDATE_WITHOUT_KEY_IN_SAFE_MODE)
+}
+
+// hasColumn tells if the expression refers to any column
+func hasColumn(expr parser.IExpr) bool {
+	found := false
+	walkAST(reflect.ValueOf(expr), func(v reflect.Value) bool {
+		if v.Type() == schemaObjectType && len(v.Interface().(*parser.SchemaObject).Column) > 0 {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/bytedance/dbatman/config"
+	. "github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/parser"
+)
+
+func TestCheckSafeUpdates(t *testing.T) {
+	for _, test := range []struct {
+		sql    string
+		safe   bool
+		reject bool
+	}{
+		{"update t set a = 1", false, false},
+		{"update t set a = 1", true, true},
+		{"update t set a = 1 where 1 = 1", true, true},
+		{"update t set a = 1 where id = 1", true, false},
+		{"update t set a = 1 where t.id in (select id from t2)", true, false},
+		{"update t set a = 1 limit 10", true, false},
+		{"delete from t", true, true},
+		{"delete from t where true", true, true},
+		{"delete from t where id > 10", true, false},
+		{"delete from t order by id limit 1", true, false},
+		{"delete t1 from t1 join t2", true, true},
+		{"delete t1 from t1 join t2 where t1.id = t2.id", true, false},
+		{"select * from t", true, false},
+	} {
+		stmt, err := parser.Parse(test.sql)
+		if err != nil {
+			t.Fatalf("%s: %v", test.sql, err)
+		}
+
+		session := &Session{user: &config.UserConfig{Username: "app", SafeUpdates: test.safe}}
+		err = session.checkSafeUpdates(stmt, test.sql)
+		if reject := err != nil; reject != test.reject {
+			t.Fatalf("%s: expect rejected %v, got %v", test.sql, test.reject, err)
+		}
+		if err != nil && err.(*MySQLError).Number != ER_UPDATE_WITHOUT_KEY_IN_SAFE_MODE {
+			t.Fatalf("%s: unexpected error %v", test.sql, err)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package proxy
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"os"
 	"runtime"
+	"sort"
+	"strings"
 	"time"
 
 	"sync"
@@ -12,34 +16,14 @@ import (
 	"syscall"
 
 	"github.com/bytedance/dbatman/config"
-	_ "github.com/bytedance/dbatman/database/mysql"
+	"github.com/bytedance/dbatman/database/mysql"
 	"github.com/ngaut/log"
 )
 
-var startNum = 0
-var closeNum = 0
+// the kinds of the listeners passed to the new process on graceful restart,
+// in the order of the file descriptors from 3, e.g. tcp,unix
+const gracefulRestartListeners = "_GRACEFUL_RESTART_LISTENERS"
 
-type LimitReqNode struct {
-	excess     int64
-	last       int64
-	query      string
-	count      int64
-	lastSecond int64 //Last second to refresh the excess?
-
-	start        int64 //qps start time by millsecond
-	lastcount    int64 //last count rep num means qps
-	currentcount int64 //repnum in current 1s dperiod
-}
-
-type Ip struct {
-	ip          string
-	mu          sync.Mutex
-	printfinger map[string]*LimitReqNode
-}
-type User struct {
-	user   string
-	iplist map[string]*Ip
-}
 type Server struct {
 	cfg *config.Conf
 
@@ -50,15 +34,44 @@ type Server struct {
 	// users    *userAuth
 	mu *sync.Mutex
 	// users        map[string]*User
-	//qps base on fingerprint
-	fingerprints map[string]*LimitReqNode
-	sessionId    int64
-	//qps base on server
-	qpsOnServer *LimitReqNode
-	listener    net.Listener
-	running     bool
-	restart     bool
-	wg          sync.WaitGroup
+	sessionId int64
+	// listener of the port, nil if only the unix socket is listened
+	listener net.Listener
+	// listener of the unix socket, nil if it is not configured
+	socketListener net.Listener
+	running        bool
+	restart        bool
+	wg             sync.WaitGroup
+
+	// flow control, see flow_control.go
+	fingerprints *limitTable
+	users        *limitTable
+	qpsOnServer  *LimitReqNode
+	rules        atomic.Value
+	// IP access control of the clients, see ip_acl.go
+	acl atomic.Value
+	// SQL firewall of the queries, see firewall.go
+	fw atomic.Value
+	// number of queries waiting for the rate limits, accessed atomically
+	limitWaiting int64
+
+	// listener of the manage port, nil if the admin interface is disabled
+	manageListener net.Listener
+	// all the sessions which passed the handshake, protected by mu
+	sessions map[int64]*Session
+	// the sessions logged in, in total and by user, protected by mu. They
+	// are limited by max_connections of the global config and the users.
+	numConns  int
+	userConns map[string]int
+
+	// TLS and authentication of the client connections, loaded on start
+	tlsConfig  *tls.Config
+	requireTLS bool
+	authPlugin string
+	rsaKey     *rsa.PrivateKey
+
+	// the load balancers allowed to send the PROXY protocol header
+	proxyNetworks []*net.IPNet
 }
 
 func (s *Server) GetSessionId() int64 {
@@ -73,63 +86,89 @@ func NewServer(cfg *config.Conf) (*Server, error) {
 
 	var err error
 
-	s.fingerprints = make(map[string]*LimitReqNode)
-	// s.users = make(map[string]*User)
-	// s.qpsOnServer = &LimitReqNode{}
+	s.fingerprints = newLimitTable()
+	s.users = newLimitTable()
+	s.qpsOnServer = newLimitReqNode("")
+	s.sessions = make(map[int64]*Session)
+	s.userConns = make(map[string]int)
 	s.mu = &sync.Mutex{}
 	s.restart = false
-	port := s.cfg.GetConfig().Global.Port
 	s.sessionId = 0
 
+	if s.tlsConfig, err = s.cfg.GetConfig().Global.ServerTLSConfig(); err != nil {
+		return nil, err
+	}
+	s.requireTLS = s.cfg.GetConfig().Global.RequireTLS
+	switch s.authPlugin = s.cfg.GetConfig().Global.AuthPlugin; s.authPlugin {
+	case "", mysql.AuthNativePassword, mysql.AuthCachingSha2Password:
+	default:
+		return nil, fmt.Errorf("unsupported auth_plugin %s", s.authPlugin)
+	}
+	if s.rsaKey, err = s.cfg.GetConfig().Global.ServerRSAKey(); err != nil {
+		return nil, err
+	}
+	if s.proxyNetworks, err = config.ParseNetworks(s.cfg.GetConfig().Global.ProxyProtocolNetworks); err != nil {
+		return nil, fmt.Errorf("invalid proxy_protocol_networks: %s", err.Error())
+	}
+
 	// get listenfd from file when restart
 	if os.Getenv("_GRACEFUL_RESTART") == "true" {
 		log.Info("graceful restart with previous listenfd")
-
-		//get the linstenfd
-		file := os.NewFile(3, "")
-		s.listener, err = net.FileListener(file)
-		if err != nil {
-			log.Warn("get linstener err ")
-		}
-
+		err = s.inheritListeners()
 	} else {
-		s.listener, err = net.Listen("tcp4", fmt.Sprintf(":%d", port))
+		err = s.listen()
 	}
 	if err != nil {
+		s.closeListeners()
+		return nil, err
+	}
+
+	if err := s.listenManage(); err != nil {
+		s.closeListeners()
 		return nil, err
 	}
 
-	log.Infof("Dbatman Listen(tcp4) at [%d]", port)
 	return s, nil
 }
 
 func (s *Server) Serve() error {
 	log.Debug("this is ddbatman v4")
 	s.running = true
-	// var sessionId int64 = 0
-	for s.running {
+	if s.manageListener != nil {
+		go s.serveManage(s.manageListener)
+	}
 
-		conn, err := s.Accept()
-		if err != nil {
-			log.Warning("accept error %s", err.Error())
+	// the connections of the port and the unix socket are served alike
+	var serving sync.WaitGroup
+	for _, l := range []net.Listener{s.listener, s.socketListener} {
+		if l == nil {
 			continue
 		}
-		//allocate a sessionId for a session
-		go s.onConn(conn)
-		// sessionId += 1
+		serving.Add(1)
+		go func(l net.Listener) {
+			defer serving.Done()
+			s.serveListener(l)
+		}(l)
 	}
+	serving.Wait()
+
 	if s.restart == true {
 		log.Debug("Begin to restart graceful")
-		listenerFile, err := s.listener.(*net.TCPListener).File()
+		files, kinds, err := s.listenerFiles()
 		if err != nil {
 			log.Fatal("Fail to get socket file descriptor:", err)
 		}
-		listenerFd := listenerFile.Fd()
+
+		fds := []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd()}
+		for _, file := range files {
+			fds = append(fds, file.Fd())
+		}
 
 		os.Setenv("_GRACEFUL_RESTART", "true")
+		os.Setenv(gracefulRestartListeners, strings.Join(kinds, ","))
 		execSpec := &syscall.ProcAttr{
 			Env:   os.Environ(),
-			Files: []uintptr{os.Stdin.Fd(), os.Stdout.Fd(), os.Stderr.Fd(), listenerFd},
+			Files: fds,
 		}
 		fork, err := syscall.ForkExec(os.Args[0], os.Args, execSpec)
 		if err != nil {
@@ -155,36 +194,197 @@ func (s *Server) Serve() error {
 	}
 	return nil
 }
-func (s *Server) Accept() (net.Conn, error) {
 
-	conn, err := s.listener.Accept()
+func (s *Server) serveListener(l net.Listener) {
+	for s.running {
+		conn, err := s.accept(l)
+		if err != nil {
+			if s.running {
+				log.Warning("accept error %s", err.Error())
+			}
+			continue
+		}
+		//allocate a sessionId for a session
+		go s.onConn(conn)
+	}
+}
+
+func (s *Server) accept(l net.Listener) (net.Conn, error) {
+
+	conn, err := l.Accept()
 	if err != nil {
 		return nil, err
 	}
 	s.wg.Add(1)
-	startNum += 1
 
 	return conn, nil
 }
 
-// TODO check this function if it need routine-safe
-func (s *Server) Close() {
-	s.running = false
+// listen listens on the port and the unix socket of the config
+func (s *Server) listen() error {
+	global := s.cfg.GetConfig().Global
+
+	if global.Port > 0 {
+		// both of IPv4 and IPv6
+		l, err := net.Listen("tcp", fmt.Sprintf(":%d", global.Port))
+		if err != nil {
+			return err
+		}
+		s.listener = l
+		log.Infof("Dbatman Listen(tcp) at [%d]", global.Port)
+	}
+
+	if global.Socket != "" {
+		if err := removeStaleSocket(global.Socket); err != nil {
+			return err
+		}
+		l, err := net.Listen("unix", global.Socket)
+		if err != nil {
+			return err
+		}
+		s.socketListener = l
+		// every local user may connect, as mysqld does, the users are
+		// authenticated anyway
+		if err := os.Chmod(global.Socket, 0777); err != nil {
+			return err
+		}
+		log.Infof("Dbatman Listen(unix) at [%s]", global.Socket)
+	}
+
+	return nil
+}
+
+// removeStaleSocket removes the socket file left by a process not closed
+// gracefully, the socket of a running process is not touched
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		// let net.Listen report the files which are not sockets
+		return nil
+	}
+
+	if c, err := net.Dial("unix", path); err == nil {
+		c.Close()
+		return fmt.Errorf("unix socket %s is in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// inheritListeners gets the listeners from the file descriptors passed by
+// the previous process on graceful restart
+func (s *Server) inheritListeners() error {
+	// only the port is passed by the versions without the unix socket
+	for i, kind := range strings.Split(os.Getenv(gracefulRestartListeners), ",") {
+		//get the linstenfd
+		file := os.NewFile(uintptr(3+i), "")
+		l, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			log.Warn("get linstener err ")
+			return err
+		}
+
+		switch kind {
+		case "unix":
+			s.socketListener = l
+			log.Infof("Dbatman Listen(unix) at [%s]", l.Addr())
+		default:
+			s.listener = l
+			log.Infof("Dbatman Listen(tcp) at [%s]", l.Addr())
+		}
+	}
+
+	return nil
+}
+
+// listenerFiles returns the file descriptors of the listeners passed to the
+// new process on graceful restart, and their kinds in the same order
+func (s *Server) listenerFiles() ([]*os.File, []string, error) {
+	var files []*os.File
+	var kinds []string
+
+	if s.listener != nil {
+		file, err := s.listener.(*net.TCPListener).File()
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+		kinds = append(kinds, "tcp")
+	}
+
+	if s.socketListener != nil {
+		ul := s.socketListener.(*net.UnixListener)
+		// the socket file is listened by the new process
+		ul.SetUnlinkOnClose(false)
+		file, err := ul.File()
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, file)
+		kinds = append(kinds, "unix")
+	}
+
+	return files, kinds, nil
+}
+
+func (s *Server) closeListeners() {
 	if s.listener != nil {
 		s.listener.Close()
 		s.listener = nil
 	}
+	if s.socketListener != nil {
+		s.socketListener.Close()
+		s.socketListener = nil
+	}
+}
+
+// wakeListeners makes the blocked accepts return without closing the
+// listeners, which are passed to the new process on graceful restart
+func (s *Server) wakeListeners() {
+	for _, l := range []net.Listener{s.listener, s.socketListener} {
+		if dl, ok := l.(interface {
+			SetDeadline(time.Time) error
+		}); ok {
+			dl.SetDeadline(time.Now())
+		}
+	}
+}
+
+// TODO check this function if it need routine-safe
+func (s *Server) Close() {
+	s.running = false
+	s.closeListeners()
+	s.closeManage()
 }
 func (s *Server) Restart() {
 	s.running = false
 	s.restart = true
-	if s.listener != nil {
-		//s.listener.Close()
-		//s.listener = nil
-	}
+	// the new process will listen the manage port again
+	s.closeManage()
+	s.wakeListeners()
+}
+
+// newServerConn wraps the client connection with the TLS and the auth
+// plugins of the server
+func (s *Server) newServerConn(ctx mysql.MySQLServerCtx, c net.Conn) *mysql.MySQLServerConn {
+	fc := mysql.NewMySQLServerConn(ctx, c)
+	fc.SetTLSConfig(s.tlsConfig, s.requireTLS)
+	// validated on start
+	fc.SetAuthPlugin(s.authPlugin)
+	fc.SetRSAKey(s.rsaKey)
+	return fc
 }
 
 func (s *Server) onConn(c net.Conn) {
+	pc, err := s.readProxyHeader(c)
+	if err != nil {
+		log.Warnf("read PROXY protocol header from %s error: %s", c.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+	c = pc
+
 	session := s.newSession(c)
 
 	defer func() {
@@ -198,6 +398,7 @@ func (s *Server) onConn(c net.Conn) {
 		}
 
 		session.Close()
+		s.releaseConn(session)
 	}()
 	// Handshake error, here we do not need to close the conn
 	if err := session.Handshake(); err != nil {
@@ -205,21 +406,147 @@ func (s *Server) onConn(c net.Conn) {
 		return
 	}
 
+	s.addSession(session)
+	defer s.removeSession(session)
+
 	if err := session.Run(); err != nil {
 		// TODO
 
 		// session.WriteError(NewDefaultError(err))
 		session.Close()
+		s.releaseConn(session)
 		if err == errSessionQuit {
 
 			log.Warnf("session %d: %s", session.sessionId, err.Error())
 			// return
 		}
 
-		closeNum += 1
 		s.wg.Done()
-		log.Info("current activity session num is : :", startNum-closeNum)
+		log.Info("current activity session num is : :", s.Connections())
 		log.Infof("session %d closed ,because of %s", session.sessionId, err.Error())
 		return
 	}
 }
+
+func (s *Server) addSession(session *Session) {
+	s.mu.Lock()
+	s.sessions[session.sessionId] = session
+	s.mu.Unlock()
+	activeSessions.WithLabelValues().Inc()
+}
+
+func (s *Server) removeSession(session *Session) {
+	s.mu.Lock()
+	delete(s.sessions, session.sessionId)
+	s.mu.Unlock()
+	activeSessions.WithLabelValues().Dec()
+}
+
+// acquireConn counts the session logged in by the user, it fails if
+// max_connections of the global config or the user is reached
+func (s *Server) acquireConn(session *Session) error {
+	maxConns := session.config.Global.MaxConnections
+	username := session.user.Username
+	maxUserConns := session.user.MaxConnections
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// the session counted already changes the user by COM_CHANGE_USER, its
+	// count is moved to the new user
+	if !session.counted && maxConns > 0 && s.numConns >= maxConns {
+		return mysql.NewDefaultError(mysql.ER_CON_COUNT_ERROR)
+	}
+	sameUser := session.counted && session.countedUser == username
+	if !sameUser && maxUserConns > 0 && s.userConns[username] >= maxUserConns {
+		return mysql.NewDefaultError(mysql.ER_TOO_MANY_USER_CONNECTIONS, username)
+	}
+
+	s.countLocked(session, username)
+	return nil
+}
+
+// restoreConn moves the count of the session back to the user it was counted
+// for, when COM_CHANGE_USER fails after the count is moved to the new user
+func (s *Server) restoreConn(session *Session, username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.countLocked(session, username)
+}
+
+func (s *Server) countLocked(session *Session, username string) {
+	s.uncountLocked(session)
+	s.numConns++
+	s.userConns[username]++
+	session.counted = true
+	session.countedUser = username
+}
+
+// releaseConn uncounts the session when it is closed
+func (s *Server) releaseConn(session *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uncountLocked(session)
+}
+
+// uncountLocked uncounts the session from the user it is counted for, which
+// is not session.user if the user is being changed
+func (s *Server) uncountLocked(session *Session) {
+	if !session.counted {
+		return
+	}
+	session.counted = false
+
+	s.numConns--
+	username := session.countedUser
+	if s.userConns[username]--; s.userConns[username] <= 0 {
+		delete(s.userConns, username)
+	}
+}
+
+// Connections returns the number of the sessions logged in
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.numConns
+}
+
+// UserConnections returns the number of the sessions logged in by the user
+func (s *Server) UserConnections(username string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.userConns[username]
+}
+
+// Sessions returns all the sessions which passed the handshake, ordered by
+// the session id
+func (s *Server) Sessions() []*Session {
+	s.mu.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.Unlock()
+
+	sort.Sort(sessionsById(sessions))
+	return sessions
+}
+
+// KillSession kicks the session with given id out of the proxy
+func (s *Server) KillSession(id int64) error {
+	s.mu.Lock()
+	session, ok := s.sessions[id]
+	s.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("session %d not exists", id)
+	}
+
+	return session.Kill()
+}
+
+type sessionsById []*Session
+
+func (s sessionsById) Len() int           { return len(s) }
+func (s sessionsById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s sessionsById) Less(i, j int) bool { return s[i].sessionId < s[j].sessionId }
@@ -6,6 +6,7 @@ import (
 	"github.com/bytedance/dbatman/database/cluster"
 	"github.com/bytedance/dbatman/database/mysql"
 	"github.com/ngaut/log"
+	"net"
 	"os"
 	"sync"
 	"testing"
@@ -26,7 +27,9 @@ var testConfigData = []byte(`
 global:
   port: 4307
   manage_port: 4308
-  max_connections: 10
+  manage_user: proxy_admin
+  manage_password: proxy_admin_passwd
+  max_connections: 1000
   log_filename: ./log/dbatman.log
   log_level: 31
   log_maxsize: 1024
@@ -189,6 +192,101 @@ func newSqlDB(dsn string) *mysql.DB {
 	return db
 }
 
+func TestClientHost(t *testing.T) {
+	for _, test := range []struct {
+		addr net.Addr
+		host string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 51234}, "10.1.2.3"},
+		{&net.UnixAddr{Name: "/tmp/dbatman.sock", Net: "unix"}, "localhost"},
+		{&net.UnixAddr{Name: "", Net: "unix"}, "localhost"},
+		{nil, "localhost"},
+	} {
+		if host := clientHost(test.addr); host != test.host {
+			t.Fatalf("expect host %s of %v, got %s", test.host, test.addr, host)
+		}
+	}
+}
+
+func TestServer_Connections(t *testing.T) {
+	s := &Server{mu: &sync.Mutex{}, userConns: make(map[string]int)}
+	cfg := &config.ProxyConfig{Global: &config.GlobalConfig{MaxConnections: 3}}
+	a := &config.UserConfig{Username: "a", MaxConnections: 2}
+	b := &config.UserConfig{Username: "b"}
+
+	newSession := func(user *config.UserConfig) *Session {
+		return &Session{server: s, config: cfg, user: user}
+	}
+
+	expectCode := func(err error, code uint16) {
+		if code == 0 && err == nil {
+			return
+		}
+		if e, ok := err.(*mysql.MySQLError); !ok || e.Number != code {
+			t.Fatalf("expect error %d, got %v", code, err)
+		}
+	}
+
+	a1, a2, a3 := newSession(a), newSession(a), newSession(a)
+	expectCode(s.acquireConn(a1), 0)
+	expectCode(s.acquireConn(a2), 0)
+	expectCode(s.acquireConn(a3), mysql.ER_TOO_MANY_USER_CONNECTIONS)
+
+	b1, b2 := newSession(b), newSession(b)
+	expectCode(s.acquireConn(b1), 0)
+	expectCode(s.acquireConn(b2), mysql.ER_CON_COUNT_ERROR)
+
+	// the sessions rejected are not counted
+	s.releaseConn(a3)
+	s.releaseConn(b2)
+	if s.Connections() != 3 || s.UserConnections("a") != 2 {
+		t.Fatalf("expect 3 connections and 2 of a, got %d and %d", s.Connections(), s.UserConnections("a"))
+	}
+
+	// released once
+	s.releaseConn(a1)
+	s.releaseConn(a1)
+	expectCode(s.acquireConn(a3), 0)
+	if s.Connections() != 3 || s.UserConnections("a") != 2 {
+		t.Fatalf("expect 3 connections and 2 of a, got %d and %d", s.Connections(), s.UserConnections("a"))
+	}
+
+	// COM_CHANGE_USER moves the count to the new user, though
+	// max_connections of the global config is reached
+	a3.user = b
+	expectCode(s.acquireConn(a3), 0)
+	if s.Connections() != 3 || s.UserConnections("a") != 1 || s.UserConnections("b") != 2 {
+		t.Fatalf("expect 3 connections, 1 of a and 2 of b, got %d, %d and %d",
+			s.Connections(), s.UserConnections("a"), s.UserConnections("b"))
+	}
+
+	// the count goes back to the old user when the change fails later
+	b1.user = a
+	expectCode(s.acquireConn(b1), 0)
+	s.restoreConn(b1, "b")
+	if s.Connections() != 3 || s.UserConnections("a") != 1 || s.UserConnections("b") != 2 {
+		t.Fatalf("expect 3 connections, 1 of a and 2 of b, got %d, %d and %d",
+			s.Connections(), s.UserConnections("a"), s.UserConnections("b"))
+	}
+
+	expectCode(s.acquireConn(b1), 0)
+
+	// the failed change keeps the count of the old user, which is released
+	// whatever the user of the session is then
+	a3.user = a
+	expectCode(s.acquireConn(a3), mysql.ER_TOO_MANY_USER_CONNECTIONS)
+	if s.Connections() != 3 || s.UserConnections("a") != 2 || s.UserConnections("b") != 1 {
+		t.Fatalf("expect 3 connections, 2 of a and 1 of b, got %d, %d and %d",
+			s.Connections(), s.UserConnections("a"), s.UserConnections("b"))
+	}
+	a3.user = nil
+	s.releaseConn(a3)
+	if s.Connections() != 2 || s.UserConnections("a") != 2 || s.UserConnections("b") != 0 {
+		t.Fatalf("expect 2 connections, 2 of a and none of b, got %d, %d and %d",
+			s.Connections(), s.UserConnections("a"), s.UserConnections("b"))
+	}
+}
+
 func TestMain(m *testing.M) {
 	// Init dbatman_test database
 
@@ -17,7 +17,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/bytedance/dbatman/cmd/version"
 	"github.com/bytedance/dbatman/config"
@@ -40,9 +41,14 @@ type Session struct {
 	bc      *SqlConn
 	fc      *MySQLServerConn
 
-	cliAddr    string //client ip for auth
+	// the session variables replayed on the backend connections
+	vars *SessionVars
+
+	conn       net.Conn // raw client connection, closed to kill the session
+	cliAddr    string   //client ip for auth
 	autoCommit uint
 	sessionId  int64
+	startTime  time.Time
 
 	sqlParserAst map[string]parser.IStatement // store all the query (select\update\insert\delete) opt of seesion group by fingerprint
 	//session status
@@ -50,12 +56,43 @@ type Session struct {
 	txIsolationInDef bool //is the tx isolation level in dafault?
 
 	closed bool
+	// counted in the connections of the server and of countedUser, see
+	// Server.acquireConn
+	counted     bool
+	countedUser string
+
+	// the state shown by SHOW PROXY SESSIONS, published after each command
+	// as the admin reads it from another goroutine, see publishState
+	stateMu sync.Mutex
+	state   sessionState
+
+	// stmtFailed is set when an error packet is written for the statement,
+	// the statements left in a multi-statement query are not run then
+	stmtFailed bool
 
 	// lastcmd uint8
 }
 
 var errSessionQuit error = errors.New("session closed by client")
 
+// clientHost returns the host of the client address, the clients of the unix
+// socket are from localhost as mysqld sees them. The IPv4 clients of the
+// dual-stack listener are in IPv4 rather than ::ffff:a.b.c.d.
+func clientHost(addr net.Addr) string {
+	if addr == nil || addr.Network() == "unix" {
+		return "localhost"
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		return ip.To4().String()
+	}
+	return host
+}
+
 func (s *Server) newSession(conn net.Conn) *Session {
 	session := new(Session)
 	// id := <-sessionChan
@@ -64,10 +101,13 @@ func (s *Server) newSession(conn net.Conn) *Session {
 	session.config = s.cfg.GetConfig()
 	session.salt, _ = RandomBuf(20)
 	session.autoCommit = 0
-	session.cliAddr = strings.Split(conn.RemoteAddr().String(), ":")[0]
+	session.cliAddr = clientHost(conn.RemoteAddr())
 	session.sessionId = id
+	session.startTime = time.Now()
+	session.conn = conn
 	session.txIsolationInDef = true
-	session.fc = NewMySQLServerConn(session, conn)
+	session.vars = NewSessionVars()
+	session.fc = s.newServerConn(session, conn)
 	session.sqlParserAst = make(map[string]parser.IStatement)
 
 	//session.lastcmd = ComQuit
@@ -81,6 +121,8 @@ func (session *Session) Handshake() error {
 		erro := fmt.Errorf("session %d : handshake error: %s", session.sessionId, err.Error())
 		return erro
 	}
+	session.bindCharset()
+	session.publishState()
 
 	return nil
 }
@@ -112,6 +154,7 @@ func (session *Session) Run() error {
 		}
 
 		session.fc.ResetSequence()
+		session.publishState()
 
 		if session.closed {
 			// TODO return MySQL Go Away ?
@@ -153,6 +196,10 @@ func (session *Session) Close() error {
 			log.Info(err.Error)
 		}
 	}
+	if session.bc != nil {
+		// give the connections held by the cursors back to the pool
+		session.bc.closeCursors()
+	}
 	session.fc.Close()
 
 	// session.bc.tx.Exec("set autocommit =0 ")
@@ -171,6 +218,44 @@ func (session *Session) Close() error {
 	return nil
 }
 
+// sessionState is a snapshot of the session for the admin
+type sessionState struct {
+	user, db            string
+	autocommit, inTrans bool
+}
+
+// publishState takes a snapshot of the session, it is called by the session
+// itself
+func (session *Session) publishState() {
+	var state sessionState
+	if session.user != nil {
+		state.user = session.user.Username
+	}
+	if session.cluster != nil {
+		state.db = session.cluster.DBName
+	}
+	state.autocommit = session.isAutoCommit()
+	state.inTrans = session.isInTransaction()
+
+	session.stateMu.Lock()
+	session.state = state
+	session.stateMu.Unlock()
+}
+
+// snapshot returns the state published by the session
+func (session *Session) snapshot() sessionState {
+	session.stateMu.Lock()
+	defer session.stateMu.Unlock()
+	return session.state
+}
+
+// Kill closes the client connection from another goroutine, the session
+// quits when it reads or writes the connection next time.
+func (session *Session) Kill() error {
+	log.Infof("session %d is killed", session.sessionId)
+	return session.conn.Close()
+}
+
 func (session *Session) ServerName() []byte {
 	return hack.Slice(version.Version)
 }
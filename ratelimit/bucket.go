@@ -0,0 +1,106 @@
+// Package ratelimit implements a lock-free token bucket.
+package ratelimit
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Bucket is a token bucket implemented by the generic cell rate algorithm
+// (GCRA). Instead of counting tokens, it keeps the theoretical arrival time
+// of the next request, so taking a token is a single compare-and-swap and
+// the bucket is safe for concurrent use without locks.
+type Bucket struct {
+	// all fields are accessed atomically
+	tat      int64 // theoretical arrival time in nanoseconds
+	interval int64 // nanoseconds per token, <= 0 means unlimited
+	burst    int64
+}
+
+// NewBucket returns a bucket fills rate tokens per second and holds at most
+// burst tokens, a rate <= 0 means unlimited.
+func NewBucket(rate, burst int64) *Bucket {
+	b := new(Bucket)
+	b.SetRate(rate, burst)
+	return b
+}
+
+// SetRate changes the rate and burst of the bucket, the tokens already
+// taken are kept.
+func (b *Bucket) SetRate(rate, burst int64) {
+	var interval int64
+	if rate > 0 {
+		interval = int64(time.Second) / rate
+	}
+
+	if burst < 1 {
+		burst = 1
+	}
+
+	atomic.StoreInt64(&b.interval, interval)
+	atomic.StoreInt64(&b.burst, burst)
+}
+
+// Rate returns the rate and burst of the bucket
+func (b *Bucket) Rate() (rate, burst int64) {
+	if interval := atomic.LoadInt64(&b.interval); interval > 0 {
+		rate = int64(time.Second) / interval
+	}
+	return rate, atomic.LoadInt64(&b.burst)
+}
+
+// Unlimited reports whether the bucket has no rate limit
+func (b *Bucket) Unlimited() bool {
+	return atomic.LoadInt64(&b.interval) <= 0
+}
+
+// Take takes a token if it is available now
+func (b *Bucket) Take() bool {
+	_, ok := b.Reserve(time.Now(), 0)
+	return ok
+}
+
+// Reserve takes a token which will be available after the returned delay.
+// If the delay is longer than maxWait, no token is taken and false is
+// returned with the delay needed. The caller should wait for the delay
+// before going on when true is returned.
+func (b *Bucket) Reserve(now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	interval := atomic.LoadInt64(&b.interval)
+	if interval <= 0 {
+		return 0, true
+	}
+
+	burst := atomic.LoadInt64(&b.burst)
+	n := now.UnixNano()
+
+	for {
+		tat := atomic.LoadInt64(&b.tat)
+
+		t := tat
+		if t < n {
+			t = n
+		}
+		newTat := t + interval
+
+		delay := time.Duration(newTat - n - burst*interval)
+		if delay < 0 {
+			delay = 0
+		}
+
+		if delay > maxWait {
+			return delay, false
+		}
+
+		if atomic.CompareAndSwapInt64(&b.tat, tat, newTat) {
+			return delay, true
+		}
+	}
+}
+
+// Cancel gives back the token taken by Reserve if it is not used, e.g. the
+// request is rejected by another limit
+func (b *Bucket) Cancel() {
+	if interval := atomic.LoadInt64(&b.interval); interval > 0 {
+		atomic.AddInt64(&b.tat, -interval)
+	}
+}
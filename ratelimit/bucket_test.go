@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBucketBurst(t *testing.T) {
+	b := NewBucket(10, 5)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if _, ok := b.Reserve(now, 0); !ok {
+			t.Fatalf("expect token %d in the burst is available", i)
+		}
+	}
+
+	delay, ok := b.Reserve(now, 0)
+	if ok {
+		t.Fatal("expect the bucket is empty after burst")
+	}
+	if delay != 100*time.Millisecond {
+		t.Fatalf("expect delay 100ms, got %s", delay)
+	}
+
+	// one token is filled after 100ms
+	now = now.Add(100 * time.Millisecond)
+	if _, ok := b.Reserve(now, 0); !ok {
+		t.Fatal("expect a token after 100ms")
+	}
+	if _, ok := b.Reserve(now, 0); ok {
+		t.Fatal("expect only one token after 100ms")
+	}
+}
+
+func TestBucketReserveWait(t *testing.T) {
+	b := NewBucket(100, 1)
+	now := time.Now()
+
+	if d, ok := b.Reserve(now, 0); !ok || d != 0 {
+		t.Fatalf("expect first token now, got %s %v", d, ok)
+	}
+
+	if d, ok := b.Reserve(now, 50*time.Millisecond); !ok || d != 10*time.Millisecond {
+		t.Fatalf("expect second token after 10ms, got %s %v", d, ok)
+	}
+
+	if d, ok := b.Reserve(now, 50*time.Millisecond); !ok || d != 20*time.Millisecond {
+		t.Fatalf("expect third token after 20ms, got %s %v", d, ok)
+	}
+
+	// a rejected reservation takes no token
+	if _, ok := b.Reserve(now, 5*time.Millisecond); ok {
+		t.Fatal("expect reservation rejected when wait is too short")
+	}
+	if d, ok := b.Reserve(now, 50*time.Millisecond); !ok || d != 30*time.Millisecond {
+		t.Fatalf("expect fourth token after 30ms, got %s %v", d, ok)
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	b := NewBucket(0, 0)
+	if !b.Unlimited() {
+		t.Fatal("expect unlimited bucket")
+	}
+
+	for i := 0; i < 1000; i++ {
+		if !b.Take() {
+			t.Fatal("unlimited bucket must always have tokens")
+		}
+	}
+
+	b.SetRate(1, 1)
+	if rate, burst := b.Rate(); rate != 1 || burst != 1 {
+		t.Fatalf("expect rate 1 burst 1, got %d %d", rate, burst)
+	}
+	b.Take()
+	if b.Take() {
+		t.Fatal("expect limited after SetRate")
+	}
+}
+
+func TestBucketConcurrent(t *testing.T) {
+	b := NewBucket(1, 100)
+	now := time.Now()
+
+	var taken int64
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if _, ok := b.Reserve(now, 0); ok {
+					atomic.AddInt64(&taken, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if taken != 100 {
+		t.Fatalf("expect exactly burst 100 tokens taken, got %d", taken)
+	}
+}
+
+func TestBucketCancel(t *testing.T) {
+	b := NewBucket(10, 1)
+	now := time.Now()
+
+	if _, ok := b.Reserve(now, 0); !ok {
+		t.Fatal("expect the first token")
+	}
+	b.Cancel()
+	if _, ok := b.Reserve(now, 0); !ok {
+		t.Fatal("expect the token given back")
+	}
+	if _, ok := b.Reserve(now, 0); ok {
+		t.Fatal("expect the bucket is empty")
+	}
+}